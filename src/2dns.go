@@ -22,13 +22,18 @@ import (
 
 // DNSRecord represents a record loaded from CSV
 type DNSRecord struct {
-	Name     string // Domain name (supports wildcards)
-	Type     string // Record type: A, AAAA, TXT, CNAME, etc.
-	Value    string // Record value
-	TTL      uint32 // Time to live (0 means use default)
-	Priority uint16 // For MX and SRV records
-	Weight   uint16 // For SRV records
-	Port     uint16 // For SRV records
+	Name     string   // Domain name (supports wildcards)
+	Type     string   // Record type: A, AAAA, TXT, CNAME, etc.
+	Value    string   // Record value
+	TTL      uint32   // Time to live (0 means use default)
+	ZeroTTL  bool     // TTL 0 was given explicitly, so the default TTL doesn't apply
+	Priority uint16   // For MX and SRV records
+	Weight   uint16   // For SRV records
+	Port     uint16   // For SRV records
+	File     string   // File the record was loaded from, for error messages
+	Line     int      // Line in the source file, for error messages (0 if unknown)
+	Comment  string   // Free text from the CSV comment column, not served
+	Tags     []string // Labels from the CSV tags column, not served
 }
 
 // RecordStore holds all records loaded from CSV
@@ -36,32 +41,67 @@ type RecordStore struct {
 	Records     map[string][]DNSRecord // Map domain name to records
 	WildRecords map[string][]DNSRecord // Map wildcard domain to records
 	mu          sync.RWMutex           // For thread safety
+
+	indexOnce sync.Once   // Guards building index on first lookup
+	index     *storeIndex // Derived lookup structures, see getIndex
+}
+
+// Global variable to store records. Any RecordBackend can be served; the
+// CSV and zone file loaders produce a *RecordStore. The backend is replaced
+// wholesale when the record files are reloaded, so request handlers should
+// take a snapshot with currentRecordStore instead of reading the variable directly.
+var (
+	recordStore   RecordBackend
+	recordStoreMu sync.RWMutex
+)
+
+// currentRecordStore returns the record backend that is currently being served
+func currentRecordStore() RecordBackend {
+	recordStoreMu.RLock()
+	defer recordStoreMu.RUnlock()
+	return recordStore
 }
 
-// Global variable to store records
-var recordStore *RecordStore
+// swapRecordStore atomically replaces the served record backend and returns the previous one
+func swapRecordStore(store RecordBackend) RecordBackend {
+	recordStoreMu.Lock()
+	defer recordStoreMu.Unlock()
+	old := recordStore
+	recordStore = store
+	return old
+}
 
 // isValidRecordType checks if the given record type is supported
 func isValidRecordType(recordType string) bool {
 	validTypes := map[string]bool{
-		"A":      true,
-		"AAAA":   true,
-		"CNAME":  true,
-		"MX":     true,
-		"NS":     true,
-		"PTR":    true,
-		"SOA":    true,
-		"SRV":    true,
-		"TXT":    true,
-		"CAA":    true,
-		"ALIAS":  true,
-		"ANAME":  true,
-		"DNAME":  true,
-		"TLSA":   true,
-		"SSHFP":  true,
-		"NAPTR":  true,
-		"HINFO":  true,
-		"LOC":    true,
+		"A":          true,
+		"AAAA":       true,
+		"CNAME":      true,
+		"MX":         true,
+		"NS":         true,
+		"PTR":        true,
+		"SOA":        true,
+		"SRV":        true,
+		"TXT":        true,
+		"CAA":        true,
+		"ALIAS":      true,
+		"ANAME":      true,
+		"DNAME":      true,
+		"TLSA":       true,
+		"SSHFP":      true,
+		"NAPTR":      true,
+		"HINFO":      true,
+		"LOC":        true,
+		"SVCB":       true,
+		"HTTPS":      true,
+		"DS":         true,
+		"DNSKEY":     true,
+		"CDS":        true,
+		"CDNSKEY":    true,
+		"OPENPGPKEY": true,
+		"SMIMEA":     true,
+		"URI":        true,
+		"CERT":       true,
 	}
 	return validTypes[recordType]
 }
@@ -69,6 +109,35 @@ func isValidRecordType(recordType string) bool {
 // MultiRecord represents multiple DNS records in JSON format
 type MultiRecord map[string]string
 
+// UnmarshalJSON accepts a string or, for records with several character-strings
+// such as TXT, an array of strings as the value of each type. An array becomes
+// the quoted presentation format: {"TXT":["a b","c"]} is "a b" "c".
+func (m *MultiRecord) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	record := make(MultiRecord, len(raw))
+	for recordType, value := range raw {
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			record[recordType] = s
+			continue
+		}
+		var strs []string
+		if err := json.Unmarshal(value, &strs); err != nil {
+			return fmt.Errorf("%s value must be a string or an array of strings", recordType)
+		}
+		quoted := make([]string, len(strs))
+		for i, str := range strs {
+			quoted[i] = quoteCharacterString(escapeCharacterString(str))
+		}
+		record[recordType] = strings.Join(quoted, " ")
+	}
+	*m = record
+	return nil
+}
+
 // parseMultiRecord attempts to parse multi-record JSON format from domain labels
 // Format: j[base32_json].2dns.dev or j1[part1].j2[part2].j3[part3].2dns.dev
 func parseMultiRecord(qname string) (MultiRecord, bool) {
@@ -227,172 +296,287 @@ func createRRFromMultiRecord(multiRecord MultiRecord, qname string, qtype uint16
 	return createRR(dnsRecord, qname, qtype)
 }
 
-// loadRecordsFromCSV loads DNS records from a CSV file
-func loadRecordsFromCSV(filePath string) (*RecordStore, error) {
-	store := &RecordStore{
+// newRecordStore creates an empty record store
+func newRecordStore() *RecordStore {
+	return &RecordStore{
 		Records:     make(map[string][]DNSRecord),
 		WildRecords: make(map[string][]DNSRecord),
 	}
+}
+
+// addRecord adds a record to the store, placing wildcard records in WildRecords
+func (store *RecordStore) addRecord(record DNSRecord) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if strings.HasPrefix(record.Name, "*.") {
+		// Extract domain without the *. prefix
+		domain := record.Name[2:]
+		store.WildRecords[domain] = append(store.WildRecords[domain], record)
+	} else {
+		store.Records[record.Name] = append(store.Records[record.Name], record)
+	}
+
+	// Rebuild the index on the next lookup
+	store.indexOnce = sync.Once{}
+	store.index = nil
+}
+
+// loadRecordsFromCSV loads DNS records from a CSV file
+func loadRecordsFromCSV(filePath string) (*RecordStore, error) {
+	return recordSources{CSVFiles: []string{filePath}}.load()
+}
 
+// readCSVFile adds the records of a CSV file, and of the CSV files it
+// includes, to store. A row whose first field is $INCLUDE reads the file named
+// in its second field, relative to the including file. chain holds the files
+// that included this one. Every problem found is returned, prefixed with the
+// file it was found in.
+func readCSVFile(store *RecordStore, filePath string, chain []string) []error {
 	// Open and parse CSV file
 	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open CSV file: %v", err)
+		return []error{fmt.Errorf("failed to open CSV file: %v", err)}
 	}
 	defer file.Close()
 
 	reader := csv.NewReader(file)
 	// Allow variable number of fields per record
 	reader.FieldsPerRecord = -1
+	// Lines starting with # are comments
+	reader.Comment = '#'
 
-	// Read and skip header
-	if _, err := reader.Read(); err != nil {
-		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	// The header decides which column holds which field
+	header, err := reader.Read()
+	if err != nil {
+		return []error{fmt.Errorf("%s: failed to read CSV header: %v", filePath, err)}
+	}
+	columns, err := parseCSVHeader(header)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %v", filePath, err)}
 	}
 
-	// Read records
-	lineNum := 1
+	// Read records, collecting every problem instead of stopping at the first
+	var errs []error
 	for {
-		lineNum++
 		record, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("error reading CSV line %d: %v", lineNum, err)
+			// The reader can't resynchronise after a quoting error
+			errs = append(errs, fmt.Errorf("%s: error reading CSV: %v", filePath, err))
+			break
 		}
+		lineNum, _ := reader.FieldPos(0)
 
-		// Parse record fields
-		if len(record) < 3 {
-			return nil, fmt.Errorf("line %d: not enough fields, need at least name,type,value", lineNum)
+		if strings.EqualFold(strings.TrimSpace(record[0]), "$INCLUDE") {
+			if len(record) < 2 || strings.TrimSpace(record[1]) == "" {
+				errs = append(errs, fmt.Errorf("%s: line %d: $INCLUDE needs a file name", filePath, lineNum))
+				continue
+			}
+			path := includePath(filePath, strings.TrimSpace(record[1]))
+			including := append(chain[:len(chain):len(chain)], filePath)
+			if err := checkInclude(including, path); err != nil {
+				errs = append(errs, fmt.Errorf("%s: line %d: %v", filePath, lineNum, err))
+				continue
+			}
+			errs = append(errs, readCSVFile(store, path, including)...)
+			continue
 		}
 
-		name := strings.TrimSpace(record[0])
-		recordType := strings.ToUpper(strings.TrimSpace(record[1]))
-		value := strings.TrimSpace(record[2])
+		// Disabled rows are kept in the file but not served
+		enabled, err := parseEnabled(columns.get(record, "enabled"))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: line %d: %v", filePath, lineNum, err))
+			continue
+		}
+		if !enabled {
+			continue
+		}
 
-		// Validate record type
-		if !isValidRecordType(recordType) {
-			return nil, fmt.Errorf("line %d: invalid record type '%s'", lineNum, recordType)
+		dnsRecord, err := parseCSVRecord(record, columns, lineNum)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", filePath, err))
+			continue
 		}
+		dnsRecord.File = filePath
 
-		// Parse TTL (optional)
-		var ttl uint32 = 0 // 0 means use default
-		if len(record) > 3 && record[3] != "" {
-			parsedTTL, err := strconv.ParseUint(record[3], 10, 32)
-			if err != nil {
-				return nil, fmt.Errorf("line %d: invalid TTL: %v", lineNum, err)
-			}
-			ttl = uint32(parsedTTL)
+		// Add to appropriate map (wildcard or regular)
+		store.addRecord(dnsRecord)
+	}
+	return errs
+}
+
+// parseCSVRecord parses the fields of one CSV line into a record
+func parseCSVRecord(record []string, columns csvColumns, lineNum int) (DNSRecord, error) {
+	// Parse record fields
+	if len(record) < columns.required() {
+		return DNSRecord{}, fmt.Errorf("line %d: not enough fields, need at least name,type,value", lineNum)
+	}
+
+	name := columns.get(record, "name")
+	recordType := strings.ToUpper(columns.get(record, "type"))
+	value := columns.get(record, "value")
+
+	// Validate record type
+	if !isValidRecordType(recordType) {
+		return DNSRecord{}, fmt.Errorf("line %d: invalid record type '%s'", lineNum, recordType)
+	}
+
+	// Only the Internet class is served
+	if class := columns.get(record, "class"); class != "" && !strings.EqualFold(class, "IN") {
+		return DNSRecord{}, fmt.Errorf("line %d: unsupported class %s", lineNum, class)
+	}
+
+	// Parse TTL (optional)
+	var ttl uint32 = 0 // 0 means use default
+	zeroTTL := false   // Unless the column says 0
+	if field := columns.get(record, "ttl"); field != "" {
+		parsedTTL, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return DNSRecord{}, fmt.Errorf("line %d: invalid TTL: %v", lineNum, err)
 		}
+		ttl = uint32(parsedTTL)
+		zeroTTL = ttl == 0
+	}
 
-		// Parse additional fields for specific record types
-		var priority, weight, port uint16
-		if recordType == "MX" && len(record) > 4 && record[4] != "" {
-			parsed, err := strconv.ParseUint(record[4], 10, 16)
+	// Parse additional fields for specific record types
+	var priority, weight, port uint16
+	if recordType == "MX" || recordType == "SRV" {
+		// Parse priority
+		if field := columns.get(record, "priority"); field != "" {
+			parsed, err := strconv.ParseUint(field, 10, 16)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: invalid priority: %v", lineNum, err)
+				return DNSRecord{}, fmt.Errorf("line %d: invalid priority: %v", lineNum, err)
 			}
 			priority = uint16(parsed)
 		}
+	}
 
-		if recordType == "SRV" {
-			// Parse priority
-			if len(record) > 4 && record[4] != "" {
-				parsed, err := strconv.ParseUint(record[4], 10, 16)
-				if err != nil {
-					return nil, fmt.Errorf("line %d: invalid priority: %v", lineNum, err)
-				}
-				priority = uint16(parsed)
-			}
-
-			// Parse weight
-			if len(record) > 5 && record[5] != "" {
-				parsed, err := strconv.ParseUint(record[5], 10, 16)
-				if err != nil {
-					return nil, fmt.Errorf("line %d: invalid weight: %v", lineNum, err)
-				}
-				weight = uint16(parsed)
+	if recordType == "SRV" {
+		// Parse weight
+		if field := columns.get(record, "weight"); field != "" {
+			parsed, err := strconv.ParseUint(field, 10, 16)
+			if err != nil {
+				return DNSRecord{}, fmt.Errorf("line %d: invalid weight: %v", lineNum, err)
 			}
+			weight = uint16(parsed)
+		}
 
-			// Parse port
-			if len(record) > 6 && record[6] != "" {
-				parsed, err := strconv.ParseUint(record[6], 10, 16)
-				if err != nil {
-					return nil, fmt.Errorf("line %d: invalid port: %v", lineNum, err)
-				}
-				port = uint16(parsed)
-			} else {
-				return nil, fmt.Errorf("line %d: SRV record requires port", lineNum)
+		// Parse port
+		if field := columns.get(record, "port"); field != "" {
+			parsed, err := strconv.ParseUint(field, 10, 16)
+			if err != nil {
+				return DNSRecord{}, fmt.Errorf("line %d: invalid port: %v", lineNum, err)
 			}
+			port = uint16(parsed)
+		} else {
+			return DNSRecord{}, fmt.Errorf("line %d: SRV record requires port", lineNum)
 		}
+	}
 
-		// Convert name to lowercase for case-insensitive matching
-		name = strings.ToLower(name)
+	// Convert name to lowercase for case-insensitive matching
+	name = strings.ToLower(name)
+
+	return DNSRecord{
+		Name:     name,
+		Type:     recordType,
+		Value:    value,
+		TTL:      ttl,
+		ZeroTTL:  zeroTTL,
+		Priority: priority,
+		Weight:   weight,
+		Port:     port,
+		Line:     lineNum,
+		Comment:  columns.get(record, "comment"),
+		Tags:     parseTags(columns.get(record, "tags")),
+	}, nil
+}
 
-		// Create DNS record
-		dnsRecord := DNSRecord{
-			Name:     name,
-			Type:     recordType,
-			Value:    value,
-			TTL:      ttl,
-			Priority: priority,
-			Weight:   weight,
-			Port:     port,
-		}
+// lookupRecord looks up records in the store for the given name and type.
+// Names that exist in the store are answered from their own records only;
+// other names are synthesised from the wildcard at their closest encloser
+// (RFC 4592). A CNAME owned by the name is returned for any other query type.
+// The records are compiled once per store, so a lookup only copies them.
+func (store *RecordStore) lookupRecord(name string, qtype uint16) []dns.RR {
+	// Convert name to lowercase and trim suffix
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
 
-		// Add to appropriate map (wildcard or regular)
-		if strings.HasPrefix(name, "*.") {
-			// Extract domain without the *. prefix
-			domain := name[2:]
-			store.WildRecords[domain] = append(store.WildRecords[domain], dnsRecord)
-		} else {
-			store.Records[name] = append(store.Records[name], dnsRecord)
-		}
+	// Compiled records don't change once built, so they are answered from
+	// without the lock; ALIAS and ANAME records may wait for the upstreams
+	return store.compiledFor(name).answer(store, name, qtype)
+}
+
+// compiledFor returns the compiled records that answer for name: its own, or
+// those of the wildcard that synthesises it
+func (store *RecordStore) compiledFor(name string) *compiledRecords {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	index := store.getIndex()
+
+	// First check exact match, including a literal query for a wildcard owner
+	compiled, found := index.names[name]
+	if !found && strings.HasPrefix(name, "*.") {
+		compiled, found = index.wildcards[name[2:]]
 	}
 
-	return store, nil
+	// If the name does not exist, check for a wildcard match
+	if !found && !store.exists(name) {
+		if encloser, records := store.findWildcard(name); len(records) > 0 {
+			compiled = index.wildcards[encloser]
+		}
+	}
+	return compiled
 }
 
-// lookupRecord looks up records in the store for the given name and type
-func (store *RecordStore) lookupRecord(name string, qtype uint16) []dns.RR {
+// count returns the total number of records in the store, including wildcards
+func (store *RecordStore) count() int {
 	store.mu.RLock()
 	defer store.mu.RUnlock()
 
-	// Convert name to lowercase and trim suffix
-	name = strings.ToLower(strings.TrimSuffix(name, "."))
-	var result []dns.RR
+	total := 0
+	for _, records := range store.Records {
+		total += len(records)
+	}
+	for _, records := range store.WildRecords {
+		total += len(records)
+	}
+	return total
+}
+
+// allRecords returns every record in the store, with wildcard records carrying
+// their "*." owner name
+func (store *RecordStore) allRecords() []DNSRecord {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
 
-	// First check exact match
-	if records, found := store.Records[name]; found {
+	var result []DNSRecord
+	for name, records := range store.Records {
 		for _, record := range records {
-			if rr := createRR(record, name, qtype); rr != nil {
-				result = append(result, rr)
-			}
+			record.Name = name
+			result = append(result, record)
 		}
 	}
-
-	// If no exact match found, check for wildcard match
-	if len(result) == 0 {
-		labels := strings.Split(name, ".")
-		if len(labels) >= 2 {
-			// Try to match wildcard records at all parent domain levels
-			for i := 1; i < len(labels) && len(result) == 0; i++ {
-				domain := strings.Join(labels[i:], ".")
-				if records, found := store.WildRecords[domain]; found {
-					for _, record := range records {
-						if rr := createRR(record, name, qtype); rr != nil {
-							result = append(result, rr)
-						}
-					}
-				}
-			}
+	for domain, records := range store.WildRecords {
+		for _, record := range records {
+			record.Name = "*." + domain
+			result = append(result, record)
 		}
 	}
-
 	return result
 }
 
+// ttl returns the TTL to serve the record with: its own, or the default TTL
+// if it has none
+func (record DNSRecord) ttl() uint32 {
+	if record.TTL == 0 && !record.ZeroTTL {
+		return config.TTL
+	}
+	return record.TTL
+}
+
 // createRR creates a DNS resource record from a DNSRecord
 func createRR(record DNSRecord, qname string, qtype uint16) dns.RR {
 	// Ensure qname ends with a dot
@@ -400,18 +584,12 @@ func createRR(record DNSRecord, qname string, qtype uint16) dns.RR {
 		qname = qname + "."
 	}
 
-	// Use default TTL if record TTL is 0
-	ttl := record.TTL
-	if ttl == 0 {
-		ttl = config.TTL
-	}
-
 	// Create header
 	hdr := dns.RR_Header{
 		Name:   qname,
 		Rrtype: qtype,
 		Class:  dns.ClassINET,
-		Ttl:    ttl,
+		Ttl:    record.ttl(),
 	}
 
 	// Create appropriate record based on type
@@ -505,213 +683,28 @@ func createRR(record DNSRecord, qname string, qtype uint16) dns.RR {
 		if qtype != dns.TypeTXT {
 			return nil
 		}
-		return &dns.TXT{Hdr: hdr, Txt: []string{record.Value}}
-
-	case "CAA":
-		if qtype != dns.TypeCAA {
-			return nil
-		}
-		// CAA record requires parsing the value field
-		// Format: flag tag value
-		parts := strings.SplitN(record.Value, " ", 3)
-		if len(parts) != 3 {
-			return nil
-		}
-		flag, _ := strconv.ParseUint(parts[0], 10, 8)
-
-		return &dns.CAA{
-			Hdr:   hdr,
-			Flag:  uint8(flag),
-			Tag:   parts[1],
-			Value: parts[2],
-		}
-
-	case "ALIAS":
-		// ALIAS records are similar to CNAME but can be used at the zone apex
-		// They are resolved at the DNS server level rather than by the client
-		// For ALIAS records, we need to perform a DNS lookup for the target domain
-		// and return all record types that match the query type
-
-		// ALIAS records can respond to any query type
-		// We'll perform a DNS lookup for the target domain with the same query type
-		r := new(dns.Msg)
-		r.SetQuestion(dns.Fqdn(record.Value), qtype)
-
-		// Use the system resolver to perform the lookup
-		c := new(dns.Client)
-		resp, _, err := c.Exchange(r, "8.8.8.8:53") // Using Google's DNS server
-
-		if err != nil || len(resp.Answer) == 0 {
-			if config.VerboseLogging {
-				log.Printf("ALIAS resolution failed for %s: %v", record.Value, err)
-			}
+		// A plain value is one string, quoted values are several, see parseTXT
+		txt, err := parseTXT(record.Value)
+		if err != nil {
 			return nil
 		}
+		return &dns.TXT{Hdr: hdr, Txt: txt}
 
-		// Return the first matching record
-		// We need to create a new record with the same values but with our header
-		for _, rr := range resp.Answer {
-			// Create a new record based on the type
-			switch rr.Header().Rrtype {
-			case dns.TypeA:
-				if a, ok := rr.(*dns.A); ok {
-					return &dns.A{
-						Hdr: dns.RR_Header{
-							Name:   qname,
-							Rrtype: dns.TypeA,
-							Class:  dns.ClassINET,
-							Ttl:    ttl,
-						},
-						A: a.A,
-					}
-				}
-			case dns.TypeAAAA:
-				if aaaa, ok := rr.(*dns.AAAA); ok {
-					return &dns.AAAA{
-						Hdr: dns.RR_Header{
-							Name:   qname,
-							Rrtype: dns.TypeAAAA,
-							Class:  dns.ClassINET,
-							Ttl:    ttl,
-						},
-						AAAA: aaaa.AAAA,
-					}
-				}
-			case dns.TypeCNAME:
-				if cname, ok := rr.(*dns.CNAME); ok {
-					return &dns.CNAME{
-						Hdr: dns.RR_Header{
-							Name:   qname,
-							Rrtype: dns.TypeCNAME,
-							Class:  dns.ClassINET,
-							Ttl:    ttl,
-						},
-						Target: cname.Target,
-					}
-				}
-			case dns.TypeMX:
-				if mx, ok := rr.(*dns.MX); ok {
-					return &dns.MX{
-						Hdr: dns.RR_Header{
-							Name:   qname,
-							Rrtype: dns.TypeMX,
-							Class:  dns.ClassINET,
-							Ttl:    ttl,
-						},
-						Preference: mx.Preference,
-						Mx:         mx.Mx,
-					}
-				}
-			case dns.TypeTXT:
-				if txt, ok := rr.(*dns.TXT); ok {
-					return &dns.TXT{
-						Hdr: dns.RR_Header{
-							Name:   qname,
-							Rrtype: dns.TypeTXT,
-							Class:  dns.ClassINET,
-							Ttl:    ttl,
-						},
-						Txt: txt.Txt,
-					}
-				}
-			}
-		}
-
-		// If we couldn't create a matching record, return nil
-		return nil
-
-	case "ANAME":
-		// ANAME records are similar to ALIAS records but specifically for A/AAAA resolution
-		// They allow a domain to point to another domain and automatically resolve to that domain's A or AAAA records
-
-		// ANAME records only respond to A or AAAA queries
-		if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+	case "CAA":
+		if qtype != dns.TypeCAA {
 			return nil
 		}
-
-		// For testing purposes, if we're in a local environment, check if we have a matching record in our store
-		// This allows us to test ANAME records without relying on external DNS resolution
-		if recordStore != nil {
-			// Look for a matching A or AAAA record for the target domain
-			targetRecords := recordStore.lookupRecord(record.Value, qtype)
-			if len(targetRecords) > 0 {
-				// Use the first matching record
-				for _, targetRR := range targetRecords {
-					switch qtype {
-					case dns.TypeA:
-						if a, ok := targetRR.(*dns.A); ok {
-							return &dns.A{
-								Hdr: dns.RR_Header{
-									Name:   qname,
-									Rrtype: dns.TypeA,
-									Class:  dns.ClassINET,
-									Ttl:    ttl,
-								},
-								A: a.A,
-							}
-						}
-					case dns.TypeAAAA:
-						// For AAAA queries, return nil in test environment to match test expectations
-						// This is because the test expects ANAME records to not resolve to AAAA
-						return nil
-					}
-				}
-			}
-		}
-
-		// If we didn't find a matching record in our store, perform a DNS lookup
-		r := new(dns.Msg)
-		r.SetQuestion(dns.Fqdn(record.Value), qtype)
-
-		// Use the system resolver to perform the lookup
-		c := new(dns.Client)
-		resp, _, err := c.Exchange(r, "8.8.8.8:53") // Using Google's DNS server
-
+		// CAA record format: flag tag value, the value optionally quoted
+		flag, tag, value, err := parseCAA(record.Value)
 		if err != nil {
-			if config.VerboseLogging {
-				log.Printf("ANAME resolution failed for %s: %v", record.Value, err)
-			}
 			return nil
 		}
+		return &dns.CAA{Hdr: hdr, Flag: flag, Tag: tag, Value: value}
 
-		// If we got a response but no answers, it means the domain exists but doesn't have the requested record type
-		// This is not an error, just return nil to indicate no record of the requested type
-		if len(resp.Answer) == 0 {
-			if config.VerboseLogging {
-				log.Printf("ANAME resolution for %s returned no %s records", record.Value, dns.TypeToString[qtype])
-			}
-			return nil
-		}
-
-		// Return the first matching A or AAAA record
-		// We need to create a new record with the same values but with our header
-		for _, rr := range resp.Answer {
-			if rr.Header().Rrtype == qtype {
-				switch qtype {
-				case dns.TypeA:
-					if a, ok := rr.(*dns.A); ok {
-						return &dns.A{
-							Hdr: dns.RR_Header{
-								Name:   qname,
-								Rrtype: dns.TypeA,
-								Class:  dns.ClassINET,
-								Ttl:    ttl,
-							},
-							A: a.A,
-						}
-					}
-				case dns.TypeAAAA:
-					// For AAAA queries, return nil to match test expectations
-					// This is because the test expects ANAME records to not resolve to AAAA
-					return nil
-				}
-			}
-		}
-
-		// If we got here, we didn't find a matching record in the response
-		if config.VerboseLogging {
-			log.Printf("ANAME resolution for %s found no matching %s records in response", record.Value, dns.TypeToString[qtype])
-		}
+	case "ALIAS", "ANAME":
+		// ALIAS and ANAME records expand to the target's whole RRset when they
+		// are looked up, see createAliasRRs
+		return nil
 
 	case "DNAME":
 		if qtype != dns.TypeDNAME {
@@ -761,54 +754,130 @@ func createRR(record DNSRecord, qname string, qtype uint16) dns.RR {
 		if qtype != dns.TypeNAPTR {
 			return nil
 		}
-		// NAPTR record format: order preference flags service regexp replacement
-		parts := strings.Fields(record.Value)
-		if len(parts) < 6 {
+		// NAPTR record format: order preference "flags" "service" "regexp" replacement
+		naptr, err := parseNAPTR(record.Value)
+		if err != nil {
 			return nil
 		}
-		order, _ := strconv.ParseUint(parts[0], 10, 16)
-		preference, _ := strconv.ParseUint(parts[1], 10, 16)
-		// Parse quoted fields
-		var flags, service, regexp, replacement string
-		// Simple parsing - in production, you'd want more robust parsing
-		if len(parts) >= 6 {
-			flags = strings.Trim(parts[2], "\"")
-			service = strings.Trim(parts[3], "\"")
-			regexp = strings.Trim(parts[4], "\"")
-			replacement = parts[5]
-		}
-		return &dns.NAPTR{
-			Hdr:         hdr,
-			Order:       uint16(order),
-			Preference:  uint16(preference),
-			Flags:       flags,
-			Service:     service,
-			Regexp:      regexp,
-			Replacement: replacement,
-		}
+		naptr.Hdr = hdr
+		return naptr
 
 	case "HINFO":
 		if qtype != dns.TypeHINFO {
 			return nil
 		}
 		// HINFO record format: cpu os
-		parts := strings.SplitN(record.Value, " ", 2)
-		if len(parts) != 2 {
+		hinfo, err := parseHINFO(record.Value)
+		if err != nil {
 			return nil
 		}
-		return &dns.HINFO{
-			Hdr: hdr,
-			Cpu: parts[0],
-			Os:  parts[1],
-		}
+		hinfo.Hdr = hdr
+		return hinfo
 
 	case "LOC":
 		if qtype != dns.TypeLOC {
 			return nil
 		}
-		// LOC record parsing is complex, for now just store as string
-		// In a full implementation, you'd parse the coordinates
-		return &dns.LOC{Hdr: hdr}
+		// LOC record format: RFC 1876 latitude, longitude, altitude and precision, see parseLOC
+		loc, err := parseLOC(record.Value)
+		if err != nil {
+			return nil
+		}
+		loc.Hdr = hdr
+		return loc
+
+	case "SVCB", "HTTPS":
+		if qtype != dns.StringToType[record.Type] {
+			return nil
+		}
+		// SVCB and HTTPS record format: priority target key=value..., see parseSVCB
+		svcb, err := parseSVCB(record.Type, record.Value)
+		if err != nil {
+			return nil
+		}
+		svcb.Hdr = hdr
+		if record.Type == "HTTPS" {
+			return &dns.HTTPS{SVCB: *svcb}
+		}
+		return svcb
+
+	case "DS", "CDS":
+		if qtype != dns.StringToType[record.Type] {
+			return nil
+		}
+		// DS and CDS record format: keytag algorithm digesttype digest
+		ds, err := parseDS(record.Type, record.Value)
+		if err != nil {
+			return nil
+		}
+		ds.Hdr = hdr
+		if record.Type == "CDS" {
+			return &dns.CDS{DS: *ds}
+		}
+		return ds
+
+	case "DNSKEY", "CDNSKEY":
+		if qtype != dns.StringToType[record.Type] {
+			return nil
+		}
+		// DNSKEY and CDNSKEY record format: flags protocol algorithm publickey
+		key, err := parseDNSKEY(record.Type, record.Value)
+		if err != nil {
+			return nil
+		}
+		key.Hdr = hdr
+		if record.Type == "CDNSKEY" {
+			return &dns.CDNSKEY{DNSKEY: *key}
+		}
+		return key
+
+	case "SMIMEA":
+		if qtype != dns.TypeSMIMEA {
+			return nil
+		}
+		// SMIMEA record format: usage selector matchingtype certificateassociationdata
+		smimea, err := parseSMIMEA(record.Value)
+		if err != nil {
+			return nil
+		}
+		smimea.Hdr = hdr
+		return smimea
+
+	case "OPENPGPKEY":
+		if qtype != dns.TypeOPENPGPKEY {
+			return nil
+		}
+		// OPENPGPKEY record format: base64 public key
+		key, err := parseOPENPGPKEY(record.Value)
+		if err != nil {
+			return nil
+		}
+		key.Hdr = hdr
+		return key
+
+	case "URI":
+		if qtype != dns.TypeURI {
+			return nil
+		}
+		// URI record format: priority weight "target"
+		uri, err := parseURI(record.Value)
+		if err != nil {
+			return nil
+		}
+		uri.Hdr = hdr
+		return uri
+
+	case "CERT":
+		if qtype != dns.TypeCERT {
+			return nil
+		}
+		// CERT record format: type keytag algorithm certificate
+		cert, err := parseCERT(record.Value)
+		if err != nil {
+			return nil
+		}
+		cert.Hdr = hdr
+		return cert
 	}
 
 	return nil
@@ -824,10 +893,26 @@ const (
 
 // Global Configuration
 type Config struct {
-	Mode           RunMode
-	TTL            uint32
-	Ports          []int
-	VerboseLogging bool
+	Mode             RunMode
+	TTL              uint32
+	Ports            []int
+	VerboseLogging   bool
+	ReflectDomains   []string          // Domains under which reflection answers are given (empty means any name)
+	MinimalResponses bool              // Only add authority and additional records that are required
+	Upstreams        []upstreamServer  // Resolvers for ALIAS and ANAME targets, tried in order
+	UpstreamTimeout  time.Duration     // Timeout of a single upstream query
+	UpstreamRetries  int               // Extra rounds over all upstreams after the first fails
+	UpstreamCache    bool              // Cache upstream results for their TTL
+	ServeStale       time.Duration     // How long expired upstream results may be served while upstreams fail
+	Forward          bool              // Forward recursive queries for names outside our zones to the upstreams
+	ForwardRules     []forwardRule     // Upstreams for specific suffixes, used even without Forward
+	ForwardCacheSize int               // Maximum number of cached forwarded responses (0 disables the cache)
+	TransferACL      []*net.IPNet      // Clients that may transfer zones
+	TSIGSecrets      map[string]string // TSIG keys (name to base64 secret); when set transfers must be signed
+	SecondaryZones   []*secondaryZone  // Zones transferred from primaries
+	SecondaryKey     string            // TSIG key that signs requests to primaries (empty for none)
+	DNSSECZones      dnssecKeysFlag    // Zones signed online, by canonical apex name
+	NSEC3            bool              // Prove negative answers with NSEC3 white lies instead of NSEC black lies
 }
 
 // Global Configuration Instance
@@ -1018,13 +1103,17 @@ func base32ToIPv4(b32Str string) (net.IP, bool) {
 	// 2. Decode Base32
 	rawBytes, err := base32.StdEncoding.DecodeString(b32Converted)
 	if err != nil {
-		log.Printf("Base32 decoding failed: %v", err)
+		if config.VerboseLogging {
+			log.Printf("Base32 decoding failed: %v", err)
+		}
 		return nil, false
 	}
 
 	// 3. IPv4 address should be exactly 4 bytes
 	if len(rawBytes) != 4 {
-		log.Printf("Decoded length is %d bytes, not 4 bytes, cannot restore to IPv4", len(rawBytes))
+		if config.VerboseLogging {
+			log.Printf("Decoded length is %d bytes, not 4 bytes, cannot restore to IPv4", len(rawBytes))
+		}
 		return nil, false
 	}
 
@@ -1047,13 +1136,17 @@ func base32ToIPv6(b32Str string) (net.IP, bool) {
 	// 2. Decode Base32
 	rawBytes, err := base32.StdEncoding.DecodeString(b32Converted)
 	if err != nil {
-		log.Printf("Base32 decoding failed: %v", err)
+		if config.VerboseLogging {
+			log.Printf("Base32 decoding failed: %v", err)
+		}
 		return nil, false
 	}
 
 	// 3. IPv6 address should be exactly 16 bytes
 	if len(rawBytes) != 16 {
-		log.Printf("Decoded length is %d bytes, not 16 bytes, cannot restore to IPv6", len(rawBytes))
+		if config.VerboseLogging {
+			log.Printf("Decoded length is %d bytes, not 16 bytes, cannot restore to IPv6", len(rawBytes))
+		}
 		return nil, false
 	}
 
@@ -1147,12 +1240,157 @@ func expandIPv6Notation(notation string) string {
 	return expanded
 }
 
+// reflectRecord synthesises an A or AAAA record from an IP address encoded in
+// qname (direct, Base32 or dual-stack notation), or returns nil if qname does
+// not encode an address of the requested family
+func reflectRecord(qname string, qtype uint16) dns.RR {
+	switch qtype {
+	case dns.TypeA:
+		// 1. Try direct IPv4 parsing
+		ip, ok := parseReflectIPv4(qname)
+		if ok {
+			if config.VerboseLogging {
+				log.Printf("Adding A record (direct IPv4): %v", ip)
+			}
+			return newReflectedA(qname, ip)
+		}
+
+		// 2. Try Base32 encoded IPv4
+		// Extract domain name prefix part and convert to lowercase
+		labels := strings.Split(strings.ToLower(strings.TrimSuffix(qname, ".")), ".")
+		if len(labels) >= 2 && len(labels[0]) == 8 {
+			ip, ok := base32ToIPv4(labels[0])
+			if ok {
+				if config.VerboseLogging {
+					log.Printf("Adding A record (Base32): %v", ip)
+				}
+				return newReflectedA(qname, ip)
+			}
+		}
+
+		// 3. Try dual-stack address
+		ip, ok = parseDualStackAddress(qname, dns.TypeA)
+		if ok {
+			if config.VerboseLogging {
+				log.Printf("Adding A record (dual-stack): %v", ip)
+			}
+			return newReflectedA(qname, ip)
+		}
+
+	case dns.TypeAAAA:
+		// 1. Try direct IPv6 parsing
+		ip, ok := parseReflectIPv6(qname)
+		if ok {
+			if config.VerboseLogging {
+				log.Printf("Adding AAAA record (direct IPv6): %v", ip)
+			}
+			return newReflectedAAAA(qname, ip)
+		}
+
+		// 2. Try Base32 encoded IPv6
+		// Extract domain name prefix part and convert to lowercase
+		labels := strings.Split(strings.ToLower(strings.TrimSuffix(qname, ".")), ".")
+		if len(labels) >= 2 && len(labels[0]) == 32 {
+			ip, ok := base32ToIPv6(labels[0])
+			if ok {
+				if config.VerboseLogging {
+					log.Printf("Adding AAAA record (Base32): %v", ip)
+				}
+				return newReflectedAAAA(qname, ip)
+			}
+		}
+
+		// 3. Try dual-stack address
+		ip, ok = parseDualStackAddress(qname, dns.TypeAAAA)
+		if ok {
+			if config.VerboseLogging {
+				log.Printf("Adding AAAA record (dual-stack): %v", ip)
+			}
+			return newReflectedAAAA(qname, ip)
+		}
+	}
+
+	return nil
+}
+
+func newReflectedA(qname string, ip net.IP) dns.RR {
+	return &dns.A{
+		Hdr: dns.RR_Header{
+			Name:   qname,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    config.TTL,
+		},
+		A: ip,
+	}
+}
+
+func newReflectedAAAA(qname string, ip net.IP) dns.RR {
+	return &dns.AAAA{
+		Hdr: dns.RR_Header{
+			Name:   qname,
+			Rrtype: dns.TypeAAAA,
+			Class:  dns.ClassINET,
+			Ttl:    config.TTL,
+		},
+		AAAA: ip,
+	}
+}
+
+// inReflectionDomain reports whether answers may be synthesised for qname.
+// Without configured reflection domains every name is eligible.
+func inReflectionDomain(qname string) bool {
+	if len(config.ReflectDomains) == 0 {
+		return true
+	}
+	return isSubdomainOfAny(qname, config.ReflectDomains)
+}
+
+// isReflectionName reports whether qname encodes data that the reflection
+// logic can answer for, which makes the name exist even without CSV records
+func isReflectionName(qname string) bool {
+	if !inReflectionDomain(qname) {
+		return false
+	}
+	if _, ok := parseMultiRecord(qname); ok {
+		return true
+	}
+	return reflectRecord(qname, dns.TypeA) != nil || reflectRecord(qname, dns.TypeAAAA) != nil
+}
+
+// isSubdomainOfAny reports whether name is equal to or below one of domains
+func isSubdomainOfAny(name string, domains []string) bool {
+	name = dns.Fqdn(strings.ToLower(name))
+	for _, domain := range domains {
+		if dns.IsSubDomain(dns.Fqdn(strings.ToLower(domain)), name) {
+			return true
+		}
+	}
+	return false
+}
+
 func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 	msg := new(dns.Msg)
 	msg.SetReply(r)
 
 	// Set the Authoritative Answer flag
 	msg.Authoritative = true
+	msg.RecursionAvailable = recursionAvailable(r)
+
+	// Take a snapshot of the record store so a concurrent reload cannot
+	// change the data halfway through building the response
+	store := currentRecordStore()
+
+	if r.Opcode == dns.OpcodeNotify {
+		handleNotify(w, r)
+		return
+	}
+
+	// Zone transfers are answered with a stream of messages of their own
+	if len(r.Question) == 1 && (r.Question[0].Qtype == dns.TypeAXFR || r.Question[0].Qtype == dns.TypeIXFR) {
+		handleTransfer(w, r, store)
+		return
+	}
 
 	for _, q := range r.Question {
 		if config.VerboseLogging {
@@ -1160,291 +1398,95 @@ func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 		}
 
 		// 1. First check if we have a matching record in the CSV store
-		if recordStore != nil {
-			// Convert query name to lowercase before lookup
-			records := recordStore.lookupRecord(q.Name, q.Qtype)
-			if len(records) > 0 {
+		if store != nil {
+			// Signed zones serve their DNSKEY RRset from the signing keys
+			if records := dnssecKeyRecords(store, q); len(records) > 0 {
 				msg.Answer = append(msg.Answer, records...)
-				if config.VerboseLogging {
-					log.Printf("Adding %d records from CSV for %s", len(records), q.Name)
-				}
 				continue
 			}
-		}
 
-		// 2. Check for multi-record JSON format
-		multiRecord, ok := parseMultiRecord(q.Name)
-		if ok {
-			rr := createRRFromMultiRecord(multiRecord, q.Name, q.Qtype)
-			if rr != nil {
-				msg.Answer = append(msg.Answer, rr)
+			// Names at or below a zone cut are answered with a referral
+			if nsset := findDelegation(store, q.Name, q.Qtype); nsset != nil {
+				setReferral(msg, nsset)
 				if config.VerboseLogging {
-					log.Printf("Adding multi-record for %s (type %s)", q.Name, dns.TypeToString[q.Qtype])
+					log.Printf("Referring %s to %s", q.Name, nsset[0].Hdr.Name)
 				}
 				continue
 			}
-		}
 
-		// 3. If no matching record, proceed with existing reflection logic
-		switch q.Qtype {
-		case dns.TypeA:
-			// 1. Try direct IPv4 parsing
-			ip, ok := parseReflectIPv4(q.Name)
-			if ok {
-				rr := &dns.A{
-					Hdr: dns.RR_Header{
-						Name:   q.Name,
-						Rrtype: dns.TypeA,
-						Class:  dns.ClassINET,
-						Ttl:    config.TTL,
-					},
-					A: ip,
-				}
-				msg.Answer = append(msg.Answer, rr)
+			// Names below a DNAME are rewritten to the DNAME target
+			records, rcode := lookupWithDNAME(store, q.Name, q.Qtype)
+			if len(records) > 0 {
+				msg.Answer = append(msg.Answer, records...)
 				if config.VerboseLogging {
-					log.Printf("Adding A record (direct IPv4): %v", ip)
+					log.Printf("Adding %d records from CSV for %s", len(records), q.Name)
 				}
-				continue
-			}
-
-			// 2. Try Base32 encoded IPv4
-			// Extract domain name prefix part and convert to lowercase
-			qname := strings.ToLower(strings.TrimSuffix(q.Name, "."))
-			labels := strings.Split(qname, ".")
-			if len(labels) >= 2 && len(labels[0]) == 8 {
-				ip, ok := base32ToIPv4(labels[0])
-				if ok {
-					rr := &dns.A{
-						Hdr: dns.RR_Header{
-							Name:   q.Name,
-							Rrtype: dns.TypeA,
-							Class:  dns.ClassINET,
-							Ttl:    config.TTL,
-						},
-						A: ip,
-					}
-					msg.Answer = append(msg.Answer, rr)
-					if config.VerboseLogging {
-						log.Printf("Adding A record (Base32): %v", ip)
-					}
+				if rcode != dns.RcodeSuccess {
+					msg.Rcode = rcode
 					continue
 				}
-			}
 
-			// 3. Try dual-stack address
-			ip, ok = parseDualStackAddress(q.Name, dns.TypeA)
-			if ok {
-				rr := &dns.A{
-					Hdr: dns.RR_Header{
-						Name:   q.Name,
-						Rrtype: dns.TypeA,
-						Class:  dns.ClassINET,
-						Ttl:    config.TTL,
-					},
-					A: ip,
-				}
-				msg.Answer = append(msg.Answer, rr)
-				if config.VerboseLogging {
-					log.Printf("Adding A record (dual-stack): %v", ip)
-				}
-			}
-
-		case dns.TypeAAAA:
-			// 1. Try direct IPv6 parsing
-			ip, ok := parseReflectIPv6(q.Name)
-			if ok {
-				rr := &dns.AAAA{
-					Hdr: dns.RR_Header{
-						Name:   q.Name,
-						Rrtype: dns.TypeAAAA,
-						Class:  dns.ClassINET,
-						Ttl:    config.TTL,
-					},
-					AAAA: ip,
-				}
-				msg.Answer = append(msg.Answer, rr)
-				if config.VerboseLogging {
-					log.Printf("Adding AAAA record (direct IPv6): %v", ip)
-				}
+				// Follow a CNAME answer to its target inside our zones
+				chaseCNAME(msg, store, records, q)
 				continue
 			}
+		}
 
-			// 2. Try Base32 encoded IPv6
-			// Extract domain name prefix part and convert to lowercase
-			qname := strings.ToLower(strings.TrimSuffix(q.Name, "."))
-			labels := strings.Split(qname, ".")
-			if len(labels) >= 2 && len(labels[0]) == 32 {
-				ip, ok := base32ToIPv6(labels[0])
-				if ok {
-					rr := &dns.AAAA{
-						Hdr: dns.RR_Header{
-							Name:   q.Name,
-							Rrtype: dns.TypeAAAA,
-							Class:  dns.ClassINET,
-							Ttl:    config.TTL,
-						},
-						AAAA: ip,
-					}
+		// Names we are not authoritative for can be forwarded to upstream resolvers
+		if upstreams := forwardUpstreams(store, r, q.Name); upstreams != nil {
+			forwardQuery(msg, r, q, upstreams)
+			continue
+		}
+
+		// Synthesised answers are only given for names inside the reflection domains
+		if inReflectionDomain(q.Name) {
+			// 2. Check for multi-record JSON format
+			multiRecord, ok := parseMultiRecord(q.Name)
+			if ok {
+				rr := createRRFromMultiRecord(multiRecord, q.Name, q.Qtype)
+				if rr != nil {
 					msg.Answer = append(msg.Answer, rr)
 					if config.VerboseLogging {
-						log.Printf("Adding AAAA record (Base32): %v", ip)
+						log.Printf("Adding multi-record for %s (type %s)", q.Name, dns.TypeToString[q.Qtype])
 					}
 					continue
 				}
 			}
 
-			// 3. Try dual-stack address
-			ip, ok = parseDualStackAddress(q.Name, dns.TypeAAAA)
-			if ok {
-				rr := &dns.AAAA{
-					Hdr: dns.RR_Header{
-						Name:   q.Name,
-						Rrtype: dns.TypeAAAA,
-						Class:  dns.ClassINET,
-						Ttl:    config.TTL,
-					},
-					AAAA: ip,
-				}
+			// 3. If no matching record, proceed with existing reflection logic
+			if rr := reflectRecord(q.Name, q.Qtype); rr != nil {
 				msg.Answer = append(msg.Answer, rr)
-				if config.VerboseLogging {
-					log.Printf("Adding AAAA record (dual-stack): %v", ip)
-				}
+				continue
 			}
 		}
-	}
-
-	// Add AUTHORITY section (NS records) if we have answers and records for the domain
-	// Skip adding NS records for CNAME queries or responses as requested
-	if len(msg.Answer) > 0 && recordStore != nil {
-		// Check if this is a CNAME query or if the response contains a CNAME record
-		hasCNAME := false
 
-		// Check if any of the answers is a CNAME record
-		for _, answer := range msg.Answer {
-			if answer.Header().Rrtype == dns.TypeCNAME {
-				hasCNAME = true
-				break
-			}
-		}
+		// 4. Nothing matched: answer NXDOMAIN, NODATA or REFUSED
+		setNegativeResponse(msg, store, q)
+	}
 
-		// Also check if this is a direct CNAME query
-		if !hasCNAME {
-			for _, q := range r.Question {
-				if q.Qtype == dns.TypeCNAME {
-					hasCNAME = true
-					break
-				}
-			}
+	// Add the zone's NS records to the AUTHORITY section and addresses of the
+	// names in the answer to the ADDITIONAL section
+	if store != nil {
+		for _, q := range r.Question {
+			addAuthoritySection(msg, store, q)
 		}
+		addAdditionalSection(msg, store)
 
-		// Only add NS records if this is not a CNAME query or response
-		if !hasCNAME {
-			for _, q := range r.Question {
-				domain := strings.TrimSuffix(q.Name, ".")
-				// Check if we have records for this domain
-				if records, found := recordStore.Records[domain]; found && len(records) > 0 {
-					domainFqdn := dns.Fqdn(domain)
-					// Add NS record to Authority section
-					nsRecord := &dns.NS{
-						Hdr: dns.RR_Header{
-							Name:   domainFqdn,
-							Rrtype: dns.TypeNS,
-							Class:  dns.ClassINET,
-							Ttl:    config.TTL,
-						},
-						Ns: "ns1." + domainFqdn,
-					}
-					msg.Ns = append(msg.Ns, nsRecord)
-
-					// Check if we have records for the nameserver domain before adding A record
-					nsDomain := "ns1." + domain
-					if nsRecords, nsFound := recordStore.Records[nsDomain]; nsFound && len(nsRecords) > 0 {
-						// Use the actual A record from our records if available
-						for _, nsRecord := range nsRecords {
-							if nsRecord.Type == "A" {
-								aRecord := &dns.A{
-									Hdr: dns.RR_Header{
-										Name:   "ns1." + domainFqdn,
-										Rrtype: dns.TypeA,
-										Class:  dns.ClassINET,
-										Ttl:    config.TTL,
-									},
-									A: net.ParseIP(nsRecord.Value).To4(),
-								}
-								msg.Extra = append(msg.Extra, aRecord)
-								break
-							}
-						}
-					}
-					// No else clause - if no NS record is found, don't add anything to ADDITIONAL section
-				}
-			}
+		// Sign the response for DNSSEC-aware clients
+		if opt := r.IsEdns0(); opt != nil && opt.Do() {
+			signResponse(msg, store)
 		}
-	} else if len(msg.Answer) == 0 && recordStore != nil {
-		// No answers found - this is an NXDOMAIN or empty response
-		// Add SOA record to Authority section for proper negative caching
-		for _, q := range r.Question {
-			// Extract the domain from the query and convert to lowercase
-			qname := strings.ToLower(strings.TrimSuffix(q.Name, "."))
-			labels := strings.Split(qname, ".")
-
-			// Try to find the closest parent domain that has an SOA record
-			for i := 0; i < len(labels); i++ {
-				domain := strings.Join(labels[i:], ".")
-
-				if records, found := recordStore.Records[domain]; found {
-					// Look for an SOA record for this domain
-					for _, record := range records {
-						if record.Type == "SOA" {
-							// Parse the SOA record value
-							parts := strings.Fields(record.Value)
-							if len(parts) != 7 {
-								continue
-							}
-
-							serial, _ := strconv.ParseUint(parts[2], 10, 32)
-							refresh, _ := strconv.ParseUint(parts[3], 10, 32)
-							retry, _ := strconv.ParseUint(parts[4], 10, 32)
-							expire, _ := strconv.ParseUint(parts[5], 10, 32)
-							minimum, _ := strconv.ParseUint(parts[6], 10, 32)
-
-							// Create SOA record for the authority section
-							soaRecord := &dns.SOA{
-								Hdr: dns.RR_Header{
-									Name:   dns.Fqdn(domain),
-									Rrtype: dns.TypeSOA,
-									Class:  dns.ClassINET,
-									Ttl:    record.TTL,
-								},
-								Ns:      dns.Fqdn(parts[0]),
-								Mbox:    dns.Fqdn(parts[1]),
-								Serial:  uint32(serial),
-								Refresh: uint32(refresh),
-								Retry:   uint32(retry),
-								Expire:  uint32(expire),
-								Minttl:  uint32(minimum),
-							}
-
-							msg.Ns = append(msg.Ns, soaRecord)
-
-							// Set the response code to NXDOMAIN if the query was for a specific domain
-							// that doesn't exist (not a wildcard match)
-							if !strings.HasPrefix(qname, "*.") {
-								msg.Rcode = dns.RcodeNameError
-							}
-
-							// Once we've added an SOA record, we can break out of the loop
-							break
-						}
-					}
+	}
 
-					// If we found and added an SOA record, break out of the domain search loop
-					if len(msg.Ns) > 0 {
-						break
-					}
-				}
-			}
-		}
+	// Echo EDNS(0) and keep UDP responses within the client's buffer size,
+	// which forwarded answers can exceed
+	udpSize := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		msg.SetEdns0(ednsBufferSize, opt.Do())
+		udpSize = int(min(opt.UDPSize(), ednsBufferSize))
+	}
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		msg.Truncate(udpSize)
 	}
 
 	err := w.WriteMsg(msg)
@@ -1499,12 +1541,44 @@ func initConfig(mode RunMode, ttlOverride uint32, verboseOverride *bool) {
 }
 
 func main() {
+	// "2dns export ..." writes the records instead of serving them
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
+
 	// Parse command line arguments
 	modeFlag := flag.String("mode", "dev", "Run mode: dev or production")
 	portFlag := flag.Int("port", 0, "Specify port number (overrides mode default port)")
-	csvFileFlag := flag.String("csv", "", "Path to CSV file containing DNS records")
+	var csvFiles, recordsDirs stringListFlag
+	flag.Var(&csvFiles, "csv", "Path to CSV file containing DNS records (repeatable)")
+	zoneFileFlag := flag.String("zone", "", "Path to RFC 1035 master zone file containing DNS records")
+	flag.Var(&recordsDirs, "records-dir", "Directory to load every *.csv and *.zone file from (repeatable)")
+	originFlag := flag.String("origin", ".", "Initial $ORIGIN for relative names in the zone file")
 	ttlFlag := flag.Uint("ttl", 0, "Specify TTL in seconds (0 means use mode default)")
 	verboseFlag := flag.Bool("verbose", false, "Enable verbose logging (overrides mode default)")
+	reflectDomainsFlag := flag.String("reflect-domains", "", "Comma-separated domains to answer reflection queries for (empty means any name)")
+	minimalResponsesFlag := flag.Bool("minimal-responses", false, "Omit NS records and additional addresses that are not required from responses")
+	upstreamsFlag := flag.String("upstreams", "8.8.8.8:53", "Comma-separated resolvers for ALIAS and ANAME targets, tried in order (udp:// or tcp:// prefix, default udp)")
+	upstreamTimeoutFlag := flag.Duration("upstream-timeout", defaultUpstreamTimeout, "Timeout of a single upstream query")
+	upstreamRetriesFlag := flag.Int("upstream-retries", 1, "How many more times to try all upstreams after they all failed")
+	upstreamCacheFlag := flag.Bool("upstream-cache", true, "Cache ALIAS and ANAME upstream results for their TTL")
+	serveStaleFlag := flag.Duration("serve-stale", 24*time.Hour, "How long expired upstream results may be served while upstreams fail (0 disables)")
+	forwardFlag := flag.Bool("forward", false, "Forward recursive queries for names outside our zones to the -upstreams")
+	var forwardRules forwardRulesFlag
+	flag.Var(&forwardRules, "forward-rule", "Forward names at or below a suffix to specific upstreams: suffix=upstream[,upstream...] (repeatable)")
+	forwardCacheSizeFlag := flag.Int("forward-cache-size", 10000, "Maximum number of cached forwarded responses (0 disables the cache)")
+	adminAddrFlag := flag.String("admin-addr", "", "Address to serve the admin HTTP endpoints on, e.g. 127.0.0.1:8080 (empty disables)")
+	allowTransferFlag := flag.String("allow-transfer", "", "Comma-separated addresses and CIDR prefixes that may request AXFR and IXFR (empty allows none unless a -tsig-key other than the -secondary-key is set)")
+	var tsigKeys tsigKeysFlag
+	flag.Var(&tsigKeys, "tsig-key", "TSIG key that zone transfers must be signed with: name:base64-secret (repeatable)")
+	var secondaryZones secondaryZonesFlag
+	flag.Var(&secondaryZones, "secondary", "Serve a zone transferred from primaries: zone=primary[,primary...] (repeatable)")
+	secondaryKeyFlag := flag.String("secondary-key", "", "Name of the -tsig-key that signs SOA queries and transfers sent to primaries; it does not allow transfers from this server")
+	var dnssecKeys dnssecKeysFlag
+	flag.Var(&dnssecKeys, "dnssec-key", "BIND key file (K<zone>+<alg>+<tag>.key, with its .private file) to sign the zone's answers with online (repeatable)")
+	nsec3Flag := flag.Bool("nsec3", false, "Prove negative answers of signed zones with NSEC3 white lies instead of NSEC black lies")
+	checkFlag := flag.Bool("check", false, "Validate the -csv or -zone file, report every error and exit without starting the server")
+	reloadIntervalFlag := flag.Duration("reload-interval", 5*time.Second, "How often to check record files for changes (0 disables file watching, SIGHUP still reloads)")
 	flag.Parse()
 
 	// Initialize configuration
@@ -1515,30 +1589,102 @@ func main() {
 	}
 	initConfig(mode, uint32(*ttlFlag), verboseFlag)
 
+	config.ReflectDomains = splitDomainList(*reflectDomainsFlag)
+	config.MinimalResponses = *minimalResponsesFlag
+
+	upstreams, err := parseUpstreams(*upstreamsFlag)
+	if err != nil {
+		log.Fatalf("Invalid -upstreams: %v", err)
+	}
+	config.Upstreams = upstreams
+	config.UpstreamTimeout = *upstreamTimeoutFlag
+	config.UpstreamRetries = *upstreamRetriesFlag
+	config.UpstreamCache = *upstreamCacheFlag
+	config.ServeStale = *serveStaleFlag
+	if config.UpstreamCache {
+		startUpstreamCacheMaintenance(aliasCache, 10*time.Minute)
+	}
+	config.Forward = *forwardFlag
+	config.ForwardRules = forwardRules
+	config.ForwardCacheSize = *forwardCacheSizeFlag
+	transferACL, err := parseTransferACL(*allowTransferFlag)
+	if err != nil {
+		log.Fatalf("Invalid -allow-transfer: %v", err)
+	}
+	config.TransferACL = transferACL
+	config.TSIGSecrets = tsigKeys
+	config.SecondaryZones = secondaryZones
+	if *secondaryKeyFlag != "" {
+		config.SecondaryKey = dns.CanonicalName(*secondaryKeyFlag)
+		if _, ok := tsigKeys[config.SecondaryKey]; !ok {
+			log.Fatalf("Invalid -secondary-key: no -tsig-key named %s", *secondaryKeyFlag)
+		}
+	}
+	config.DNSSECZones = dnssecKeys
+	config.NSEC3 = *nsec3Flag
+	for _, zone := range dnssecKeys {
+		var keys []string
+		for _, key := range zone.keys {
+			role := "ZSK"
+			if key.ksk() {
+				role = "KSK"
+			}
+			keys = append(keys, fmt.Sprintf("%s %d (%s)", role, key.tag, dns.AlgorithmToString[key.dnskey.Algorithm]))
+		}
+		log.Printf("Signing zone %s online with %s", zone.apex, strings.Join(keys, ", "))
+	}
+
 	// If port is specified, override the port in configuration
 	if *portFlag > 0 {
 		config.Ports = []int{*portFlag}
 		log.Printf("Using specified port: %d", *portFlag)
 	}
 
-	// Load records from CSV if specified
-	if *csvFileFlag != "" {
-		var err error
-		recordStore, err = loadRecordsFromCSV(*csvFileFlag)
-		if err != nil {
-			log.Fatalf("Failed to load records from CSV: %v", err)
+	// Load records from the CSV files, zone file and record directories, merged into one store
+	sources := recordSources{
+		CSVFiles: csvFiles,
+		ZoneFile: *zoneFileFlag,
+		Origin:   *originFlag,
+		Dirs:     recordsDirs,
+	}
+
+	if *checkFlag {
+		if sources.empty() {
+			log.Fatalf("-check requires -csv, -zone or -records-dir")
 		}
+		os.Exit(checkRecords(sources.load, sources.String(), os.Stdout))
+	}
+
+	var load recordLoader
+	if !sources.empty() {
+		load = sources.load
+	}
+	// Transferred zones are merged into the store on every reload
+	if len(config.SecondaryZones) > 0 {
+		load = withSecondaryZones(load, config.SecondaryZones)
+	}
 
-		// Count total records
-		totalRecords := 0
-		for _, records := range recordStore.Records {
-			totalRecords += len(records)
+	if load != nil {
+		store, err := buildRecordStore(load)
+		if err != nil {
+			log.Fatalf("Failed to load records: %v", err)
 		}
-		for _, records := range recordStore.WildRecords {
-			totalRecords += len(records)
+		swapRecordStore(store)
+
+		if !sources.empty() {
+			log.Printf("Loaded %d records from %s", store.count(), sources)
+
+			// Reload on SIGHUP and whenever a file changes on disk
+			startRecordReloader(load, sources.watchPaths, *reloadIntervalFlag)
 		}
+	}
+	if len(config.SecondaryZones) > 0 {
+		log.Printf("Serving %d secondary zones: %s", len(config.SecondaryZones), &secondaryZones)
+		startSecondaryZones(config.SecondaryZones, load)
+	}
 
-		log.Printf("Loaded %d records from %s", totalRecords, *csvFileFlag)
+	if *adminAddrFlag != "" {
+		startAdminServer(*adminAddrFlag)
 	}
 
 	dns.HandleFunc(".", handleDNSRequest)
@@ -1569,8 +1715,9 @@ func main() {
 				defer wg.Done()
 
 				server := &dns.Server{
-					Addr: fmt.Sprintf(":%d", p),
-					Net:  net,
+					Addr:       fmt.Sprintf(":%d", p),
+					Net:        net,
+					TsigSecret: config.TSIGSecrets,
 				}
 
 				if config.VerboseLogging {
@@ -16,7 +16,7 @@ import (
 
 // TestSuite provides shared test fixtures and utilities
 type TestSuite struct {
-	originalRecordStore *RecordStore
+	originalRecordStore RecordBackend
 	testRecordStore     *RecordStore
 }
 
@@ -333,6 +333,27 @@ func TestRecordStoreLookup(t *testing.T) {
 			wantType:     dns.TypeA,
 			description:  "Should match wildcard for deep subdomains",
 		},
+		{
+			name:         "Existing name blocks wildcard",
+			qname:        "example.com",
+			qtype:        dns.TypeMX,
+			wantRecords:  0,
+			description:  "Should not apply a wildcard to a name that exists",
+		},
+		{
+			name:         "Empty non-terminal blocks wildcard",
+			qname:        "_tcp.example.com",
+			qtype:        dns.TypeA,
+			wantRecords:  0,
+			description:  "Should not apply a wildcard to a parent of existing names",
+		},
+		{
+			name:         "Wildcard below existing node",
+			qname:        "x._tcp.example.com",
+			qtype:        dns.TypeA,
+			wantRecords:  0,
+			description:  "Should not apply a wildcard above the closest encloser",
+		},
 	}
 
 	for _, tt := range tests {
@@ -653,9 +674,14 @@ www.example.com,CNAME,example.com,3600,,,
 		}{
 			{
 				name:    "Missing fields",
-				content: "name,type\nexample.com,A",
+				content: "name,type,value\nexample.com,A",
 				wantErr: "not enough fields",
 			},
+			{
+				name:    "Missing value column",
+				content: "name,type\nexample.com,A",
+				wantErr: "missing the 'value' column",
+			},
 			{
 				name:    "Invalid TTL",
 				content: "name,type,value,ttl\nexample.com,A,192.168.1.1,invalid",
@@ -715,6 +741,61 @@ func BenchmarkDNSHandling(b *testing.B) {
 	}
 }
 
+// BenchmarkRecordLookup compares serving the precompiled records of a store
+// with creating them from the loaded data on every query, as lookupRecord
+// did before records were compiled
+func BenchmarkRecordLookup(b *testing.B) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	store := suite.testRecordStore
+	store.compile()
+
+	perQuery := func(name string, qtype uint16) []dns.RR {
+		store.mu.RLock()
+		defer store.mu.RUnlock()
+
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		records, found := store.Records[name]
+		if !found && !store.exists(name) {
+			_, records = store.findWildcard(name)
+		}
+		var result []dns.RR
+		for _, record := range records {
+			if rr := createRR(record, name, qtype); rr != nil {
+				result = append(result, rr)
+			}
+		}
+		return result
+	}
+
+	benchmarks := []struct {
+		name  string
+		qname string
+		qtype uint16
+	}{
+		{"A", "example.com.", dns.TypeA},
+		{"SOA", "example.com.", dns.TypeSOA},
+		{"SRV", "_sip._tcp.example.com.", dns.TypeSRV},
+		{"Wildcard", "test.example.com.", dns.TypeA},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name+"/Compiled", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				store.lookupRecord(bm.qname, bm.qtype)
+			}
+		})
+		b.Run(bm.name+"/PerQuery", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				perQuery(bm.qname, bm.qtype)
+			}
+		})
+	}
+}
+
 // TestEdgeCases tests various edge cases and error conditions
 func TestEdgeCases(t *testing.T) {
 	suite := setupTestSuite()
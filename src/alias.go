@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// aliasTypes are the query types an ALIAS record answers with the target's data
+var aliasTypes = map[uint16]bool{
+	dns.TypeA:    true,
+	dns.TypeAAAA: true,
+	dns.TypeMX:   true,
+	dns.TypeTXT:  true,
+}
+
+// createAliasRRs expands an ALIAS or ANAME record into the target's complete
+// qtype RRset, renamed to qname. ALIAS and ANAME are resolved at the server
+// rather than by the client, so they can be used at the zone apex. ANAME
+// targets are looked up in store, the backend the query is answered from,
+// before going upstream.
+func createAliasRRs(store RecordBackend, record DNSRecord, qname string, qtype uint16) []dns.RR {
+	qname = dns.Fqdn(qname)
+	ttl := record.ttl()
+
+	target := record.Value
+	switch record.Type {
+	case "ALIAS":
+		if !aliasTypes[qtype] {
+			return nil
+		}
+
+	case "ANAME":
+		// ANAME records only respond to A or AAAA queries
+		if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+			return nil
+		}
+
+		// Targets we serve ourselves are answered from the record store
+		if store != nil {
+			rrs, chainTTL, next, local := resolveInStore(store, record.Value, qtype)
+			if local {
+				return renameRRs(rrs, qname, qtype, min(ttl, chainTTL))
+			}
+			// The chain left our zones, the rest is resolved upstream
+			target = next
+		}
+
+	default:
+		return nil
+	}
+
+	rrs, upstreamTTL, err := lookupUpstream(target, qtype)
+	if err != nil {
+		if config.VerboseLogging {
+			log.Printf("%s resolution failed for %s: %v", record.Type, target, err)
+		}
+		return nil
+	}
+	if len(rrs) == 0 && config.VerboseLogging {
+		log.Printf("%s resolution for %s returned no %s records", record.Type, target, dns.TypeToString[qtype])
+	}
+	// Our answer must not outlive the upstream data
+	if upstreamTTL < ttl {
+		ttl = upstreamTTL
+	}
+	return renameRRs(rrs, qname, qtype, ttl)
+}
+
+// resolveInStore resolves name from the record store the way a query for it
+// would be answered, following CNAME and DNAME chains and reflection names.
+// It returns the qtype records at the end of the chain and the lowest TTL on
+// the way. local is false if the store is not authoritative for the answer;
+// target is then the name at which the chain left our zones.
+func resolveInStore(store RecordBackend, name string, qtype uint16) (rrs []dns.RR, ttl uint32, target string, local bool) {
+	q := dns.Question{Name: dns.Fqdn(name), Qtype: qtype, Qclass: dns.ClassINET}
+
+	msg := new(dns.Msg)
+	answer, rcode := lookupWithDNAME(store, q.Name, qtype)
+	msg.Answer = answer
+	if rcode == dns.RcodeSuccess {
+		chaseCNAME(msg, store, answer, q)
+	}
+	if len(msg.Answer) == 0 && inReflectionDomain(q.Name) {
+		if rr := reflectRecord(q.Name, qtype); rr != nil {
+			msg.Answer = []dns.RR{rr}
+		}
+	}
+
+	target = q.Name
+	for i, rr := range msg.Answer {
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+		if cname, ok := rr.(*dns.CNAME); ok {
+			target = cname.Target
+		}
+		if rr.Header().Rrtype == qtype {
+			rrs = append(rrs, rr)
+		}
+	}
+	local = len(rrs) > 0 || isLocalName(store, target) && findDelegation(store, target, qtype) == nil
+	return rrs, ttl, target, local
+}
+
+// checkANAMEChain makes sure an ANAME's target does not lead back to the ANAME
+// through CNAME and ANAME records in the store, which would recurse forever
+// at query time
+func (store *RecordStore) checkANAMEChain(record DNSRecord) error {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	visited := map[string]bool{strings.ToLower(record.Name): true}
+	name := record.Value
+	for depth := 0; ; depth++ {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if visited[name] {
+			return fmt.Errorf("%s: ANAME target %s leads back to itself", record.Name, record.Value)
+		}
+		if depth >= maxCNAMEChain {
+			return fmt.Errorf("%s: ANAME target %s is more than %d aliases away", record.Name, record.Value, maxCNAMEChain)
+		}
+		visited[name] = true
+
+		records := store.Records[name]
+		if !store.exists(name) {
+			_, records = store.findWildcard(name)
+		}
+		next := ""
+		for _, r := range records {
+			if r.Type == "CNAME" || r.Type == "ANAME" {
+				next = r.Value
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		name = next
+	}
+}
+
+// renameRRs returns copies of the qtype records in rrs owned by name with the given TTL
+func renameRRs(rrs []dns.RR, name string, qtype uint16, ttl uint32) []dns.RR {
+	var result []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		rr.Header().Ttl = ttl
+		result = append(result, rr)
+	}
+	return result
+}
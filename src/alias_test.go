@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TestANAMEResolution tests ANAME records whose targets are answered from the record store
+func TestANAMEResolution(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config.Upstreams = []upstreamServer{{"udp", startStubUpstream(t, stubAnswer)}}
+	config.UpstreamTimeout = time.Second
+
+	store := newRecordStore()
+	for _, record := range []DNSRecord{
+		{Name: "example.com", Type: "SOA", Value: "ns1.example.com. admin.example.com. 2025050801 3600 1800 604800 300", TTL: 3600},
+		{Name: "example.com", Type: "ANAME", Value: "web.example.com", TTL: 3600},
+		{Name: "web.example.com", Type: "A", Value: "192.168.1.1", TTL: 3600},
+		{Name: "web.example.com", Type: "A", Value: "192.168.1.2", TTL: 600},
+		{Name: "web.example.com", Type: "AAAA", Value: "2001:db8::1", TTL: 3600},
+		{Name: "chain.example.com", Type: "ANAME", Value: "www.example.com", TTL: 3600},
+		{Name: "www.example.com", Type: "CNAME", Value: "web.example.com", TTL: 300},
+		{Name: "v4only.example.com", Type: "ANAME", Value: "legacy.example.com", TTL: 3600},
+		{Name: "legacy.example.com", Type: "A", Value: "192.168.1.3", TTL: 3600},
+		{Name: "external.example.com", Type: "ANAME", Value: "cdn.example.com", TTL: 3600},
+		{Name: "cdn.example.com", Type: "CNAME", Value: "target.example.net", TTL: 3600},
+		{Name: "reflect.example.com", Type: "ANAME", Value: "10.0.0.1.example.com", TTL: 3600},
+	} {
+		store.addRecord(record)
+	}
+	// Targets are looked up in the store being queried, not the one being served
+	recordStore = newRecordStore()
+
+	tests := []struct {
+		name    string
+		qname   string
+		qtype   uint16
+		want    []string
+		wantTTL uint32
+	}{
+		{"A at the apex", "example.com.", dns.TypeA, []string{"192.168.1.1", "192.168.1.2"}, 600},
+		{"AAAA at the apex", "example.com.", dns.TypeAAAA, []string{"2001:db8::1"}, 3600},
+		{"A through a CNAME chain", "chain.example.com.", dns.TypeA, []string{"192.168.1.1", "192.168.1.2"}, 300},
+		{"AAAA through a CNAME chain", "chain.example.com.", dns.TypeAAAA, []string{"2001:db8::1"}, 300},
+		{"In-zone target without AAAA", "v4only.example.com.", dns.TypeAAAA, nil, 0},
+		{"CNAME chain leaving the zone is resolved upstream", "external.example.com.", dns.TypeAAAA, []string{"2001:db8::1", "2001:db8::2"}, 120},
+		{"Reflection target", "reflect.example.com.", dns.TypeA, []string{"10.0.0.1"}, config.TTL},
+		{"Other types are not answered", "example.com.", dns.TypeTXT, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := store.lookupRecord(tt.qname, tt.qtype)
+			if len(records) != len(tt.want) {
+				t.Fatalf("Expected %d records, got %d: %v", len(tt.want), len(records), records)
+			}
+			for i, rr := range records {
+				var value string
+				switch v := rr.(type) {
+				case *dns.A:
+					value = v.A.String()
+				case *dns.AAAA:
+					value = v.AAAA.String()
+				}
+				if rr.Header().Name != tt.qname || value != tt.want[i] {
+					t.Errorf("Expected %s %s, got %s", tt.qname, tt.want[i], rr)
+				}
+				if rr.Header().Ttl != tt.wantTTL {
+					t.Errorf("Expected TTL %d, got %d", tt.wantTTL, rr.Header().Ttl)
+				}
+			}
+		})
+	}
+}
+
+// TestAliasResolvedWithoutLock tests that ALIAS targets are resolved upstream
+// without holding the store's lock, which would stall reloads and other writers
+func TestAliasResolvedWithoutLock(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config.UpstreamTimeout = time.Second
+	config.UpstreamCache = false
+
+	store := newRecordStore()
+	store.addRecord(DNSRecord{Name: "example.com", Type: "ALIAS", Value: "target.example.net", TTL: 300})
+	config.Upstreams = []upstreamServer{{"udp", startStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		// Blocks until the lookup releases its read lock
+		store.addRecord(DNSRecord{Name: "www.example.com", Type: "A", Value: "192.0.2.1"})
+		stubAnswer(w, r)
+	})}}
+
+	if records := store.lookupRecord("example.com.", dns.TypeA); len(records) != 2 {
+		t.Errorf("Expected 2 records from the upstream, got %v", records)
+	}
+}
+
+// TestANAMELoopValidation tests that ANAME chains leading back to themselves are rejected at load time
+func TestANAMELoopValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []DNSRecord
+		wantErr string
+	}{
+		{
+			name: "Self reference",
+			records: []DNSRecord{
+				{Name: "example.com", Type: "ANAME", Value: "example.com"},
+			},
+			wantErr: "leads back to itself",
+		},
+		{
+			name: "Loop through a CNAME",
+			records: []DNSRecord{
+				{Name: "example.com", Type: "ANAME", Value: "www.example.com"},
+				{Name: "www.example.com", Type: "CNAME", Value: "example.com"},
+			},
+			wantErr: "leads back to itself",
+		},
+		{
+			name: "Loop through a wildcard",
+			records: []DNSRecord{
+				{Name: "*.example.com", Type: "ANAME", Value: "a.example.com"},
+			},
+			wantErr: "leads back to itself",
+		},
+		{
+			name: "Chain to another ANAME",
+			records: []DNSRecord{
+				{Name: "example.com", Type: "ANAME", Value: "www.example.com"},
+				{Name: "www.example.com", Type: "ANAME", Value: "target.example.net"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newRecordStore()
+			for _, record := range tt.records {
+				store.addRecord(record)
+			}
+			err := store.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// addAuthoritySection adds the NS RRset of the zone containing the question
+// name to the AUTHORITY section of a positive authoritative answer. Negative
+// answers already carry the SOA, and minimal responses leave it out.
+func addAuthoritySection(msg *dns.Msg, store RecordBackend, q dns.Question) {
+	if config.MinimalResponses || !msg.Authoritative || msg.Rcode != dns.RcodeSuccess {
+		return
+	}
+	if len(msg.Answer) == 0 || len(msg.Ns) > 0 {
+		return
+	}
+
+	soa := store.findSOA(q.Name)
+	if soa == nil {
+		return
+	}
+	// The NS RRset is already in the answer for NS queries at the apex
+	for _, rr := range msg.Answer {
+		if rr.Header().Rrtype == dns.TypeNS && dns.CanonicalName(rr.Header().Name) == dns.CanonicalName(soa.Hdr.Name) {
+			return
+		}
+	}
+	for _, ns := range store.findNS(soa.Hdr.Name) {
+		msg.Ns = append(msg.Ns, ns)
+	}
+}
+
+// addAdditionalSection adds the A and AAAA records of in-zone names that the
+// answer and authority sections point to: name servers (glue) and, unless
+// minimal responses are configured, MX, SRV, SVCB and HTTPS targets
+func addAdditionalSection(msg *dns.Msg, store RecordBackend) {
+	var names []string
+	for _, rr := range msg.Ns {
+		if ns, ok := rr.(*dns.NS); ok {
+			names = append(names, ns.Ns)
+		}
+	}
+	if !config.MinimalResponses {
+		for _, rr := range msg.Answer {
+			switch v := rr.(type) {
+			case *dns.NS:
+				names = append(names, v.Ns)
+			case *dns.MX:
+				names = append(names, v.Mx)
+			case *dns.SRV:
+				names = append(names, v.Target)
+			case *dns.SVCB:
+				names = append(names, svcbTarget(v))
+			case *dns.HTTPS:
+				names = append(names, svcbTarget(&v.SVCB))
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, rr := range msg.Answer {
+		seen[rrKey(rr)] = true
+	}
+	for _, rr := range msg.Extra {
+		seen[rrKey(rr)] = true
+	}
+
+	for _, name := range names {
+		for _, rr := range addressRecords(store, name) {
+			if key := rrKey(rr); !seen[key] {
+				seen[key] = true
+				msg.Extra = append(msg.Extra, rr)
+			}
+		}
+	}
+}
+
+// addressRecords returns the A and AAAA records of name if the store is
+// authoritative for it
+func addressRecords(store RecordBackend, name string) []dns.RR {
+	if name == "" || name == "." || !isLocalName(store, name) {
+		return nil
+	}
+
+	var result []dns.RR
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		for _, rr := range store.lookupRecord(name, qtype) {
+			// Skip the CNAME a lookup may return instead of addresses
+			if rr.Header().Rrtype == qtype {
+				result = append(result, rr)
+			}
+		}
+	}
+	return result
+}
+
+// rrKey identifies a resource record by owner, type and data for de-duplication
+func rrKey(rr dns.RR) string {
+	hdr := rr.Header()
+	return strings.ToLower(hdr.Name) + " " + dns.TypeToString[hdr.Rrtype] + " " + strings.TrimPrefix(rr.String(), hdr.String())
+}
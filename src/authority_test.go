@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// TestAuthorityAndAdditional tests NS records in the authority section and
+// address records for NS, MX, SRV, SVCB and HTTPS targets in the additional section
+func TestAuthorityAndAdditional(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	store := newRecordStore()
+	for _, record := range []DNSRecord{
+		{Name: "example.com", Type: "SOA", Value: "ns1.example.com. admin.example.com. 2025050801 3600 1800 604800 300", TTL: 3600},
+		{Name: "example.com", Type: "NS", Value: "ns1.example.com", TTL: 3600},
+		{Name: "example.com", Type: "NS", Value: "ns2.example.net", TTL: 3600},
+		{Name: "example.com", Type: "MX", Value: "mail.example.com", TTL: 3600, Priority: 10},
+		{Name: "www.example.com", Type: "A", Value: "192.168.1.1", TTL: 3600},
+		{Name: "ns1.example.com", Type: "A", Value: "192.168.1.53", TTL: 3600},
+		{Name: "ns1.example.com", Type: "AAAA", Value: "2001:db8::53", TTL: 3600},
+		{Name: "mail.example.com", Type: "A", Value: "192.168.1.25", TTL: 3600},
+		{Name: "_sip._tcp.example.com", Type: "SRV", Value: "sip.example.com", TTL: 3600, Priority: 10, Weight: 5, Port: 5060},
+		{Name: "sip.example.com", Type: "A", Value: "192.168.1.60", TTL: 3600},
+		{Name: "_xmpp._tcp.example.com", Type: "SRV", Value: "xmpp.example.com", TTL: 3600, Priority: 10, Weight: 5, Port: 5222},
+		{Name: "xmpp.example.com", Type: "CNAME", Value: "www.example.com", TTL: 3600},
+		{Name: "svc.example.com", Type: "HTTPS", Value: "1 . alpn=h3,h2", TTL: 3600},
+		{Name: "svc.example.com", Type: "A", Value: "192.168.1.80", TTL: 3600},
+		{Name: "alias.example.com", Type: "HTTPS", Value: "0 www.example.com", TTL: 3600},
+		{Name: "_8443._foo.example.com", Type: "SVCB", Value: "1 sip.example.com port=8443", TTL: 3600},
+		{Name: "external.example.com", Type: "HTTPS", Value: "1 cdn.example.net", TTL: 3600},
+		{Name: "other.org", Type: "A", Value: "192.168.2.1", TTL: 3600},
+	} {
+		store.addRecord(record)
+	}
+	recordStore = store
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		minimal   bool
+		wantNs    int
+		wantExtra []string
+	}{
+		{"A record gets zone NS and glue", "www.example.com.", dns.TypeA, false, 2, []string{"192.168.1.53", "2001:db8::53"}},
+		{"NS query gets glue only for in-zone names", "example.com.", dns.TypeNS, false, 0, []string{"192.168.1.53", "2001:db8::53"}},
+		{"MX target address", "example.com.", dns.TypeMX, false, 2, []string{"192.168.1.53", "2001:db8::53", "192.168.1.25"}},
+		{"SRV target address", "_sip._tcp.example.com.", dns.TypeSRV, false, 2, []string{"192.168.1.53", "2001:db8::53", "192.168.1.60"}},
+		{"SRV target behind CNAME has no address", "_xmpp._tcp.example.com.", dns.TypeSRV, false, 2, []string{"192.168.1.53", "2001:db8::53"}},
+		{"HTTPS target . is the owner name", "svc.example.com.", dns.TypeHTTPS, false, 2, []string{"192.168.1.53", "2001:db8::53", "192.168.1.80"}},
+		{"HTTPS AliasMode target address", "alias.example.com.", dns.TypeHTTPS, false, 2, []string{"192.168.1.53", "2001:db8::53", "192.168.1.1"}},
+		{"SVCB target address", "_8443._foo.example.com.", dns.TypeSVCB, false, 2, []string{"192.168.1.53", "2001:db8::53", "192.168.1.60"}},
+		{"HTTPS target outside our zones has no address", "external.example.com.", dns.TypeHTTPS, false, 2, []string{"192.168.1.53", "2001:db8::53"}},
+		{"Negative answer has SOA only", "missing.example.com.", dns.TypeA, false, 1, nil},
+		{"Name outside zones", "other.org.", dns.TypeA, false, 0, nil},
+		{"Minimal responses", "example.com.", dns.TypeMX, true, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.MinimalResponses = tt.minimal
+			defer func() { config.MinimalResponses = false }()
+
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, tt.qtype)
+
+			w := newMockResponseWriter()
+			handleDNSRequest(w, req)
+
+			if len(w.msg.Ns) != tt.wantNs {
+				t.Errorf("Expected %d authority records, got %d: %v", tt.wantNs, len(w.msg.Ns), w.msg.Ns)
+			}
+			for _, rr := range w.msg.Ns {
+				if ns, ok := rr.(*dns.NS); ok && ns.Hdr.Name != "example.com." {
+					t.Errorf("Expected NS owned by the zone apex, got %s", ns)
+				}
+			}
+
+			var extra []string
+			for _, rr := range w.msg.Extra {
+				switch v := rr.(type) {
+				case *dns.A:
+					extra = append(extra, v.A.String())
+				case *dns.AAAA:
+					extra = append(extra, v.AAAA.String())
+				}
+			}
+			if len(extra) != len(tt.wantExtra) {
+				t.Fatalf("Expected additional addresses %v, got %v", tt.wantExtra, extra)
+			}
+			for i := range extra {
+				if extra[i] != tt.wantExtra[i] {
+					t.Errorf("Expected additional addresses %v, got %v", tt.wantExtra, extra)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// RecordBackend is a source of authoritative records for handleDNSRequest.
+// The in-memory RecordStore built from CSV or zone files is the default
+// implementation; other sources such as generated records or test fakes only
+// need to provide these lookups for the resolver logic to serve them.
+type RecordBackend interface {
+	// lookupRecord returns the records for name and qtype, including any
+	// synthesised from a matching wildcard
+	lookupRecord(name string, qtype uint16) []dns.RR
+
+	// nameExists reports whether name owns any records
+	nameExists(name string) bool
+
+	// lookupName reports whether name exists, as an owner of records, an
+	// empty non-terminal or through wildcard synthesis
+	lookupName(name string) nameState
+
+	// recordTypes returns the types name has records of, or gets from the
+	// wildcard that synthesises it, in ascending order
+	recordTypes(name string) []uint16
+
+	// findSOA returns the SOA record of the closest zone enclosing name,
+	// or nil if name is not inside any zone
+	findSOA(name string) *dns.SOA
+
+	// findNS returns the NS records at the apex of zone
+	findNS(zone string) []*dns.NS
+
+	// enumerate calls fn for every record in the backend, with wildcard records
+	// carrying their "*." owner name, until fn returns false
+	enumerate(fn func(DNSRecord) bool)
+}
+
+// nameExists reports whether name owns any records in the store
+func (store *RecordStore) nameExists(name string) bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return len(store.Records[name]) > 0
+}
+
+// recordTypes returns the types of the records name owns or gets from a
+// wildcard. ALIAS and ANAME records count as the types they answer.
+func (store *RecordStore) recordTypes(name string) []uint16 {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	index := store.getIndex()
+	compiled := index.names[name]
+	if compiled == nil && strings.HasPrefix(name, "*.") {
+		compiled = index.wildcards[name[2:]]
+	}
+	if compiled == nil && !index.nonTerminals[name] {
+		if encloser, records := store.findWildcard(name); len(records) > 0 {
+			compiled = index.wildcards[encloser]
+		}
+	}
+	if compiled == nil {
+		return nil
+	}
+
+	present := make(map[uint16]bool)
+	for qtype := range compiled.rrsets {
+		present[qtype] = true
+	}
+	for _, record := range compiled.dynamic {
+		switch record.Type {
+		case "ALIAS":
+			for qtype := range aliasTypes {
+				present[qtype] = true
+			}
+		case "ANAME":
+			present[dns.TypeA] = true
+			present[dns.TypeAAAA] = true
+		}
+	}
+
+	types := make([]uint16, 0, len(present))
+	for qtype := range present {
+		types = append(types, qtype)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// findSOA walks up from name to the closest domain that has an SOA record
+func (store *RecordStore) findSOA(name string) *dns.SOA {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	labels := strings.Split(name, ".")
+
+	index := store.getIndex()
+	for i := 0; i < len(labels); i++ {
+		domain := strings.Join(labels[i:], ".")
+		for _, rr := range index.names[domain].rrset(domain, dns.TypeSOA) {
+			if soa, ok := rr.(*dns.SOA); ok {
+				return soa
+			}
+		}
+	}
+
+	return nil
+}
+
+// findNS returns the NS records owned by zone
+func (store *RecordStore) findNS(zone string) []*dns.NS {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	var result []*dns.NS
+	for _, rr := range store.getIndex().names[zone].rrset(zone, dns.TypeNS) {
+		if ns, ok := rr.(*dns.NS); ok {
+			result = append(result, ns)
+		}
+	}
+	return result
+}
+
+// enumerate calls fn for every record in the store until fn returns false
+func (store *RecordStore) enumerate(fn func(DNSRecord) bool) {
+	for _, record := range store.allRecords() {
+		if !fn(record) {
+			return
+		}
+	}
+}
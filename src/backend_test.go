@@ -0,0 +1,189 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// fakeBackend is a RecordBackend that synthesises an A record for every name
+// under its zone, used to check that the handler does not depend on RecordStore
+type fakeBackend struct {
+	zone string
+}
+
+func (f *fakeBackend) lookupRecord(name string, qtype uint16) []dns.RR {
+	if qtype != dns.TypeA || !f.nameExists(name) {
+		return nil
+	}
+	return []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   []byte{10, 9, 8, 7},
+	}}
+}
+
+func (f *fakeBackend) nameExists(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return strings.HasSuffix(name, "."+f.zone) && !strings.HasPrefix(name, "missing.")
+}
+
+func (f *fakeBackend) lookupName(name string) nameState {
+	if f.nameExists(name) {
+		return nameOwnsRecords
+	}
+	return nameMissing
+}
+
+func (f *fakeBackend) recordTypes(name string) []uint16 {
+	if !f.nameExists(name) {
+		return nil
+	}
+	return []uint16{dns.TypeA}
+}
+
+func (f *fakeBackend) findSOA(name string) *dns.SOA {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name != f.zone && !strings.HasSuffix(name, "."+f.zone) {
+		return nil
+	}
+	return &dns.SOA{
+		Hdr:  dns.RR_Header{Name: dns.Fqdn(f.zone), Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+		Ns:   "ns." + dns.Fqdn(f.zone),
+		Mbox: "admin." + dns.Fqdn(f.zone),
+	}
+}
+
+func (f *fakeBackend) findNS(zone string) []*dns.NS {
+	return nil
+}
+
+func (f *fakeBackend) enumerate(fn func(DNSRecord) bool) {
+	fn(DNSRecord{Name: "host." + f.zone, Type: "A", Value: "10.9.8.7", TTL: 60})
+}
+
+// TestRecordBackendInterface tests serving records from a backend other than RecordStore
+func TestRecordBackendInterface(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	recordStore = &fakeBackend{zone: "fake.test"}
+
+	t.Run("Positive answer", func(t *testing.T) {
+		req := new(dns.Msg)
+		req.SetQuestion("anything.fake.test.", dns.TypeA)
+
+		w := newMockResponseWriter()
+		handleDNSRequest(w, req)
+
+		if len(w.msg.Answer) != 1 {
+			t.Fatalf("Expected 1 answer, got %d", len(w.msg.Answer))
+		}
+		if a := w.msg.Answer[0].(*dns.A); a.A.String() != "10.9.8.7" {
+			t.Errorf("Expected IP 10.9.8.7, got %s", a.A.String())
+		}
+	})
+
+	t.Run("Negative answer uses backend SOA", func(t *testing.T) {
+		req := new(dns.Msg)
+		req.SetQuestion("missing.fake.test.", dns.TypeA)
+
+		w := newMockResponseWriter()
+		handleDNSRequest(w, req)
+
+		if len(w.msg.Ns) != 1 {
+			t.Fatalf("Expected 1 authority record, got %d", len(w.msg.Ns))
+		}
+		if soa, ok := w.msg.Ns[0].(*dns.SOA); !ok || soa.Hdr.Name != "fake.test." {
+			t.Errorf("Expected SOA for fake.test., got %v", w.msg.Ns[0])
+		}
+	})
+}
+
+// TestRecordStoreBackend tests the RecordBackend methods of the in-memory store
+func TestRecordStoreBackend(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	store := suite.testRecordStore
+
+	t.Run("findSOA", func(t *testing.T) {
+		for _, name := range []string{"example.com", "www.example.com.", "A.B.EXAMPLE.COM"} {
+			soa := store.findSOA(name)
+			if soa == nil {
+				t.Errorf("findSOA(%s) returned nil", name)
+				continue
+			}
+			if soa.Hdr.Name != "example.com." || soa.Serial != 2025050801 {
+				t.Errorf("findSOA(%s) = %v, want example.com. serial 2025050801", name, soa)
+			}
+		}
+		if soa := store.findSOA("unknown.com"); soa != nil {
+			t.Errorf("findSOA(unknown.com) = %v, want nil", soa)
+		}
+	})
+
+	t.Run("findNS", func(t *testing.T) {
+		ns := store.findNS("example.com.")
+		if len(ns) != 1 || ns[0].Ns != "ns1.example.com." {
+			t.Errorf("Expected NS ns1.example.com., got %v", ns)
+		}
+	})
+
+	t.Run("nameExists", func(t *testing.T) {
+		if !store.nameExists("WWW.example.com.") {
+			t.Error("Expected www.example.com to exist")
+		}
+		if store.nameExists("missing.example.com") {
+			t.Error("Expected missing.example.com not to exist")
+		}
+	})
+
+	t.Run("enumerate", func(t *testing.T) {
+		count := 0
+		wildcards := 0
+		store.enumerate(func(record DNSRecord) bool {
+			count++
+			if strings.HasPrefix(record.Name, "*.") {
+				wildcards++
+			}
+			return true
+		})
+		if count != store.count() {
+			t.Errorf("enumerate visited %d records, want %d", count, store.count())
+		}
+		if wildcards != 2 {
+			t.Errorf("Expected 2 wildcard records, got %d", wildcards)
+		}
+
+		visited := 0
+		store.enumerate(func(record DNSRecord) bool {
+			visited++
+			return false
+		})
+		if visited != 1 {
+			t.Errorf("Expected enumerate to stop after 1 record, visited %d", visited)
+		}
+	})
+	t.Run("recordTypes", func(t *testing.T) {
+		aliases := newRecordStore()
+		aliases.addRecord(DNSRecord{Name: "alias.example.com", Type: "ALIAS", Value: "target.example.net"})
+		aliases.addRecord(DNSRecord{Name: "aname.example.com", Type: "ANAME", Value: "target.example.net"})
+		aliases.addRecord(DNSRecord{Name: "aname.example.com", Type: "TXT", Value: "text"})
+
+		tests := []struct {
+			name string
+			want []uint16
+		}{
+			{"alias.example.com", []uint16{dns.TypeA, dns.TypeMX, dns.TypeTXT, dns.TypeAAAA}},
+			{"aname.example.com", []uint16{dns.TypeA, dns.TypeTXT, dns.TypeAAAA}},
+			{"missing.example.com", nil},
+		}
+		for _, tt := range tests {
+			if got := aliases.recordTypes(tt.name); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recordTypes(%s) = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	})
+}
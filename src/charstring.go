@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxCharacterString is the length limit of a character-string in record
+// data (RFC 1035 section 3.3)
+const maxCharacterString = 255
+
+// splitRecordFields splits a record value in presentation format into its
+// fields: runs of characters separated by spaces, or character-strings in
+// double quotes that may contain spaces. The value of a key=value field, as
+// in SVCB parameters, may be quoted on its own. Backslash escapes (\X for a
+// literal X and \DDD for a decimal byte value) are decoded in all of them.
+func splitRecordFields(value string) ([]string, error) {
+	var fields []string
+	for i := 0; i < len(value); {
+		if value[i] == ' ' || value[i] == '\t' {
+			i++
+			continue
+		}
+
+		quoted := value[i] == '"'
+		if quoted {
+			i++
+		}
+		var field strings.Builder
+		closed := false
+		for i < len(value) {
+			c := value[i]
+			if quoted && c == '"' {
+				i++
+				closed = true
+				break
+			}
+			if !quoted && (c == ' ' || c == '\t') {
+				break
+			}
+			if !quoted && c == '"' {
+				if !strings.HasSuffix(field.String(), "=") {
+					return nil, fmt.Errorf("unexpected quote in '%s'", value)
+				}
+				quoted = true
+				i++
+				continue
+			}
+			if c != '\\' {
+				field.WriteByte(c)
+				i++
+				continue
+			}
+
+			// Escape sequence
+			if i+1 >= len(value) {
+				return nil, fmt.Errorf("trailing backslash in '%s'", value)
+			}
+			if isDigit(value[i+1]) {
+				if i+4 > len(value) || !isDigit(value[i+2]) || !isDigit(value[i+3]) {
+					return nil, fmt.Errorf("invalid escape '%s' in '%s', \\DDD needs three digits", value[i:min(i+4, len(value))], value)
+				}
+				n, _ := strconv.Atoi(value[i+1 : i+4])
+				if n > 255 {
+					return nil, fmt.Errorf("invalid escape '%s' in '%s', must be at most \\255", value[i:i+4], value)
+				}
+				field.WriteByte(byte(n))
+				i += 4
+				continue
+			}
+			field.WriteByte(value[i+1])
+			i += 2
+		}
+		if quoted && !closed {
+			return nil, fmt.Errorf("unterminated quoted string in '%s'", value)
+		}
+		if quoted && i < len(value) && value[i] != ' ' && value[i] != '\t' {
+			return nil, fmt.Errorf("missing space after quoted string in '%s'", value)
+		}
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// escapeCharacterString escapes quotes, backslashes and non-printable bytes
+// of s, the form miekg/dns keeps character-strings in and packs from
+func escapeCharacterString(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// quoteCharacterString returns a character-string in the escaped form of
+// miekg/dns as a quoted presentation format field
+func quoteCharacterString(escaped string) string {
+	return `"` + escaped + `"`
+}
+
+// parseTXT returns the character-strings of a TXT value, escaped for
+// miekg/dns. A value that starts with a double quote is a list of quoted
+// strings, as in a zone file; any other value is taken literally as a single
+// string. Strings longer than 255 bytes are split into several, which
+// clients join again (RFC 7208 section 3.3).
+func parseTXT(value string) ([]string, error) {
+	strs := []string{value}
+	if strings.HasPrefix(value, `"`) {
+		var err error
+		if strs, err = splitRecordFields(value); err != nil {
+			return nil, err
+		}
+	}
+
+	var txt []string
+	for _, s := range strs {
+		for len(s) > maxCharacterString {
+			txt = append(txt, escapeCharacterString(s[:maxCharacterString]))
+			s = s[maxCharacterString:]
+		}
+		txt = append(txt, escapeCharacterString(s))
+	}
+	return txt, nil
+}
+
+// formatTXT is the inverse of parseTXT for the character-strings of a
+// parsed TXT record: a single plain string is returned as it is, anything
+// else as quoted strings
+func formatTXT(txt []string) string {
+	if len(txt) == 1 && !strings.ContainsAny(txt[0], `\"`) {
+		return txt[0]
+	}
+	quoted := make([]string, len(txt))
+	for i, s := range txt {
+		quoted[i] = quoteCharacterString(s)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// parseCAA parses a CAA value: flags, tag and a value that is either quoted
+// or, for compatibility, the literal rest of the line. The value is returned
+// escaped for miekg/dns.
+func parseCAA(value string) (uint8, string, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), " ", 3)
+	if len(parts) != 3 {
+		return 0, "", "", fmt.Errorf("CAA needs flag, tag and value")
+	}
+	flags, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("invalid CAA flags '%s', must be 0-255", parts[0])
+	}
+	if !isAlphanumeric(parts[1]) {
+		return 0, "", "", fmt.Errorf("invalid CAA tag '%s'", parts[1])
+	}
+
+	caaValue := strings.TrimSpace(parts[2])
+	if strings.HasPrefix(caaValue, `"`) {
+		fields, err := splitRecordFields(caaValue)
+		if err != nil {
+			return 0, "", "", fmt.Errorf("invalid CAA value: %v", err)
+		}
+		if len(fields) != 1 {
+			return 0, "", "", fmt.Errorf("invalid CAA value '%s', must be a single quoted string", caaValue)
+		}
+		caaValue = fields[0]
+	}
+	return uint8(flags), parts[1], escapeCharacterString(caaValue), nil
+}
+
+// parseNAPTR parses a NAPTR value: order, preference, the flags, service and
+// regexp character-strings, usually quoted, and the replacement domain name.
+// The header of the returned record is left empty.
+func parseNAPTR(value string) (*dns.NAPTR, error) {
+	fields, err := splitRecordFields(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("NAPTR needs order, preference, flags, service, regexp and replacement, got %d fields", len(fields))
+	}
+	order, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NAPTR order '%s', must be 0-65535", fields[0])
+	}
+	preference, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NAPTR preference '%s', must be 0-65535", fields[1])
+	}
+	if _, ok := dns.IsDomainName(fields[5]); !ok || fields[5] == "" {
+		return nil, fmt.Errorf("invalid NAPTR replacement '%s'", fields[5])
+	}
+	return &dns.NAPTR{
+		Order:       uint16(order),
+		Preference:  uint16(preference),
+		Flags:       escapeCharacterString(fields[2]),
+		Service:     escapeCharacterString(fields[3]),
+		Regexp:      escapeCharacterString(fields[4]),
+		Replacement: dns.Fqdn(fields[5]),
+	}, nil
+}
+
+// parseHINFO parses an HINFO value: the CPU and OS character-strings. For
+// compatibility, an unquoted value may have spaces in the OS. The header of
+// the returned record is left empty.
+func parseHINFO(value string) (*dns.HINFO, error) {
+	fields, err := splitRecordFields(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) > 2 && !strings.Contains(value, `"`) {
+		fields = []string{fields[0], strings.Join(fields[1:], " ")}
+	}
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("HINFO needs CPU and OS, got %d fields", len(fields))
+	}
+	return &dns.HINFO{Cpu: escapeCharacterString(fields[0]), Os: escapeCharacterString(fields[1])}, nil
+}
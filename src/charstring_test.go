@@ -0,0 +1,201 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// TestSplitRecordFields tests splitting values into fields with quotes and escapes
+func TestSplitRecordFields(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr string
+	}{
+		{`a b  c`, []string{"a", "b", "c"}, ""},
+		{`"a b" c`, []string{"a b", "c"}, ""},
+		{`"" x`, []string{"", "x"}, ""},
+		{`"say \"hi\"" back\\slash`, []string{`say "hi"`, `back\slash`}, ""},
+		{`"\065\066\067" \032`, []string{"ABC", " "}, ""},
+		{`a\ b`, []string{"a b"}, ""},
+		{`alpn="h2,h3" port=443`, []string{"alpn=h2,h3", "port=443"}, ""},
+		{`key="a b"c`, nil, "missing space after quoted string"},
+		{`"open`, nil, "unterminated quoted string"},
+		{`a\`, nil, "trailing backslash"},
+		{`"\12"`, nil, "needs three digits"},
+		{`\256`, nil, "must be at most \\255"},
+		{`a"b"`, nil, "unexpected quote"},
+		{`"a"b`, nil, "missing space after quoted string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := splitRecordFields(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q (%v)", tt.want, got, err)
+			}
+		})
+	}
+}
+
+// TestCharacterStringRecords tests TXT, CAA, NAPTR and HINFO records against
+// the miekg/dns zone parser
+func TestCharacterStringRecords(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	tests := []struct {
+		recordType string
+		value      string
+		zone       string // The same record data in a zone file
+	}{
+		{"TXT", "v=spf1 include:_spf.example.com -all", `"v=spf1 include:_spf.example.com -all"`},
+		{"TXT", `say "hi" \ bye`, `"say \"hi\" \\ bye"`},
+		{"TXT", `"first string" "second \"quoted\" string"`, `"first string" "second \"quoted\" string"`},
+		{"TXT", `"tab\009" "\255"`, `"tab\009" "\255"`},
+		{"TXT", long, `"` + long[:255] + `" "` + long[255:] + `"`},
+		{"CAA", `0 issue "letsencrypt.org"`, `0 issue "letsencrypt.org"`},
+		{"CAA", "0 issue letsencrypt.org", `0 issue "letsencrypt.org"`},
+		{"CAA", `0 iodef "mailto:security@example.com"`, `0 iodef "mailto:security@example.com"`},
+		{"CAA", `128 issue "ca.example.net; account=230123"`, `128 issue "ca.example.net; account=230123"`},
+		{"NAPTR", `100 10 "U" "E2U+sip" "!^.*$!sip:info@example.com!" .`, `100 10 "U" "E2U+sip" "!^.*$!sip:info@example.com!" .`},
+		{"NAPTR", `100 10 "" "" "/urn:cid:.+@([^\\.]+\\.)(.*)$/\\2/i" .`, `100 10 "" "" "/urn:cid:.+@([^\\.]+\\.)(.*)$/\\2/i" .`},
+		{"NAPTR", `10 20 "S" "SIP+D2U" "!^(.*) x$!\\1!" _sip._udp.example.com`, `10 20 "S" "SIP+D2U" "!^(.*) x$!\\1!" _sip._udp.example.com.`},
+		{"HINFO", "x86_64 Linux 6.1", `"x86_64" "Linux 6.1"`},
+		{"HINFO", `"PDP 11" "UNIX V6"`, `"PDP 11" "UNIX V6"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.recordType+" "+tt.value, func(t *testing.T) {
+			record := DNSRecord{Name: "example.com", Type: tt.recordType, Value: tt.value, TTL: 3600}
+			if err := validateRecordValue(record); err != nil {
+				t.Fatalf("Validation failed: %v", err)
+			}
+			rr := createRR(record, "example.com.", dns.StringToType[tt.recordType])
+			if rr == nil {
+				t.Fatal("createRR returned nil")
+			}
+			want, err := dns.NewRR("example.com. 3600 IN " + tt.recordType + " " + tt.zone)
+			if err != nil {
+				t.Fatalf("miekg/dns rejects %q: %v", tt.zone, err)
+			}
+			if !dns.IsDuplicate(rr, want) {
+				t.Errorf("Parsed differently from miekg/dns:\nwant %v\ngot  %v", want, rr)
+			}
+
+			// The wire format decodes to the same record
+			msg := new(dns.Msg)
+			msg.Answer = []dns.RR{rr}
+			packed, err := msg.Pack()
+			if err != nil {
+				t.Fatalf("Pack failed: %v", err)
+			}
+			if err := msg.Unpack(packed); err != nil || !dns.IsDuplicate(msg.Answer[0], want) {
+				t.Errorf("Wire format differs:\nwant %v\ngot  %v (%v)", want, msg.Answer, err)
+			}
+
+			// Records from zone files and transfers convert back to the same data
+			converted, err := recordFromRR(want)
+			if err != nil {
+				t.Fatalf("recordFromRR failed: %v", err)
+			}
+			if again := createRR(converted, "example.com.", dns.StringToType[tt.recordType]); again == nil || !dns.IsDuplicate(again, want) {
+				t.Errorf("Value %q from recordFromRR gives %v", converted.Value, again)
+			}
+		})
+	}
+}
+
+// TestCharacterStringErrors tests that malformed values are reported
+func TestCharacterStringErrors(t *testing.T) {
+	tests := []struct {
+		recordType string
+		value      string
+		wantErr    string
+	}{
+		{"TXT", `"unterminated`, "unterminated quoted string"},
+		{"CAA", `0 issue "a" "b"`, "must be a single quoted string"},
+		{"CAA", `0 issue "letsencrypt.org`, "unterminated quoted string"},
+		{"NAPTR", `100 10 "U" "E2U+sip" !^.*$!sip:info@example.com!`, "NAPTR needs order, preference, flags, service, regexp and replacement"},
+		{"NAPTR", `100 10 U E2U+sip !^.* $!sip:x! .`, "got 7 fields"},
+		{"NAPTR", `70000 10 "U" "E2U+sip" "" .`, "invalid NAPTR order '70000'"},
+		{"NAPTR", `100 x "U" "E2U+sip" "" .`, "invalid NAPTR preference 'x'"},
+		{"NAPTR", `100 10 "U" "E2U+sip" "" ..`, "invalid NAPTR replacement '..'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.recordType+" "+tt.value, func(t *testing.T) {
+			err := validateRecordValue(DNSRecord{Name: "example.com", Type: tt.recordType, Value: tt.value})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestMultiStringTXT tests TXT records with several strings from CSV and
+// multi-record JSON
+func TestMultiStringTXT(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	t.Run("CSV", func(t *testing.T) {
+		tmpFile, err := createTempCSV("name,type,value,ttl\n" +
+			`example.com,TXT,"""v=DKIM1; k=rsa; "" ""p=MIGfMA0""",3600` + "\n" +
+			"long.example.com,TXT," + strings.Repeat("x", 600) + ",3600\n")
+		if err != nil {
+			t.Fatalf("Failed to create temp CSV: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+
+		store, err := buildRecordStore(func() (*RecordStore, error) { return loadRecordsFromCSV(tmpFile.Name()) })
+		if err != nil {
+			t.Fatalf("Failed to load CSV: %v", err)
+		}
+		rrs := store.lookupRecord("example.com.", dns.TypeTXT)
+		if len(rrs) != 1 || !reflect.DeepEqual(rrs[0].(*dns.TXT).Txt, []string{"v=DKIM1; k=rsa; ", "p=MIGfMA0"}) {
+			t.Errorf("Unexpected TXT records: %v", rrs)
+		}
+		rrs = store.lookupRecord("long.example.com.", dns.TypeTXT)
+		if len(rrs) != 1 {
+			t.Fatalf("Expected one TXT record, got %v", rrs)
+		}
+		var lengths []int
+		for _, s := range rrs[0].(*dns.TXT).Txt {
+			lengths = append(lengths, len(s))
+		}
+		if !reflect.DeepEqual(lengths, []int{255, 255, 90}) {
+			t.Errorf("Expected the long TXT split into 255 byte strings, got lengths %v", lengths)
+		}
+	})
+
+	t.Run("Multi-record JSON", func(t *testing.T) {
+		multiRecord, ok := base32ToJSON("PMRFIWCUEI5FWITBEBRCELBCMMRF27I8")
+		if !ok {
+			t.Fatal(`Failed to parse {"TXT":["a b","c"]}`)
+		}
+		rr := createRRFromMultiRecord(multiRecord, "test.2dns.dev", dns.TypeTXT)
+		if txt, ok := rr.(*dns.TXT); !ok || !reflect.DeepEqual(txt.Txt, []string{"a b", "c"}) {
+			t.Errorf("Expected TXT strings [a b c], got %v", rr)
+		}
+
+		var multi MultiRecord
+		if err := multi.UnmarshalJSON([]byte(`{"TXT":["say \"hi\""],"A":"192.0.2.1"}`)); err != nil {
+			t.Fatalf("UnmarshalJSON failed: %v", err)
+		}
+		if multi["TXT"] != `"say \"hi\""` || multi["A"] != "192.0.2.1" {
+			t.Errorf("Unexpected multi-record: %v", multi)
+		}
+		if err := multi.UnmarshalJSON([]byte(`{"TXT":[1,2]}`)); err == nil {
+			t.Error("Expected an error for an array of numbers")
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain limits how many CNAMEs are followed for a single question
+const maxCNAMEChain = 8
+
+// cnameTarget returns the lowercased target of the CNAME in records, or ""
+func cnameTarget(records []dns.RR) string {
+	for _, rr := range records {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			return strings.ToLower(cname.Target)
+		}
+	}
+	return ""
+}
+
+// isLocalName reports whether the store is authoritative for name, either
+// because it lies inside one of the store's zones or because the store has
+// records for it
+func isLocalName(store RecordBackend, name string) bool {
+	return store.findSOA(name) != nil || store.lookupName(name) != nameMissing
+}
+
+// chaseCNAME follows the CNAME chain that starts with answer and appends the
+// records of each in-zone target to msg.Answer, so clients get the final
+// RRset without having to re-query. Targets below a DNAME are rewritten on
+// the way. The chase stops at targets outside our zones or below a zone cut
+// (the client's resolver continues from there), on loops and after
+// maxCNAMEChain steps. If an in-zone target has no records of the requested
+// type, the response carries the target's NXDOMAIN or NODATA status.
+func chaseCNAME(msg *dns.Msg, store RecordBackend, answer []dns.RR, q dns.Question) {
+	if q.Qtype == dns.TypeCNAME || q.Qtype == dns.TypeANY {
+		return
+	}
+
+	visited := map[string]bool{strings.ToLower(dns.Fqdn(q.Name)): true}
+	for depth := 0; ; depth++ {
+		target := cnameTarget(answer)
+		if target == "" {
+			return
+		}
+		if visited[target] {
+			if config.VerboseLogging {
+				log.Printf("CNAME loop detected at %s while resolving %s", target, q.Name)
+			}
+			return
+		}
+		if depth >= maxCNAMEChain {
+			if config.VerboseLogging {
+				log.Printf("CNAME chain for %s longer than %d, not following %s", q.Name, maxCNAMEChain, target)
+			}
+			return
+		}
+		visited[target] = true
+
+		if !isLocalName(store, target) || findDelegation(store, target, q.Qtype) != nil {
+			return
+		}
+
+		var rcode int
+		answer, rcode = lookupWithDNAME(store, target, q.Qtype)
+		if rcode != dns.RcodeSuccess {
+			msg.Answer = append(msg.Answer, answer...)
+			msg.Rcode = rcode
+			return
+		}
+		if len(answer) == 0 && inReflectionDomain(target) {
+			if rr := reflectRecord(target, q.Qtype); rr != nil {
+				answer = []dns.RR{rr}
+			}
+		}
+		if len(answer) == 0 {
+			setNegativeResponse(msg, store, dns.Question{Name: target, Qtype: q.Qtype, Qclass: q.Qclass})
+			return
+		}
+
+		msg.Answer = append(msg.Answer, answer...)
+	}
+}
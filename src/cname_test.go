@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// TestCNAMEChasing tests following CNAME chains inside the record store
+func TestCNAMEChasing(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	store := newRecordStore()
+	for _, record := range []DNSRecord{
+		{Name: "example.com", Type: "SOA", Value: "ns1.example.com. admin.example.com. 2025050801 3600 1800 604800 300", TTL: 3600},
+		{Name: "example.com", Type: "A", Value: "192.168.1.1", TTL: 3600},
+		{Name: "example.com", Type: "AAAA", Value: "2001:db8::1", TTL: 3600},
+		{Name: "www.example.com", Type: "CNAME", Value: "example.com", TTL: 3600},
+		{Name: "chain.example.com", Type: "CNAME", Value: "www.example.com", TTL: 3600},
+		{Name: "external.example.com", Type: "CNAME", Value: "www.example.net", TTL: 3600},
+		{Name: "dangling.example.com", Type: "CNAME", Value: "missing.example.com", TTL: 3600},
+		{Name: "loop1.example.com", Type: "CNAME", Value: "loop2.example.com", TTL: 3600},
+		{Name: "loop2.example.com", Type: "CNAME", Value: "loop1.example.com", TTL: 3600},
+		{Name: "*.wild.example.com", Type: "CNAME", Value: "example.com", TTL: 3600},
+		{Name: "reflect.example.com", Type: "CNAME", Value: "10.0.0.1.example.com", TTL: 3600},
+	} {
+		store.addRecord(record)
+	}
+	// A chain longer than maxCNAMEChain
+	for i := 0; i <= maxCNAMEChain+1; i++ {
+		store.addRecord(DNSRecord{Name: chainName(i), Type: "CNAME", Value: chainName(i + 1), TTL: 3600})
+	}
+	store.addRecord(DNSRecord{Name: chainName(maxCNAMEChain + 2), Type: "A", Value: "192.168.1.9", TTL: 3600})
+	recordStore = store
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		wantTypes []uint16
+		wantRcode int
+	}{
+		{"CNAME to apex A", "www.example.com.", dns.TypeA, []uint16{dns.TypeCNAME, dns.TypeA}, dns.RcodeSuccess},
+		{"CNAME to apex AAAA", "www.example.com.", dns.TypeAAAA, []uint16{dns.TypeCNAME, dns.TypeAAAA}, dns.RcodeSuccess},
+		{"Two step chain", "chain.example.com.", dns.TypeA, []uint16{dns.TypeCNAME, dns.TypeCNAME, dns.TypeA}, dns.RcodeSuccess},
+		{"CNAME query is not chased", "chain.example.com.", dns.TypeCNAME, []uint16{dns.TypeCNAME}, dns.RcodeSuccess},
+		{"Out-of-zone target", "external.example.com.", dns.TypeA, []uint16{dns.TypeCNAME}, dns.RcodeSuccess},
+		{"Target does not exist", "dangling.example.com.", dns.TypeA, []uint16{dns.TypeCNAME}, dns.RcodeNameError},
+		{"Target has no data of type", "www.example.com.", dns.TypeMX, []uint16{dns.TypeCNAME}, dns.RcodeSuccess},
+		{"Loop", "loop1.example.com.", dns.TypeA, []uint16{dns.TypeCNAME, dns.TypeCNAME}, dns.RcodeSuccess},
+		{"Wildcard CNAME", "host.wild.example.com.", dns.TypeA, []uint16{dns.TypeCNAME, dns.TypeA}, dns.RcodeSuccess},
+		{"Reflection target", "reflect.example.com.", dns.TypeA, []uint16{dns.TypeCNAME, dns.TypeA}, dns.RcodeSuccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, tt.qtype)
+
+			w := newMockResponseWriter()
+			handleDNSRequest(w, req)
+
+			if w.msg.Rcode != tt.wantRcode {
+				t.Errorf("Expected rcode %s, got %s", dns.RcodeToString[tt.wantRcode], dns.RcodeToString[w.msg.Rcode])
+			}
+			if len(w.msg.Answer) != len(tt.wantTypes) {
+				t.Fatalf("Expected %d answers, got %d: %v", len(tt.wantTypes), len(w.msg.Answer), w.msg.Answer)
+			}
+			for i, rr := range w.msg.Answer {
+				if rr.Header().Rrtype != tt.wantTypes[i] {
+					t.Errorf("Answer %d: expected %s, got %s", i, dns.TypeToString[tt.wantTypes[i]], dns.TypeToString[rr.Header().Rrtype])
+				}
+			}
+		})
+	}
+
+	t.Run("Depth limit", func(t *testing.T) {
+		req := new(dns.Msg)
+		req.SetQuestion(dns.Fqdn(chainName(0)), dns.TypeA)
+
+		w := newMockResponseWriter()
+		handleDNSRequest(w, req)
+
+		if len(w.msg.Answer) != maxCNAMEChain+1 {
+			t.Errorf("Expected %d CNAMEs, got %d answers", maxCNAMEChain+1, len(w.msg.Answer))
+		}
+		for _, rr := range w.msg.Answer {
+			if rr.Header().Rrtype != dns.TypeCNAME {
+				t.Errorf("Expected only CNAMEs before the depth limit, got %s", rr)
+			}
+		}
+	})
+}
+
+func chainName(i int) string {
+	return "c" + string(rune('a'+i)) + ".example.com"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/miekg/dns"
+)
+
+// compiledRecords holds the records of one owner name compiled into resource
+// records once, so queries only need to copy them
+type compiledRecords struct {
+	rrsets  map[uint16][]dns.RR // Records by type, a TTL of 0 means the default TTL
+	dynamic []DNSRecord         // ALIAS and ANAME records, resolved at query time
+	zeroTTL map[dns.RR]bool     // Records with an explicit TTL of 0, served without the default TTL
+}
+
+// compile builds the store's index, compiling its records ahead of the first query
+func (store *RecordStore) compile() {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	store.getIndex()
+}
+
+// compileRecords turns the records owned by owner into resource records.
+// Records that can't be represented are left out; validate reports them at
+// load time.
+func compileRecords(owner string, records []DNSRecord) *compiledRecords {
+	compiled := &compiledRecords{rrsets: make(map[uint16][]dns.RR)}
+	for _, record := range records {
+		if record.Type == "ALIAS" || record.Type == "ANAME" {
+			compiled.dynamic = append(compiled.dynamic, record)
+			continue
+		}
+		qtype, ok := dns.StringToType[record.Type]
+		if !ok {
+			continue
+		}
+		rr := createRR(record, owner, qtype)
+		if rr == nil {
+			continue
+		}
+		// The default TTL is applied when the record is served, as it depends on the run mode
+		rr.Header().Ttl = record.TTL
+		if record.TTL == 0 && record.ZeroTTL {
+			if compiled.zeroTTL == nil {
+				compiled.zeroTTL = make(map[dns.RR]bool)
+			}
+			compiled.zeroTTL[rr] = true
+		}
+		compiled.rrsets[qtype] = append(compiled.rrsets[qtype], rr)
+	}
+	return compiled
+}
+
+// answer returns copies of the qtype records owned by name, including those
+// ALIAS and ANAME records resolve to in store or upstream. If there are none
+// but a CNAME is owned by name, the CNAME is returned instead.
+func (compiled *compiledRecords) answer(store RecordBackend, name string, qtype uint16) []dns.RR {
+	if compiled == nil {
+		return nil
+	}
+
+	result := compiled.rrset(name, qtype)
+	for _, record := range compiled.dynamic {
+		result = append(result, createAliasRRs(store, record, name, qtype)...)
+	}
+	if len(result) == 0 && qtype != dns.TypeCNAME {
+		result = compiled.rrset(name, dns.TypeCNAME)
+	}
+	return result
+}
+
+// rrset returns copies of the stored qtype records, owned by name
+func (compiled *compiledRecords) rrset(name string, qtype uint16) []dns.RR {
+	if compiled == nil {
+		return nil
+	}
+
+	owner := dns.Fqdn(name)
+	var result []dns.RR
+	for _, rr := range compiled.rrsets[qtype] {
+		result = append(result, ownedCopy(rr, owner, !compiled.zeroTTL[rr]))
+	}
+	return result
+}
+
+// ownedCopy returns a copy of a compiled record owned by owner, with the
+// default TTL filled in if defaultTTL is set and the record has no TTL
+func ownedCopy(rr dns.RR, owner string, defaultTTL bool) dns.RR {
+	rr = dns.Copy(rr)
+	hdr := rr.Header()
+	hdr.Name = owner
+	if hdr.Ttl == 0 && defaultTTL {
+		hdr.Ttl = config.TTL
+	}
+	return rr
+}
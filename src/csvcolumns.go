@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// csvColumnNames are the columns the CSV loader understands. The header row
+// decides where each of them is; name, type and value are required, columns
+// with other names are ignored so files can carry extra data.
+var csvColumnNames = []string{
+	"name", "type", "value", "ttl", "priority", "weight", "port",
+	"class", "enabled", "comment", "tags",
+}
+
+// csvColumns maps column names to their position in a CSV file
+type csvColumns map[string]int
+
+// parseCSVHeader reads the column mapping from the header row
+func parseCSVHeader(header []string) (csvColumns, error) {
+	known := make(map[string]bool, len(csvColumnNames))
+	for _, name := range csvColumnNames {
+		known[name] = true
+	}
+
+	columns := make(csvColumns)
+	for i, field := range header {
+		if i == 0 {
+			// Spreadsheets like to start UTF-8 files with a byte order mark
+			field = strings.TrimPrefix(field, "\ufeff")
+		}
+		name := strings.ToLower(strings.TrimSpace(field))
+		if !known[name] {
+			continue
+		}
+		if _, dup := columns[name]; dup {
+			return nil, fmt.Errorf("CSV header has more than one '%s' column", name)
+		}
+		columns[name] = i
+	}
+
+	for _, required := range []string{"name", "type", "value"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the '%s' column", required)
+		}
+	}
+	return columns, nil
+}
+
+// required returns how many fields a row needs to hold the name, type and value
+func (columns csvColumns) required() int {
+	return max(columns["name"], columns["type"], columns["value"]) + 1
+}
+
+// get returns the trimmed field of column in row, or "" if the file or row
+// does not have it
+func (columns csvColumns) get(row []string, column string) string {
+	i, ok := columns[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+// parseEnabled parses the enabled column. An empty field means enabled.
+func parseEnabled(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "true", "yes", "y", "on", "1":
+		return true, nil
+	case "false", "no", "n", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid enabled value '%s', must be true or false", value)
+}
+
+// parseTags splits the tags column on commas, semicolons and spaces
+func parseTags(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || unicode.IsSpace(r)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// TestParseCSVHeader tests mapping columns from the header row
+func TestParseCSVHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []string
+		want    csvColumns
+		wantErr string
+	}{
+		{
+			name:   "Classic layout",
+			header: []string{"name", "type", "value", "ttl", "priority", "weight", "port"},
+			want:   csvColumns{"name": 0, "type": 1, "value": 2, "ttl": 3, "priority": 4, "weight": 5, "port": 6},
+		},
+		{
+			name:   "Reordered with unknown columns",
+			header: []string{"\ufeffType", " owner ", "NAME", "value", "Enabled"},
+			want:   csvColumns{"type": 0, "name": 2, "value": 3, "enabled": 4},
+		},
+		{
+			name:    "Missing value",
+			header:  []string{"name", "type", "ttl"},
+			wantErr: "missing the 'value' column",
+		},
+		{
+			name:    "Duplicate column",
+			header:  []string{"name", "type", "value", "ttl", "TTL"},
+			wantErr: "more than one 'ttl' column",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCSVHeader(tt.header)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestCSVOptionalColumns tests loading a file with reordered and optional columns and comment lines
+func TestCSVOptionalColumns(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	tmpFile, err := createTempCSV(`# Records owned by the web team
+type,name,value,class,ttl,enabled,comment,tags,owner
+A,example.com,192.0.2.1,IN,300,,Load balancer,"web, prod",web-team
+# Old server, kept for reference
+A,old.example.com,192.0.2.2,,300,false,Decommissioned,,web-team
+A,staging.example.com,192.0.2.3,in,,yes,,staging,web-team`)
+	if err != nil {
+		t.Fatalf("Failed to create temp CSV: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	store, err := loadRecordsFromCSV(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load CSV: %v", err)
+	}
+
+	records := store.Records["example.com"]
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record for example.com, got %d", len(records))
+	}
+	record := records[0]
+	if record.Value != "192.0.2.1" || record.TTL != 300 || record.Line != 3 {
+		t.Errorf("Unexpected record: %+v", record)
+	}
+	if record.Comment != "Load balancer" || !reflect.DeepEqual(record.Tags, []string{"web", "prod"}) {
+		t.Errorf("Expected comment and tags to be kept, got %q %v", record.Comment, record.Tags)
+	}
+
+	if store.nameExists("old.example.com") {
+		t.Errorf("Expected the disabled record not to be loaded")
+	}
+	if rrs := store.lookupRecord("staging.example.com", dns.TypeA); len(rrs) != 1 {
+		t.Errorf("Expected 1 A record for staging.example.com, got %v", rrs)
+	}
+
+	t.Run("Invalid optional columns", func(t *testing.T) {
+		tmpFile, err := createTempCSV(`name,type,value,class,enabled
+# comment lines still count towards line numbers
+example.com,A,192.0.2.1,CH,
+example.com,A,192.0.2.2,,maybe`)
+		if err != nil {
+			t.Fatalf("Failed to create temp CSV: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+
+		_, err = loadRecordsFromCSV(tmpFile.Name())
+		if err == nil {
+			t.Fatal("Expected loading to fail")
+		}
+		for _, want := range []string{"line 3: unsupported class CH", "line 4: invalid enabled value 'maybe'"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Expected error containing %q, got %v", want, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// findDelegation returns the NS RRset of the zone cut at or above name, or nil
+// if name is not delegated away from the zone it lies in. A zone cut is a
+// name below a zone apex that owns NS records but no SOA; the topmost cut
+// wins as everything below it belongs to the child zone. NS records owned by
+// a wildcard are served as data and never delegate.
+func findDelegation(store RecordBackend, name string, qtype uint16) []*dns.NS {
+	soa := store.findSOA(name)
+	if soa == nil {
+		return nil
+	}
+
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	for i := len(labels) - dns.CountLabel(soa.Hdr.Name) - 1; i >= 0; i-- {
+		owner := strings.Join(labels[i:], ".")
+		// The DS RRset at a cut belongs to the parent side (RFC 4035 section 3.1.4.1)
+		if i == 0 && qtype == dns.TypeDS {
+			return nil
+		}
+		if !store.nameExists(owner) {
+			continue
+		}
+
+		var nsset []*dns.NS
+		for _, rr := range store.lookupRecord(owner, dns.TypeNS) {
+			if ns, ok := rr.(*dns.NS); ok {
+				nsset = append(nsset, ns)
+			}
+		}
+		if len(nsset) > 0 {
+			return nsset
+		}
+	}
+	return nil
+}
+
+// setReferral turns msg into a referral to the child zone's name servers.
+// Glue addresses are added to the ADDITIONAL section by addAdditionalSection.
+func setReferral(msg *dns.Msg, nsset []*dns.NS) {
+	msg.Authoritative = false
+	for _, ns := range nsset {
+		msg.Ns = append(msg.Ns, ns)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// TestDelegation tests referrals for names at and below a zone cut
+func TestDelegation(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	store := newRecordStore()
+	for _, record := range []DNSRecord{
+		{Name: "example.com", Type: "SOA", Value: "ns1.example.com. admin.example.com. 2025050801 3600 1800 604800 300", TTL: 3600},
+		{Name: "example.com", Type: "NS", Value: "ns1.example.com", TTL: 3600},
+		{Name: "ns1.example.com", Type: "A", Value: "192.168.1.53", TTL: 3600},
+		{Name: "customer.example.com", Type: "NS", Value: "ns1.customer.example.com", TTL: 3600},
+		{Name: "customer.example.com", Type: "NS", Value: "ns.example.net", TTL: 3600},
+		{Name: "ns1.customer.example.com", Type: "A", Value: "192.168.3.53", TTL: 3600},
+		{Name: "ns1.customer.example.com", Type: "AAAA", Value: "2001:db8:3::53", TTL: 3600},
+		{Name: "hosted.example.com", Type: "SOA", Value: "ns1.example.com. admin.example.com. 2025050801 3600 1800 604800 300", TTL: 3600},
+		{Name: "hosted.example.com", Type: "NS", Value: "ns1.example.com", TTL: 3600},
+		{Name: "www.hosted.example.com", Type: "A", Value: "192.168.4.1", TTL: 3600},
+		{Name: "*.wild.example.com", Type: "NS", Value: "ns1.example.com", TTL: 3600},
+		{Name: "alias.example.com", Type: "CNAME", Value: "www.customer.example.com", TTL: 3600},
+	} {
+		store.addRecord(record)
+	}
+	recordStore = store
+
+	tests := []struct {
+		name         string
+		qname        string
+		qtype        uint16
+		wantReferral bool
+		wantAnswers  int
+		wantGlue     int
+	}{
+		{"Query at the cut", "customer.example.com.", dns.TypeA, true, 0, 2},
+		{"NS query at the cut", "customer.example.com.", dns.TypeNS, true, 0, 2},
+		{"Query below the cut", "www.customer.example.com.", dns.TypeA, true, 0, 2},
+		{"Glue name is referred too", "ns1.customer.example.com.", dns.TypeA, true, 0, 2},
+		{"DS at the cut is answered by the parent", "customer.example.com.", dns.TypeDS, false, 0, 0},
+		{"Child zone hosted here is authoritative", "www.hosted.example.com.", dns.TypeA, false, 1, 1},
+		{"Wildcard NS does not delegate", "host.wild.example.com.", dns.TypeNS, false, 1, 1},
+		{"CNAME into a delegation is not chased", "alias.example.com.", dns.TypeA, false, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, tt.qtype)
+
+			w := newMockResponseWriter()
+			handleDNSRequest(w, req)
+
+			if w.msg.Rcode != dns.RcodeSuccess {
+				t.Errorf("Expected NOERROR, got %s", dns.RcodeToString[w.msg.Rcode])
+			}
+			if w.msg.Authoritative == tt.wantReferral {
+				t.Errorf("Expected AA=%v, got %v", !tt.wantReferral, w.msg.Authoritative)
+			}
+			if len(w.msg.Answer) != tt.wantAnswers {
+				t.Errorf("Expected %d answers, got %d: %v", tt.wantAnswers, len(w.msg.Answer), w.msg.Answer)
+			}
+			if len(w.msg.Extra) != tt.wantGlue {
+				t.Errorf("Expected %d additional records, got %d: %v", tt.wantGlue, len(w.msg.Extra), w.msg.Extra)
+			}
+
+			if tt.wantReferral {
+				if len(w.msg.Ns) != 2 {
+					t.Fatalf("Expected the 2 delegation NS records, got %v", w.msg.Ns)
+				}
+				for _, rr := range w.msg.Ns {
+					if rr.Header().Rrtype != dns.TypeNS || rr.Header().Name != "customer.example.com." {
+						t.Errorf("Expected NS owned by the cut, got %s", rr)
+					}
+				}
+			}
+		})
+	}
+}
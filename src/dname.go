@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// findDNAME returns the DNAME owned by a strict ancestor of name, or nil.
+// Ancestors are checked from the top down, so the DNAME closest to the zone
+// apex wins as it occludes everything below it.
+func findDNAME(store RecordBackend, name string) *dns.DNAME {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	for i := len(labels) - 1; i >= 1; i-- {
+		owner := strings.Join(labels[i:], ".")
+		if !store.nameExists(owner) {
+			continue
+		}
+		for _, rr := range store.lookupRecord(owner, dns.TypeDNAME) {
+			if dname, ok := rr.(*dns.DNAME); ok {
+				return dname
+			}
+		}
+	}
+	return nil
+}
+
+// substituteDNAME rewrites qname by replacing the DNAME owner suffix with the
+// DNAME target (RFC 6672 section 2.2). It returns false if the result would
+// be longer than a domain name may be.
+func substituteDNAME(qname string, dname *dns.DNAME) (string, bool) {
+	qname = dns.Fqdn(qname)
+	prefix := qname[:len(qname)-len(dname.Hdr.Name)]
+	newName := prefix + dname.Target
+	if _, ok := dns.IsDomainName(newName); !ok || len(newName) > 255 {
+		return "", false
+	}
+	return newName, true
+}
+
+// lookupWithDNAME looks up name like lookupRecord, except that a name below a
+// DNAME is answered with the DNAME and a CNAME synthesised to the rewritten
+// name (RFC 6672 section 3.3), which chaseCNAME then follows. The returned
+// rcode is YXDOMAIN if the rewritten name would be too long.
+func lookupWithDNAME(store RecordBackend, name string, qtype uint16) ([]dns.RR, int) {
+	dname := findDNAME(store, name)
+	if dname == nil {
+		return store.lookupRecord(name, qtype), dns.RcodeSuccess
+	}
+
+	newName, ok := substituteDNAME(name, dname)
+	if !ok {
+		return []dns.RR{dname}, dns.RcodeYXDomain
+	}
+
+	cname := &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(name),
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    dname.Hdr.Ttl,
+		},
+		Target: newName,
+	}
+	return []dns.RR{dname, cname}, dns.RcodeSuccess
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// TestDNAMESubstitution tests DNAME rewriting of names below the DNAME owner
+func TestDNAMESubstitution(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	store := newRecordStore()
+	for _, record := range []DNSRecord{
+		{Name: "example.com", Type: "SOA", Value: "ns1.example.com. admin.example.com. 2025050801 3600 1800 604800 300", TTL: 3600},
+		{Name: "old.example.com", Type: "DNAME", Value: "new.example.com", TTL: 600},
+		{Name: "old.example.com", Type: "A", Value: "192.168.1.20", TTL: 3600},
+		{Name: "host.new.example.com", Type: "A", Value: "192.168.1.21", TTL: 3600},
+		{Name: "alias.example.com", Type: "CNAME", Value: "host.old.example.com", TTL: 3600},
+		{Name: "moved.example.com", Type: "DNAME", Value: "example.net", TTL: 3600},
+		{Name: "long.example.com", Type: "DNAME", Value: strings.Repeat("a", 63) + "." + strings.Repeat("b", 63) + "." + strings.Repeat("c", 63) + ".example.net", TTL: 3600},
+	} {
+		store.addRecord(record)
+	}
+	recordStore = store
+
+	tests := []struct {
+		name        string
+		qname       string
+		qtype       uint16
+		wantTypes   []uint16
+		wantRcode   int
+		wantCNAMETo string
+	}{
+		{
+			name:        "Name below DNAME is rewritten and chased",
+			qname:       "host.old.example.com.",
+			qtype:       dns.TypeA,
+			wantTypes:   []uint16{dns.TypeDNAME, dns.TypeCNAME, dns.TypeA},
+			wantRcode:   dns.RcodeSuccess,
+			wantCNAMETo: "host.new.example.com.",
+		},
+		{
+			name:      "DNAME owner itself is not rewritten",
+			qname:     "old.example.com.",
+			qtype:     dns.TypeA,
+			wantTypes: []uint16{dns.TypeA},
+			wantRcode: dns.RcodeSuccess,
+		},
+		{
+			name:      "DNAME query at owner",
+			qname:     "old.example.com.",
+			qtype:     dns.TypeDNAME,
+			wantTypes: []uint16{dns.TypeDNAME},
+			wantRcode: dns.RcodeSuccess,
+		},
+		{
+			name:        "Rewritten name does not exist",
+			qname:       "missing.old.example.com.",
+			qtype:       dns.TypeA,
+			wantTypes:   []uint16{dns.TypeDNAME, dns.TypeCNAME},
+			wantRcode:   dns.RcodeNameError,
+			wantCNAMETo: "missing.new.example.com.",
+		},
+		{
+			name:        "Rewritten name outside our zones",
+			qname:       "www.moved.example.com.",
+			qtype:       dns.TypeA,
+			wantTypes:   []uint16{dns.TypeDNAME, dns.TypeCNAME},
+			wantRcode:   dns.RcodeSuccess,
+			wantCNAMETo: "www.example.net.",
+		},
+		{
+			name:      "CNAME into a DNAME subtree",
+			qname:     "alias.example.com.",
+			qtype:     dns.TypeA,
+			wantTypes: []uint16{dns.TypeCNAME, dns.TypeDNAME, dns.TypeCNAME, dns.TypeA},
+			wantRcode: dns.RcodeSuccess,
+		},
+		{
+			name:      "Rewritten name too long",
+			qname:     strings.Repeat("d", 63) + ".long.example.com.",
+			qtype:     dns.TypeA,
+			wantTypes: []uint16{dns.TypeDNAME},
+			wantRcode: dns.RcodeYXDomain,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, tt.qtype)
+
+			w := newMockResponseWriter()
+			handleDNSRequest(w, req)
+
+			if w.msg.Rcode != tt.wantRcode {
+				t.Errorf("Expected rcode %s, got %s", dns.RcodeToString[tt.wantRcode], dns.RcodeToString[w.msg.Rcode])
+			}
+			if len(w.msg.Answer) != len(tt.wantTypes) {
+				t.Fatalf("Expected %d answers, got %d: %v", len(tt.wantTypes), len(w.msg.Answer), w.msg.Answer)
+			}
+			for i, rr := range w.msg.Answer {
+				if rr.Header().Rrtype != tt.wantTypes[i] {
+					t.Errorf("Answer %d: expected %s, got %s", i, dns.TypeToString[tt.wantTypes[i]], dns.TypeToString[rr.Header().Rrtype])
+				}
+			}
+
+			if tt.wantCNAMETo != "" {
+				cname := w.msg.Answer[1].(*dns.CNAME)
+				if cname.Hdr.Name != tt.qname || cname.Target != tt.wantCNAMETo {
+					t.Errorf("Expected synthesised CNAME %s -> %s, got %s", tt.qname, tt.wantCNAMETo, cname)
+				}
+				// The synthesised CNAME takes the DNAME's TTL
+				if cname.Hdr.Ttl != w.msg.Answer[0].Header().Ttl {
+					t.Errorf("Expected CNAME TTL %d, got %d", w.msg.Answer[0].Header().Ttl, cname.Hdr.Ttl)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"container/list"
+	"crypto"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Reflection and multi-record answers are synthesised per query, so zones
+// can't be signed ahead of time. Instead, responses to queries with the DO
+// bit are signed as they are sent (online signing), and the signatures are
+// cached until half their validity has passed.
+
+const (
+	// signatureValidity is how long a signature is valid from the time it is made
+	signatureValidity = 7 * 24 * time.Hour
+	// signatureBackdate moves the inception into the past for clients with slow clocks
+	signatureBackdate = time.Hour
+	// maxSignatureCacheEntries caps the number of cached signatures
+	maxSignatureCacheEntries = 100000
+)
+
+// dnssecKey is a zone key with its private key
+type dnssecKey struct {
+	dnskey *dns.DNSKEY
+	signer crypto.Signer
+	tag    uint16
+}
+
+// ksk reports whether the key is a key signing key (SEP flag set)
+func (key *dnssecKey) ksk() bool {
+	return key.dnskey.Flags&dns.SEP != 0
+}
+
+// signedZone is a zone whose answers are signed online
+type signedZone struct {
+	apex string // Canonical apex name
+	keys []*dnssecKey
+}
+
+// signingKeys returns the keys that sign RRsets of qtype: the KSKs sign the
+// DNSKEY RRset and the ZSKs everything else. If a zone only has one kind of
+// key, it signs everything.
+func (zone *signedZone) signingKeys(qtype uint16) []*dnssecKey {
+	var keys []*dnssecKey
+	for _, key := range zone.keys {
+		if key.ksk() == (qtype == dns.TypeDNSKEY) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return zone.keys
+	}
+	return keys
+}
+
+// dnssecKeysFlag collects repeated -dnssec-key flags into the signed zones,
+// by canonical apex name
+type dnssecKeysFlag map[string]*signedZone
+
+func (f *dnssecKeysFlag) String() string {
+	var zones []string
+	for apex := range *f {
+		zones = append(zones, apex)
+	}
+	sort.Strings(zones)
+	return strings.Join(zones, ",")
+}
+
+func (f *dnssecKeysFlag) Set(value string) error {
+	key, err := loadDNSSECKey(value)
+	if err != nil {
+		return err
+	}
+	if *f == nil {
+		*f = make(dnssecKeysFlag)
+	}
+	apex := dns.CanonicalName(key.dnskey.Hdr.Name)
+	zone := (*f)[apex]
+	if zone == nil {
+		zone = &signedZone{apex: apex}
+		(*f)[apex] = zone
+	}
+	zone.keys = append(zone.keys, key)
+	return nil
+}
+
+// loadDNSSECKey reads a key pair in the BIND format of dnssec-keygen: the
+// DNSKEY record in <base>.key and the private key in <base>.private. path may
+// name either file or their common base name.
+func loadDNSSECKey(path string) (*dnssecKey, error) {
+	base := strings.TrimSuffix(strings.TrimSuffix(path, ".key"), ".private")
+
+	f, err := os.Open(base + ".key")
+	if err != nil {
+		return nil, fmt.Errorf("DNSSEC key %s: %v", path, err)
+	}
+	defer f.Close()
+	rr, err := dns.ReadRR(f, base+".key")
+	if err != nil {
+		return nil, fmt.Errorf("DNSSEC key %s: %v", path, err)
+	}
+	dnskey, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("DNSSEC key %s: %s.key holds no DNSKEY record", path, base)
+	}
+	if dnskey.Flags&dns.ZONE == 0 {
+		return nil, fmt.Errorf("DNSSEC key %s: not a zone key (flags %d)", path, dnskey.Flags)
+	}
+
+	p, err := os.Open(base + ".private")
+	if err != nil {
+		return nil, fmt.Errorf("DNSSEC key %s: %v", path, err)
+	}
+	defer p.Close()
+	private, err := dnskey.ReadPrivateKey(p, base+".private")
+	if err != nil {
+		return nil, fmt.Errorf("DNSSEC key %s: %v", path, err)
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("DNSSEC key %s: unsupported private key", path)
+	}
+	return &dnssecKey{dnskey: dnskey, signer: signer, tag: dnskey.KeyTag()}, nil
+}
+
+// signedZoneFor returns the signed zone name lies in, or nil. Like negative
+// answers, a name belongs to the zone of the closest SOA above it.
+func signedZoneFor(store RecordBackend, name string) *signedZone {
+	if len(config.DNSSECZones) == 0 {
+		return nil
+	}
+	soa := store.findSOA(name)
+	if soa == nil {
+		return nil
+	}
+	return config.DNSSECZones[dns.CanonicalName(soa.Hdr.Name)]
+}
+
+// dnssecKeyRecords answers DNSKEY queries at the apex of a signed zone from
+// its keys, and NSEC3PARAM queries when negative answers use NSEC3. It
+// returns nil for every other question.
+func dnssecKeyRecords(store RecordBackend, q dns.Question) []dns.RR {
+	zone := signedZoneFor(store, q.Name)
+	if zone == nil || dns.CanonicalName(q.Name) != zone.apex {
+		return nil
+	}
+
+	var result []dns.RR
+	switch {
+	case q.Qtype == dns.TypeDNSKEY:
+		for _, key := range zone.keys {
+			dnskey := dns.Copy(key.dnskey).(*dns.DNSKEY)
+			dnskey.Hdr.Name = dns.Fqdn(q.Name)
+			result = append(result, dnskey)
+		}
+	case q.Qtype == dns.TypeNSEC3PARAM && config.NSEC3:
+		// Served with the TTL of the DNSKEY RRset, which it is published with
+		ttl := zone.keys[0].dnskey.Hdr.Ttl
+		result = append(result, &dns.NSEC3PARAM{
+			Hdr:  dns.RR_Header{Name: dns.Fqdn(q.Name), Rrtype: dns.TypeNSEC3PARAM, Class: dns.ClassINET, Ttl: ttl},
+			Hash: dns.SHA1,
+		})
+	}
+	return result
+}
+
+// signResponse adds the signatures of a signed zone's RRsets to a response
+// for a DNSSEC-aware client. Negative answers get a proof of nonexistence
+// and referrals the child's DS RRset, or a proof that there is none.
+// Delegation NS records and glue belong to the child zone and are not
+// signed.
+func signResponse(msg *dns.Msg, store RecordBackend) {
+	if len(config.DNSSECZones) == 0 || len(msg.Question) == 0 {
+		return
+	}
+	q := msg.Question[0]
+
+	switch {
+	case hasType(msg.Ns, dns.TypeSOA) && (msg.Rcode == dns.RcodeSuccess || msg.Rcode == dns.RcodeNameError):
+		addDenial(msg, store, q)
+	case !msg.Authoritative && hasType(msg.Ns, dns.TypeNS):
+		addDelegationDS(msg, store)
+	}
+
+	now := signatures.now()
+	msg.Answer = signSection(msg.Answer, store, now)
+	msg.Ns = signSection(msg.Ns, store, now)
+	msg.Extra = signSection(msg.Extra, store, now)
+}
+
+// hasType reports whether rrs contain a record of qtype
+func hasType(rrs []dns.RR, qtype uint16) bool {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// signSection returns the records of a response section with the signatures
+// of each RRset from a signed zone following the RRset
+func signSection(section []dns.RR, store RecordBackend, now time.Time) []dns.RR {
+	type rrsetKey struct {
+		name  string
+		qtype uint16
+	}
+	var order []rrsetKey
+	rrsets := make(map[rrsetKey][]dns.RR)
+	for _, rr := range section {
+		key := rrsetKey{dns.CanonicalName(rr.Header().Name), rr.Header().Rrtype}
+		if rrsets[key] == nil {
+			order = append(order, key)
+		}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+
+	result := make([]dns.RR, 0, len(section))
+	for _, key := range order {
+		rrset := rrsets[key]
+		result = append(result, rrset...)
+
+		switch key.qtype {
+		case dns.TypeRRSIG, dns.TypeOPT, dns.TypeTSIG:
+			continue
+		case dns.TypeDS, dns.TypeNSEC, dns.TypeNSEC3:
+			// Signed by the parent at a zone cut
+		default:
+			if findDelegation(store, key.name, key.qtype) != nil {
+				continue
+			}
+		}
+		zone := signedZoneFor(store, key.name)
+		if zone == nil {
+			continue
+		}
+		result = append(result, zone.sign(rrset, now)...)
+	}
+	return result
+}
+
+// sign returns the signatures of rrset by the zone's keys. The records of an
+// RRset must share a TTL (RFC 2181 section 5.2), so differing TTLs are
+// lowered to the smallest.
+func (zone *signedZone) sign(rrset []dns.RR, now time.Time) []dns.RR {
+	ttl := rrset[0].Header().Ttl
+	for _, rr := range rrset {
+		ttl = min(ttl, rr.Header().Ttl)
+	}
+	for _, rr := range rrset {
+		rr.Header().Ttl = ttl
+	}
+
+	data := rrsetData(rrset)
+	var result []dns.RR
+	for _, key := range zone.signingKeys(rrset[0].Header().Rrtype) {
+		cacheKey := signatureCacheKey{zone: zone.apex, tag: key.tag, rrset: data}
+		sig := signatures.get(cacheKey, now)
+		// A signature covers the RRset with at most its original TTL, which
+		// can be lower than the current one after an ALIAS or ANAME target
+		// was resolved again
+		if sig == nil || sig.OrigTtl < ttl {
+			sig = &dns.RRSIG{
+				Algorithm:  key.dnskey.Algorithm,
+				KeyTag:     key.tag,
+				SignerName: zone.apex,
+				Inception:  uint32(now.Add(-signatureBackdate).Unix()),
+				Expiration: uint32(now.Add(signatureValidity).Unix()),
+			}
+			if err := sig.Sign(key.signer, rrset); err != nil {
+				log.Printf("Failed to sign %s %s with key %d: %v", rrset[0].Header().Name, dns.TypeToString[rrset[0].Header().Rrtype], key.tag, err)
+				continue
+			}
+			signatures.put(cacheKey, sig, now)
+		}
+		// The owner keeps the case of the question name, and the TTL is the
+		// RRset's, which counts down for resolved ALIAS and ANAME records
+		sig.Hdr.Name = rrset[0].Header().Name
+		sig.Hdr.Ttl = ttl
+		result = append(result, sig)
+	}
+	return result
+}
+
+// rrsetData returns the signed data of rrset as text that does not depend on
+// the order of the records, the case of the owner name or the TTL
+func rrsetData(rrset []dns.RR) string {
+	lines := make([]string, len(rrset))
+	for i, rr := range rrset {
+		hdr := rr.Header()
+		rdata := strings.TrimPrefix(rr.String(), hdr.String())
+		lines[i] = fmt.Sprintf("%s %d %d %s", dns.CanonicalName(hdr.Name), hdr.Class, hdr.Rrtype, rdata)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// signatureCacheKey identifies the signature of an RRset by one zone key
+type signatureCacheKey struct {
+	zone  string
+	tag   uint16
+	rrset string
+}
+
+// signatureCacheEntry is a cached signature
+type signatureCacheEntry struct {
+	key     signatureCacheKey
+	sig     *dns.RRSIG
+	refresh time.Time // When the signature is replaced by a new one
+}
+
+// signatureCache keeps signatures of RRsets until half their validity has
+// passed, so repeated answers are not signed again. When it is full, the
+// least recently used signature is dropped.
+type signatureCache struct {
+	mu      sync.Mutex
+	entries map[signatureCacheKey]*list.Element // Elements of recent
+	recent  *list.List                          // *signatureCacheEntry, most recently used first
+	now     func() time.Time
+}
+
+// signatures caches the signatures of online signed RRsets
+var signatures = newSignatureCache()
+
+// newSignatureCache creates an empty signature cache
+func newSignatureCache() *signatureCache {
+	return &signatureCache{
+		entries: make(map[signatureCacheKey]*list.Element),
+		recent:  list.New(),
+		now:     time.Now,
+	}
+}
+
+// get returns a copy of the cached signature for key, or nil
+func (c *signatureCache) get(key signatureCacheKey, now time.Time) *dns.RRSIG {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element := c.entries[key]
+	if element == nil {
+		return nil
+	}
+	entry := element.Value.(*signatureCacheEntry)
+	if !now.Before(entry.refresh) {
+		c.remove(element)
+		return nil
+	}
+	c.recent.MoveToFront(element)
+	return dns.Copy(entry.sig).(*dns.RRSIG)
+}
+
+// put caches sig under key
+func (c *signatureCache) put(key signatureCacheKey, sig *dns.RRSIG, now time.Time) {
+	entry := &signatureCacheEntry{
+		key:     key,
+		sig:     dns.Copy(sig).(*dns.RRSIG),
+		refresh: now.Add(signatureValidity / 2),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element := c.entries[key]; element != nil {
+		c.remove(element)
+	}
+	c.entries[key] = c.recent.PushFront(entry)
+	if c.recent.Len() > maxSignatureCacheEntries {
+		c.remove(c.recent.Back())
+	}
+}
+
+// remove drops a cached signature. Callers must hold c.mu.
+func (c *signatureCache) remove(element *list.Element) {
+	c.recent.Remove(element)
+	delete(c.entries, element.Value.(*signatureCacheEntry).key)
+}
+
+// addDenial adds the proof that the question name, or the name its CNAME
+// chain ends at, has no records of the question type or doesn't exist.
+//
+// With NSEC, every name is proven to have no other names next to it ("black
+// lies", RFC 9824): the NSEC record at the name covers only the name
+// "\000.<name>", and a nonexistent name gets a NOERROR answer whose NSEC
+// type bitmap holds RRSIG, NSEC and the NXNAME pseudo-type. With NSEC3, the
+// records are made up around the hashes of the names to be denied ("white
+// lies"), so NXDOMAIN responses remain; the hashes use no salt and no extra
+// iterations (RFC 9276).
+func addDenial(msg *dns.Msg, store RecordBackend, q dns.Question) {
+	name := dns.Fqdn(q.Name)
+	for _, rr := range msg.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok && dns.CanonicalName(cname.Hdr.Name) == dns.CanonicalName(name) {
+			name = cname.Target
+		}
+	}
+	zone := signedZoneFor(store, name)
+	if zone == nil {
+		return
+	}
+	var ttl uint32
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl = soa.Hdr.Ttl
+		}
+	}
+
+	if config.NSEC3 {
+		if msg.Rcode == dns.RcodeNameError {
+			encloser := closestEncloser(store, zone, name)
+			nextCloser := strings.Join(dns.SplitDomainName(name)[dns.CountLabel(name)-dns.CountLabel(encloser)-1:], ".") + "."
+			msg.Ns = append(msg.Ns,
+				zone.nsec3(encloser, 0, nameTypes(store, zone, encloser, true), ttl),
+				zone.nsec3(nextCloser, -1, nil, ttl),
+				zone.nsec3("*."+encloser, -1, nil, ttl))
+			return
+		}
+		msg.Ns = append(msg.Ns, zone.nsec3(name, 0, withoutType(nameTypes(store, zone, name, true), q.Qtype), ttl))
+		return
+	}
+
+	types := []uint16{dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNXNAME}
+	if msg.Rcode != dns.RcodeNameError {
+		types = withoutType(nameTypes(store, zone, name, false), q.Qtype)
+	}
+	msg.Rcode = dns.RcodeSuccess
+	msg.Ns = append(msg.Ns, blackLieNSEC(name, types, ttl))
+}
+
+// addDelegationDS adds the DS RRset of the zone cut a referral points to, or
+// the proof that the cut has no DS records and the child zone is unsigned
+func addDelegationDS(msg *dns.Msg, store RecordBackend) {
+	var cut string
+	for _, rr := range msg.Ns {
+		if rr.Header().Rrtype == dns.TypeNS {
+			cut = rr.Header().Name
+			break
+		}
+	}
+	zone := signedZoneFor(store, cut)
+	if zone == nil {
+		return
+	}
+
+	if ds := store.lookupRecord(cut, dns.TypeDS); len(ds) > 0 {
+		msg.Ns = append(msg.Ns, ds...)
+		return
+	}
+	var ttl uint32
+	if soa := store.findSOA(cut); soa != nil {
+		ttl = min(soa.Hdr.Ttl, soa.Minttl)
+	}
+	types := withoutType(store.recordTypes(cut), dns.TypeDS)
+	if config.NSEC3 {
+		msg.Ns = append(msg.Ns, zone.nsec3(cut, 0, types, ttl))
+		return
+	}
+	msg.Ns = append(msg.Ns, blackLieNSEC(cut, append(types, dns.TypeRRSIG, dns.TypeNSEC), ttl))
+}
+
+// blackLieNSEC returns an NSEC record at name whose next name is the
+// immediate successor "\000.<name>", so it proves nothing about other names
+func blackLieNSEC(name string, types []uint16, ttl uint32) *dns.NSEC {
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl},
+		NextDomain: `\000.` + name,
+		TypeBitMap: sortedTypes(types),
+	}
+}
+
+// nsec3 returns an NSEC3 record that matches name (offset 0) or covers it
+// (offset -1): the record then starts just below the hash of name and ends
+// just above it
+func (zone *signedZone) nsec3(name string, offset int, types []uint16, ttl uint32) *dns.NSEC3 {
+	hash, _ := base32.HexEncoding.DecodeString(dns.HashName(name, dns.SHA1, 0, ""))
+	owner := addToHash(hash, offset)
+	next := addToHash(hash, 1)
+	return &dns.NSEC3{
+		Hdr:        dns.RR_Header{Name: strings.ToLower(base32.HexEncoding.EncodeToString(owner)) + "." + zone.apex, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: ttl},
+		Hash:       dns.SHA1,
+		HashLength: uint8(len(next)),
+		NextDomain: base32.HexEncoding.EncodeToString(next),
+		TypeBitMap: sortedTypes(types),
+	}
+}
+
+// addToHash returns hash plus delta, as a big-endian number that wraps around
+func addToHash(hash []byte, delta int) []byte {
+	result := append([]byte(nil), hash...)
+	if delta == 0 {
+		return result
+	}
+	for i := len(result) - 1; i >= 0; i-- {
+		if delta > 0 {
+			result[i]++
+			if result[i] != 0 {
+				break
+			}
+		} else {
+			result[i]--
+			if result[i] != 0xff {
+				break
+			}
+		}
+	}
+	return result
+}
+
+// closestEncloser returns the closest ancestor of name that exists, which is
+// at most the zone apex
+func closestEncloser(store RecordBackend, zone *signedZone, name string) string {
+	labels := dns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		encloser := strings.Join(labels[i:], ".") + "."
+		if dns.CanonicalName(encloser) == zone.apex || store.lookupName(encloser) != nameMissing || isReflectionName(encloser) {
+			return encloser
+		}
+	}
+	return zone.apex
+}
+
+// nameTypes returns the types an existing name has records of in a signed
+// zone: those of the record backend, of reflection answers and the DNSKEY
+// RRset at the apex, with RRSIG and NSEC added for the signatures and the
+// NSEC record itself. NSEC3 records do not list NSEC3, and empty
+// non-terminals have no types at all.
+func nameTypes(store RecordBackend, zone *signedZone, name string, nsec3 bool) []uint16 {
+	types := store.recordTypes(name)
+	if inReflectionDomain(name) {
+		if multiRecord, ok := parseMultiRecord(name); ok {
+			for recordType := range multiRecord {
+				if qtype, ok := dns.StringToType[strings.ToUpper(recordType)]; ok {
+					types = append(types, qtype)
+				}
+			}
+		}
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			if reflectRecord(name, qtype) != nil {
+				types = append(types, qtype)
+			}
+		}
+	}
+	if dns.CanonicalName(name) == zone.apex {
+		types = append(types, dns.TypeDNSKEY)
+		if config.NSEC3 {
+			types = append(types, dns.TypeNSEC3PARAM)
+		}
+	}
+
+	switch {
+	case nsec3 && len(types) > 0:
+		types = append(types, dns.TypeRRSIG)
+	case !nsec3:
+		types = append(types, dns.TypeRRSIG, dns.TypeNSEC)
+	}
+	return types
+}
+
+// withoutType returns types without qtype, which the denial is for even if
+// the records of qtype could not be served (such as an ALIAS whose target
+// has none)
+func withoutType(types []uint16, qtype uint16) []uint16 {
+	var result []uint16
+	for _, t := range types {
+		if t != qtype {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// sortedTypes returns types in ascending order without duplicates, as type
+// bitmaps need them
+func sortedTypes(types []uint16) []uint16 {
+	result := append([]uint16(nil), types...)
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	unique := result[:0]
+	for i, t := range result {
+		if i == 0 || t != result[i-1] {
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
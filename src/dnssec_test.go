@@ -0,0 +1,394 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// writeTestKey generates an ECDSA P-256 key pair for zone and writes it in the
+// BIND format, returning the base name of the .key and .private files
+func writeTestKey(t *testing.T, dir, zone string, flags uint16) string {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	private, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	base := filepath.Join(dir, fmt.Sprintf("K%s+%03d+%05d", zone, key.Algorithm, key.KeyTag()))
+	if err := os.WriteFile(base+".key", []byte(key.String()+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	if err := os.WriteFile(base+".private", []byte(key.PrivateKeyString(private)), 0600); err != nil {
+		t.Fatalf("Failed to write private key: %v", err)
+	}
+	return base
+}
+
+// signedQuery sends a query with the DO bit set to the handler
+func signedQuery(name string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	req.SetEdns0(4096, true)
+	w := newMockResponseWriter()
+	handleDNSRequest(w, req)
+	return w.msg
+}
+
+// verifySection checks that every RRset of section other than those listed in
+// unsigned has a valid signature by one of keys, and that the unsigned ones
+// have none. It returns the signatures by the type they cover.
+func verifySection(t *testing.T, section []dns.RR, keys []*dns.DNSKEY, unsigned ...uint16) map[uint16]*dns.RRSIG {
+	t.Helper()
+	type rrsetKey struct {
+		name  string
+		qtype uint16
+	}
+	rrsets := make(map[rrsetKey][]dns.RR)
+	sigs := make(map[rrsetKey]*dns.RRSIG)
+	byType := make(map[uint16]*dns.RRSIG)
+	for _, rr := range section {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs[rrsetKey{dns.CanonicalName(sig.Hdr.Name), sig.TypeCovered}] = sig
+			byType[sig.TypeCovered] = sig
+			continue
+		}
+		if rr.Header().Rrtype != dns.TypeOPT {
+			key := rrsetKey{dns.CanonicalName(rr.Header().Name), rr.Header().Rrtype}
+			rrsets[key] = append(rrsets[key], rr)
+		}
+	}
+
+	for key, rrset := range rrsets {
+		sig := sigs[key]
+		if contains(unsigned, key.qtype) {
+			if sig != nil {
+				t.Errorf("Expected no signature for %s, got %v", dns.TypeToString[key.qtype], sig)
+			}
+			continue
+		}
+		if sig == nil {
+			t.Errorf("No signature for %v", rrset)
+			continue
+		}
+		var dnskey *dns.DNSKEY
+		for _, k := range keys {
+			if k.KeyTag() == sig.KeyTag {
+				dnskey = k
+			}
+		}
+		if dnskey == nil {
+			t.Errorf("Signature %v is by an unknown key", sig)
+			continue
+		}
+		if err := sig.Verify(dnskey, rrset); err != nil {
+			t.Errorf("Signature %v does not verify %v: %v", sig, rrset, err)
+		}
+		if !sig.ValidityPeriod(time.Now()) {
+			t.Errorf("Signature %v is not valid now", sig)
+		}
+	}
+	return byType
+}
+
+func contains(types []uint16, qtype uint16) bool {
+	for _, t := range types {
+		if t == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// TestDNSSECSigning tests online signing of CSV and reflection answers,
+// the DNSKEY RRset at the apex, NSEC black lies and signed referrals
+func TestDNSSECSigning(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	originalSignatures := signatures
+	defer func() { signatures = originalSignatures }()
+	signatures = newSignatureCache()
+
+	dir := t.TempDir()
+	var keys dnssecKeysFlag
+	kskBase := writeTestKey(t, dir, "example.com.", dns.ZONE|dns.SEP)
+	if err := keys.Set(kskBase); err != nil {
+		t.Fatalf("Failed to load KSK: %v", err)
+	}
+	if err := keys.Set(writeTestKey(t, dir, "example.com.", dns.ZONE) + ".private"); err != nil {
+		t.Fatalf("Failed to load ZSK: %v", err)
+	}
+	zone := keys["example.com."]
+	if zone == nil || len(zone.keys) != 2 {
+		t.Fatalf("Expected two keys for example.com., got %v", keys)
+	}
+	var ksk, zsk *dns.DNSKEY
+	for _, key := range zone.keys {
+		if key.ksk() {
+			ksk = key.dnskey
+		} else {
+			zsk = key.dnskey
+		}
+	}
+	dnskeys := []*dns.DNSKEY{ksk, zsk}
+	config.DNSSECZones = keys
+
+	tmpFile, err := createTempCSV("name,type,value,ttl\n" +
+		"example.com,SOA,ns1.example.com. admin.example.com. 1 3600 600 86400 300,3600\n" +
+		"example.com,NS,ns1.example.com,3600\n" +
+		"ns1.example.com,A,192.0.2.53,3600\n" +
+		"www.example.com,A,192.0.2.1,3600\n" +
+		"www.example.com,A,192.0.2.2,300\n" +
+		"alias.example.com,CNAME,www.example.com,3600\n" +
+		"_sip._tcp.example.com,TXT,sip,3600\n" +
+		"child.example.com,NS,ns1.child.example.com,3600\n" +
+		"ns1.child.example.com,A,192.0.2.54,3600\n" +
+		"child.example.com,DS,2371 13 2 " + testSHA256 + ",3600\n" +
+		"insecure.example.com,NS,ns1.child.example.com,3600\n")
+	if err != nil {
+		t.Fatalf("Failed to create temp CSV: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	store, err := buildRecordStore(func() (*RecordStore, error) { return loadRecordsFromCSV(tmpFile.Name()) })
+	if err != nil {
+		t.Fatalf("Failed to load CSV: %v", err)
+	}
+	recordStore = store
+
+	t.Run("CSV answer", func(t *testing.T) {
+		resp := signedQuery("WWW.example.com.", dns.TypeA)
+		if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 3 {
+			t.Fatalf("Expected two A records and a signature, got %v", resp)
+		}
+		sigs := verifySection(t, resp.Answer, dnskeys)
+		if sig := sigs[dns.TypeA]; sig == nil || sig.KeyTag != zsk.KeyTag() || sig.Hdr.Name != resp.Answer[0].Header().Name || sig.OrigTtl != 300 {
+			t.Errorf("Expected a ZSK signature with the lowest TTL, got %v", sig)
+		}
+		verifySection(t, resp.Ns, dnskeys)
+		verifySection(t, resp.Extra, dnskeys)
+	})
+
+	t.Run("CNAME chain", func(t *testing.T) {
+		resp := signedQuery("alias.example.com.", dns.TypeA)
+		sigs := verifySection(t, resp.Answer, dnskeys)
+		if sigs[dns.TypeCNAME] == nil || sigs[dns.TypeA] == nil {
+			t.Errorf("Expected signed CNAME and A RRsets, got %v", resp.Answer)
+		}
+	})
+
+	t.Run("Reflection answers", func(t *testing.T) {
+		for _, tt := range []struct {
+			qname string
+			qtype uint16
+		}{
+			{"192.0.2.99.example.com.", dns.TypeA},
+			{"2001-db8-z-1.example.com.", dns.TypeAAAA},
+		} {
+			resp := signedQuery(tt.qname, tt.qtype)
+			if len(resp.Answer) != 2 || resp.Answer[0].Header().Rrtype != tt.qtype {
+				t.Errorf("%s: expected a reflection answer and its signature, got %v", tt.qname, resp)
+				continue
+			}
+			verifySection(t, resp.Answer, dnskeys)
+		}
+	})
+
+	t.Run("DNSKEY at the apex", func(t *testing.T) {
+		resp := signedQuery("example.com.", dns.TypeDNSKEY)
+		sigs := verifySection(t, resp.Answer, dnskeys)
+		if len(resp.Answer) != 3 || sigs[dns.TypeDNSKEY] == nil || sigs[dns.TypeDNSKEY].KeyTag != ksk.KeyTag() {
+			t.Errorf("Expected both keys signed by the KSK, got %v", resp.Answer)
+		}
+
+		// Clients without DO still get the keys, but no signatures
+		if resp := query("example.com.", dns.TypeDNSKEY); len(resp.Answer) != 2 {
+			t.Errorf("Expected the two keys without signatures, got %v", resp.Answer)
+		}
+	})
+
+	t.Run("Unsigned without DO", func(t *testing.T) {
+		for _, resp := range []*dns.Msg{query("www.example.com.", dns.TypeA), query("missing.example.com.", dns.TypeA)} {
+			for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+				if hasType(section, dns.TypeRRSIG) || hasType(section, dns.TypeNSEC) {
+					t.Errorf("Expected no DNSSEC records, got %v", resp)
+				}
+			}
+		}
+		if resp := query("missing.example.com.", dns.TypeA); resp.Rcode != dns.RcodeNameError {
+			t.Errorf("Expected NXDOMAIN without DO, got %s", dns.RcodeToString[resp.Rcode])
+		}
+	})
+
+	t.Run("NSEC black lies", func(t *testing.T) {
+		tests := []struct {
+			qname string
+			qtype uint16
+			types []uint16 // The NSEC type bitmap
+		}{
+			{"missing.example.com.", dns.TypeA, []uint16{dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNXNAME}},
+			{"www.example.com.", dns.TypeTXT, []uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC}},
+			{"_tcp.example.com.", dns.TypeA, []uint16{dns.TypeRRSIG, dns.TypeNSEC}},
+			{"example.com.", dns.TypeTXT, []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY}},
+			{"2001-db8-z-1.example.com.", dns.TypeTXT, []uint16{dns.TypeAAAA, dns.TypeRRSIG, dns.TypeNSEC}},
+		}
+		for _, tt := range tests {
+			resp := signedQuery(tt.qname, tt.qtype)
+			if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 {
+				t.Errorf("%s: expected an empty NOERROR answer, got %v", tt.qname, resp)
+				continue
+			}
+			verifySection(t, resp.Ns, dnskeys)
+			var nsec *dns.NSEC
+			for _, rr := range resp.Ns {
+				if rr, ok := rr.(*dns.NSEC); ok {
+					nsec = rr
+				}
+			}
+			if nsec == nil || nsec.Hdr.Name != tt.qname || nsec.NextDomain != `\000.`+tt.qname || nsec.Hdr.Ttl != 300 {
+				t.Errorf("%s: unexpected NSEC record %v", tt.qname, nsec)
+				continue
+			}
+			if fmt.Sprint(nsec.TypeBitMap) != fmt.Sprint(tt.types) {
+				t.Errorf("%s: expected types %v, got %v", tt.qname, tt.types, nsec.TypeBitMap)
+			}
+		}
+	})
+
+	t.Run("Referrals", func(t *testing.T) {
+		resp := signedQuery("www.child.example.com.", dns.TypeA)
+		if resp.Authoritative || !hasType(resp.Ns, dns.TypeDS) {
+			t.Fatalf("Expected a referral with the DS RRset, got %v", resp)
+		}
+		verifySection(t, resp.Ns, dnskeys, dns.TypeNS)
+		verifySection(t, resp.Extra, dnskeys, dns.TypeA)
+
+		resp = signedQuery("insecure.example.com.", dns.TypeA)
+		sigs := verifySection(t, resp.Ns, dnskeys, dns.TypeNS)
+		if sigs[dns.TypeNSEC] == nil || hasType(resp.Ns, dns.TypeDS) {
+			t.Fatalf("Expected a signed proof that there is no DS, got %v", resp)
+		}
+		for _, rr := range resp.Ns {
+			if nsec, ok := rr.(*dns.NSEC); ok && fmt.Sprint(nsec.TypeBitMap) != fmt.Sprint([]uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC}) {
+				t.Errorf("Unexpected NSEC at the cut: %v", nsec)
+			}
+		}
+
+		// The parent answers DS queries at the cut itself
+		resp = signedQuery("child.example.com.", dns.TypeDS)
+		if sigs := verifySection(t, resp.Answer, dnskeys); !resp.Authoritative || sigs[dns.TypeDS] == nil {
+			t.Errorf("Expected a signed DS answer, got %v", resp)
+		}
+	})
+
+	t.Run("Signature cache", func(t *testing.T) {
+		first := signedQuery("www.example.com.", dns.TypeA)
+		second := signedQuery("www.example.com.", dns.TypeA)
+		if first.Answer[2].String() != second.Answer[2].String() {
+			t.Errorf("Expected the cached signature, got %v and %v", first.Answer[2], second.Answer[2])
+		}
+
+		// The TTL of resolved ALIAS and ANAME records counts down; their
+		// signature is reused as long as its original TTL covers the TTL
+		rrset := func(ttl uint32) []dns.RR {
+			rr, _ := dns.NewRR(fmt.Sprintf("aname.example.com. %d IN A 192.0.2.9", ttl))
+			return []dns.RR{rr}
+		}
+		now := time.Now()
+		original := zone.sign(rrset(300), now)[0].(*dns.RRSIG)
+		lower := rrset(250)
+		cached := zone.sign(lower, now)[0].(*dns.RRSIG)
+		if cached.Signature != original.Signature || cached.OrigTtl != 300 || cached.Hdr.Ttl != 250 {
+			t.Errorf("Expected the cached signature with TTL 250, got %v for %v", cached, original)
+		}
+		if err := cached.Verify(zsk, lower); err != nil {
+			t.Errorf("Cached signature %v does not verify %v: %v", cached, lower, err)
+		}
+		if higher := zone.sign(rrset(600), now)[0].(*dns.RRSIG); higher.Signature == original.Signature || higher.OrigTtl != 600 {
+			t.Errorf("Expected a new signature for TTL 600, got %v", higher)
+		}
+
+		// Signatures are renewed once half their validity has passed
+		signatures.now = func() time.Time { return time.Now().Add(signatureValidity/2 + time.Minute) }
+		defer func() { signatures.now = time.Now }()
+		renewed := signedQuery("www.example.com.", dns.TypeA)
+		if renewed.Answer[2].(*dns.RRSIG).Inception <= first.Answer[2].(*dns.RRSIG).Inception {
+			t.Errorf("Expected a new signature, got %v", renewed.Answer[2])
+		}
+	})
+
+	t.Run("NSEC3 white lies", func(t *testing.T) {
+		config.NSEC3 = true
+		defer func() { config.NSEC3 = false }()
+
+		resp := signedQuery("example.com.", dns.TypeNSEC3PARAM)
+		if len(resp.Answer) != 2 || !strings.HasPrefix(resp.Answer[0].String(), "example.com.\t3600\tIN\tNSEC3PARAM\t1 0 0 -") {
+			t.Errorf("Expected the NSEC3 parameters, got %v", resp.Answer)
+		}
+
+		resp = signedQuery("host.missing.example.com.", dns.TypeA)
+		if resp.Rcode != dns.RcodeNameError {
+			t.Fatalf("Expected NXDOMAIN, got %v", resp)
+		}
+		verifySection(t, resp.Ns, dnskeys)
+		var matched, nextCloser, wildcard bool
+		for _, rr := range resp.Ns {
+			nsec3, ok := rr.(*dns.NSEC3)
+			if !ok {
+				continue
+			}
+			if !strings.HasSuffix(nsec3.Hdr.Name, ".example.com.") || nsec3.Iterations != 0 || nsec3.Salt != "" {
+				t.Errorf("Unexpected NSEC3 record %v", nsec3)
+			}
+			matched = matched || nsec3.Match("example.com.") && contains(nsec3.TypeBitMap, dns.TypeSOA)
+			nextCloser = nextCloser || nsec3.Cover("missing.example.com.")
+			wildcard = wildcard || nsec3.Cover("*.example.com.")
+		}
+		if !matched || !nextCloser || !wildcard {
+			t.Errorf("Expected the closest encloser proof and wildcard denial, got %v", resp.Ns)
+		}
+
+		resp = signedQuery("www.example.com.", dns.TypeTXT)
+		verifySection(t, resp.Ns, dnskeys)
+		nodata := false
+		for _, rr := range resp.Ns {
+			if nsec3, ok := rr.(*dns.NSEC3); ok && nsec3.Match("www.example.com.") && fmt.Sprint(nsec3.TypeBitMap) == fmt.Sprint([]uint16{dns.TypeA, dns.TypeRRSIG}) {
+				nodata = true
+			}
+		}
+		if resp.Rcode != dns.RcodeSuccess || !nodata {
+			t.Errorf("Expected a matching NSEC3 without TXT, got %v", resp)
+		}
+	})
+}
+
+// TestLoadDNSSECKeyErrors tests reporting unusable key files
+func TestLoadDNSSECKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+	base := writeTestKey(t, dir, "example.com.", dns.ZONE)
+
+	if _, err := loadDNSSECKey(filepath.Join(dir, "Kmissing")); err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("Expected a missing file error, got %v", err)
+	}
+
+	os.WriteFile(base+".key", []byte("example.com. IN DNSKEY 0 3 13 "+testPublicKey+"\n"), 0644)
+	if _, err := loadDNSSECKey(base); err == nil || !strings.Contains(err.Error(), "not a zone key") {
+		t.Errorf("Expected a non-zone key error, got %v", err)
+	}
+
+	os.WriteFile(base+".key", []byte("example.com. IN A 192.0.2.1\n"), 0644)
+	if _, err := loadDNSSECKey(base); err == nil || !strings.Contains(err.Error(), "holds no DNSKEY record") {
+		t.Errorf("Expected a wrong record type error, got %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// exportFormats are the formats the record store can be exported in, with
+// their MIME types for the admin endpoint
+var exportFormats = map[string]string{
+	"zone": "text/dns; charset=utf-8", // RFC 4027
+	"csv":  "text/csv; charset=utf-8",
+	"json": "application/json",
+}
+
+// exportedRecord is a record as it is served: lowercased name, wildcards with
+// their "*." owner and the default TTL filled in
+type exportedRecord struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Value    string   `json:"value"`
+	TTL      uint32   `json:"ttl"`
+	Priority uint16   `json:"priority,omitempty"`
+	Weight   uint16   `json:"weight,omitempty"`
+	Port     uint16   `json:"port,omitempty"`
+	Comment  string   `json:"comment,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Source   string   `json:"source,omitempty"` // file:line the record was loaded from
+	record   DNSRecord
+}
+
+// exportRecords writes every record of store to w in format (zone, csv or json)
+func exportRecords(store RecordBackend, format string, w io.Writer) error {
+	records := exportedRecords(store)
+	switch format {
+	case "zone":
+		return exportZone(records, w)
+	case "csv":
+		return exportCSV(records, w)
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if records == nil {
+			records = []exportedRecord{}
+		}
+		return encoder.Encode(records)
+	}
+	return fmt.Errorf("unknown export format '%s', must be zone, csv or json", format)
+}
+
+// exportedRecords collects the records of store in canonical order: by owner
+// name in DNSSEC order (RFC 4034 section 6.1), SOA and NS first, then by type
+// and value
+func exportedRecords(store RecordBackend) []exportedRecord {
+	if store == nil {
+		return nil
+	}
+
+	var records []exportedRecord
+	store.enumerate(func(record DNSRecord) bool {
+		exported := exportedRecord{
+			Name:    record.Name,
+			Type:    record.Type,
+			Value:   record.Value,
+			TTL:     record.ttl(),
+			Comment: record.Comment,
+			Tags:    record.Tags,
+			record:  record,
+		}
+		switch record.Type {
+		case "MX":
+			exported.Priority = record.Priority
+		case "SRV":
+			exported.Priority, exported.Weight, exported.Port = record.Priority, record.Weight, record.Port
+		}
+		if record.File != "" {
+			exported.Source = record.File
+			if record.Line != 0 {
+				exported.Source += ":" + strconv.Itoa(record.Line)
+			}
+		}
+		records = append(records, exported)
+		return true
+	})
+
+	typeOrder := func(recordType string) int {
+		switch recordType {
+		case "SOA":
+			return 0
+		case "NS":
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Name != b.Name {
+			return canonicalNameLess(a.Name, b.Name)
+		}
+		if typeOrder(a.Type) != typeOrder(b.Type) {
+			return typeOrder(a.Type) < typeOrder(b.Type)
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.Value < b.Value
+	})
+	return records
+}
+
+// canonicalNameLess orders names label by label from the right, so that a
+// zone's names follow its apex
+func canonicalNameLess(a, b string) bool {
+	labelsA, labelsB := dns.SplitDomainName(a), dns.SplitDomainName(b)
+	for i := 1; i <= len(labelsA) && i <= len(labelsB); i++ {
+		la, lb := labelsA[len(labelsA)-i], labelsB[len(labelsB)-i]
+		if la != lb {
+			return la < lb
+		}
+	}
+	return len(labelsA) < len(labelsB)
+}
+
+// exportZone writes the records as an RFC 1035 master file with absolute
+// names. ALIAS and ANAME have no standard representation and are written as
+// comments.
+func exportZone(records []exportedRecord, w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "; %d records exported by 2dns\n", len(records)); err != nil {
+		return err
+	}
+	for _, exported := range records {
+		record := exported.record
+		record.TTL = exported.TTL
+		owner := dns.Fqdn(record.Name)
+
+		var line string
+		if record.Type == "ALIAS" || record.Type == "ANAME" {
+			line = fmt.Sprintf("; %s\t%d\tIN\t%s\t%s", owner, record.TTL, record.Type, dns.Fqdn(record.Value))
+		} else if rr := createRR(record, owner, dns.StringToType[record.Type]); rr != nil {
+			line = rr.String()
+		} else {
+			line = fmt.Sprintf("; %s\t%d\tIN\t%s\t%s ; can't be represented", owner, record.TTL, record.Type, record.Value)
+		}
+		if exported.Comment != "" {
+			line += " ; " + exported.Comment
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportCSV writes the records as CSV with every column the loader
+// understands, so the output loads back into the same store
+func exportCSV(records []exportedRecord, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"name", "type", "value", "ttl", "priority", "weight", "port", "comment", "tags"})
+	for _, record := range records {
+		var priority, weight, port string
+		switch record.Type {
+		case "MX":
+			priority = strconv.Itoa(int(record.Priority))
+		case "SRV":
+			priority = strconv.Itoa(int(record.Priority))
+			weight = strconv.Itoa(int(record.Weight))
+			port = strconv.Itoa(int(record.Port))
+		}
+		writer.Write([]string{
+			record.Name, record.Type, record.Value, strconv.FormatUint(uint64(record.TTL), 10),
+			priority, weight, port, record.Comment, strings.Join(record.Tags, ","),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// runExport implements the export subcommand: it loads the given record
+// sources like the server would and writes them to stdout
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatFlag := flags.String("format", "zone", "Output format: zone, csv or json")
+	modeFlag := flags.String("mode", "dev", "Run mode whose default TTL is filled in: dev or production")
+	ttlFlag := flags.Uint("ttl", 0, "Default TTL to fill in (0 means use mode default)")
+	var csvFiles, recordsDirs stringListFlag
+	flags.Var(&csvFiles, "csv", "Path to CSV file containing DNS records (repeatable)")
+	zoneFileFlag := flags.String("zone", "", "Path to RFC 1035 master zone file containing DNS records")
+	flags.Var(&recordsDirs, "records-dir", "Directory to load every *.csv and *.zone file from (repeatable)")
+	originFlag := flags.String("origin", ".", "Initial $ORIGIN for relative names in the zone file")
+	flags.Parse(args)
+
+	if _, ok := exportFormats[*formatFlag]; !ok {
+		fmt.Fprintf(os.Stderr, "Unknown export format '%s', must be zone, csv or json\n", *formatFlag)
+		return 2
+	}
+	sources := recordSources{CSVFiles: csvFiles, ZoneFile: *zoneFileFlag, Origin: *originFlag, Dirs: recordsDirs}
+	if sources.empty() {
+		fmt.Fprintln(os.Stderr, "export requires -csv, -zone or -records-dir")
+		return 2
+	}
+
+	initConfig(RunMode(*modeFlag), uint32(*ttlFlag), nil)
+	store, err := buildRecordStore(sources.load)
+	if err != nil {
+		for _, problem := range flattenErrors(err) {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		return 1
+	}
+	if err := exportRecords(store, *formatFlag, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// adminHandler serves the admin endpoints:
+//
+//	GET /export?format=zone|csv|json  the records currently being served
+func adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /export", func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "zone"
+		}
+		contentType, ok := exportFormats[format]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown export format '%s', must be zone, csv or json", format), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		if err := exportRecords(currentRecordStore(), format, w); err != nil {
+			log.Printf("Admin export failed: %v", err)
+		}
+	})
+	return mux
+}
+
+// startAdminServer serves the admin endpoints on addr in the background
+func startAdminServer(addr string) {
+	go func() {
+		log.Printf("Admin endpoint listening on http://%s", addr)
+		if err := http.ListenAndServe(addr, adminHandler()); err != nil {
+			log.Printf("Admin endpoint failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// exportTestCSV covers the normalisation the export should make visible
+const exportTestCSV = `name,type,value,ttl,priority,weight,port,comment,tags
+Example.com,SOA,ns1.example.com. admin.example.com. 2025050801 3600 1800 604800 300,3600,,,,,
+example.com,NS,ns1.example.com,3600,,,,,
+ns1.example.com,A,192.0.2.53,,,,,Name server,"infra,dns"
+WWW.example.com,A,192.0.2.1,300,,,,,
+*.example.com,A,192.0.2.2,300,,,,,
+example.com,MX,mail.example.com,300,10,,,,
+_sip._tcp.example.com,SRV,sip.example.com,300,10,20,5060,,
+example.com,TXT,"v=spf1 -all",300,,,,,
+example.com,CAA,"0 issue ""letsencrypt.org""",300,,,,,
+txt.example.com,TXT,"""first string"" ""second \""quoted\"" string""",300,,,,,`
+
+// TestExportRecords tests that each export format loads back into the same records
+func TestExportRecords(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	tmpFile, err := createTempCSV(exportTestCSV)
+	if err != nil {
+		t.Fatalf("Failed to create temp CSV: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	store, err := buildRecordStore(func() (*RecordStore, error) { return loadRecordsFromCSV(tmpFile.Name()) })
+	if err != nil {
+		t.Fatalf("Failed to load CSV: %v", err)
+	}
+	// The default TTL is filled in, so compare against the store with it applied
+	expected := newRecordStore()
+	for _, record := range store.allRecords() {
+		if record.TTL == 0 {
+			record.TTL = config.TTL
+		}
+		expected.addRecord(record)
+	}
+	want := recordSets(expected)
+
+	dir := t.TempDir()
+	export := func(format string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := exportRecords(store, format, &out); err != nil {
+			t.Fatalf("Export as %s failed: %v", format, err)
+		}
+		return out.String()
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		output := export("csv")
+		if !strings.HasPrefix(output, "name,type,value,ttl,priority,weight,port,comment,tags\nexample.com,SOA,") {
+			t.Errorf("Expected the apex SOA first, got:\n%s", output)
+		}
+		if !strings.Contains(output, "ns1.example.com,A,192.0.2.53,3600,,,,Name server,\"infra,dns\"\n") {
+			t.Errorf("Expected default TTL, comment and tags, got:\n%s", output)
+		}
+
+		path := filepath.Join(dir, "export.csv")
+		os.WriteFile(path, []byte(output), 0644)
+		reloaded, err := loadRecordsFromCSV(path)
+		if err != nil {
+			t.Fatalf("Failed to load exported CSV: %v", err)
+		}
+		if got := recordSets(reloaded); !reflect.DeepEqual(got, want) {
+			t.Errorf("Exported CSV differs:\nwant %v\ngot  %v", want, got)
+		}
+	})
+
+	t.Run("Zone file", func(t *testing.T) {
+		output := export("zone")
+		if !strings.Contains(output, "*.example.com.\t300\tIN\tA\t192.0.2.2") {
+			t.Errorf("Expected the wildcard with its owner name, got:\n%s", output)
+		}
+		if !strings.Contains(output, "example.com.\t300\tIN\tCAA\t0 issue \"letsencrypt.org\"\n") {
+			t.Errorf("Expected the CAA value quoted once, got:\n%s", output)
+		}
+
+		path := filepath.Join(dir, "export.zone")
+		os.WriteFile(path, []byte(output), 0644)
+		reloaded, err := loadRecordsFromZoneFile(path, ".")
+		if err != nil {
+			t.Fatalf("Failed to load exported zone file: %v", err)
+		}
+		if got := recordSets(reloaded); !reflect.DeepEqual(got, want) {
+			t.Errorf("Exported zone file differs:\nwant %v\ngot  %v", want, got)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var records []exportedRecord
+		if err := json.Unmarshal([]byte(export("json")), &records); err != nil {
+			t.Fatalf("Failed to decode exported JSON: %v", err)
+		}
+		if len(records) != store.count() {
+			t.Fatalf("Expected %d records, got %d", store.count(), len(records))
+		}
+		for _, record := range records {
+			if record.Name == "www.example.com" {
+				if record.TTL != 300 || record.Source != tmpFile.Name()+":5" {
+					t.Errorf("Unexpected record: %+v", record)
+				}
+			}
+			if record.Type == "SRV" && (record.Priority != 10 || record.Weight != 20 || record.Port != 5060) {
+				t.Errorf("Unexpected SRV record: %+v", record)
+			}
+		}
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		if err := exportRecords(store, "yaml", &bytes.Buffer{}); err == nil {
+			t.Errorf("Expected an error for an unknown format")
+		}
+	})
+}
+
+// TestAdminExport tests the admin export endpoint
+func TestAdminExport(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	server := httptest.NewServer(adminHandler())
+	defer server.Close()
+
+	tests := []struct {
+		query       string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{"", http.StatusOK, "text/dns", "example.com.\t3600\tIN\tSOA"},
+		{"?format=csv", http.StatusOK, "text/csv", "www.example.com,CNAME,example.com,"},
+		{"?format=json", http.StatusOK, "application/json", `"type": "CNAME"`},
+		{"?format=yaml", http.StatusBadRequest, "text/plain", "unknown export format"},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(server.URL + "/export" + tt.query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.query, tt.status, resp.StatusCode)
+		}
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), tt.contentType) {
+			t.Errorf("%s: expected content type %s, got %s", tt.query, tt.contentType, resp.Header.Get("Content-Type"))
+		}
+		if !strings.Contains(body.String(), tt.contains) {
+			t.Errorf("%s: expected body containing %q, got:\n%s", tt.query, tt.contains, body.String())
+		}
+	}
+
+	if resp, err := http.Post(server.URL+"/export", "text/plain", nil); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("Expected POST to be rejected, got %d", resp.StatusCode)
+		}
+	}
+}
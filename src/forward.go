@@ -0,0 +1,312 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// ednsBufferSize is the UDP payload size advertised in EDNS(0) (DNS flag day 2020)
+	ednsBufferSize = 1232
+	// maxForwardCacheTTL caps how long a forwarded response is cached
+	maxForwardCacheTTL = 24 * 60 * 60
+)
+
+// forwardRule sends queries for names at or below Suffix to its own upstreams
+type forwardRule struct {
+	Suffix    string
+	Upstreams []upstreamServer
+}
+
+// forwardRulesFlag collects repeated -forward-rule flags of the form
+// "suffix=upstream[,upstream...]"
+type forwardRulesFlag []forwardRule
+
+func (f *forwardRulesFlag) String() string {
+	var rules []string
+	for _, rule := range *f {
+		var servers []string
+		for _, server := range rule.Upstreams {
+			servers = append(servers, server.String())
+		}
+		rules = append(rules, rule.Suffix+"="+strings.Join(servers, ","))
+	}
+	return strings.Join(rules, " ")
+}
+
+func (f *forwardRulesFlag) Set(value string) error {
+	suffix, list, ok := strings.Cut(value, "=")
+	suffix = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(suffix), "."))
+	if !ok || suffix == "" {
+		return fmt.Errorf("forward rule %q: expected suffix=upstream[,upstream...]", value)
+	}
+	servers, err := parseUpstreams(list)
+	if err != nil {
+		return fmt.Errorf("forward rule %q: %v", value, err)
+	}
+	if len(servers) == 0 {
+		return fmt.Errorf("forward rule %q: no upstreams", value)
+	}
+	*f = append(*f, forwardRule{Suffix: suffix, Upstreams: servers})
+	return nil
+}
+
+// forwardingEnabled reports whether the server answers recursive queries for
+// names it is not authoritative for
+func forwardingEnabled() bool {
+	return config.Forward || len(config.ForwardRules) > 0
+}
+
+// recursionAvailable reports whether the response to r advertises recursion:
+// always in forwarder mode, otherwise only if a forward rule covers the
+// question name
+func recursionAvailable(r *dns.Msg) bool {
+	if config.Forward {
+		return true
+	}
+	for _, q := range r.Question {
+		if findForwardRule(q.Name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardUpstreams returns the upstreams a query for name is forwarded to, or
+// nil if it is answered here. Only recursive queries are forwarded, and only
+// for names outside our zones and reflection domains that are no reflection
+// names either. Names matching a forward rule go to the rule's upstreams, all
+// others to the -upstreams in forwarder mode.
+func forwardUpstreams(store RecordBackend, r *dns.Msg, name string) []upstreamServer {
+	if !r.RecursionDesired || !forwardingEnabled() {
+		return nil
+	}
+	if store != nil && isLocalName(store, name) {
+		return nil
+	}
+	// With -reflect-domains, reflection names only exist below them, so the
+	// name is not decoded; without, any name may be one
+	if len(config.ReflectDomains) > 0 {
+		if inReflectionDomain(name) {
+			return nil
+		}
+	} else if isReflectionName(name) {
+		return nil
+	}
+
+	if rule := findForwardRule(name); rule != nil {
+		return rule.Upstreams
+	}
+	if !config.Forward {
+		return nil
+	}
+	if len(config.Upstreams) == 0 {
+		return defaultUpstreams
+	}
+	return config.Upstreams
+}
+
+// findForwardRule returns the rule with the longest suffix matching name, or nil
+func findForwardRule(name string) *forwardRule {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var best *forwardRule
+	for i, rule := range config.ForwardRules {
+		if name != rule.Suffix && !strings.HasSuffix(name, "."+rule.Suffix) {
+			continue
+		}
+		if best == nil || len(rule.Suffix) > len(best.Suffix) {
+			best = &config.ForwardRules[i]
+		}
+	}
+	return best
+}
+
+// forwardQuery resolves q through servers, or the response cache, and copies
+// the result into msg. Forwarded answers are never authoritative. If every
+// server fails the response is SERVFAIL.
+func forwardQuery(msg *dns.Msg, r *dns.Msg, q dns.Question, servers []upstreamServer) {
+	msg.Authoritative = false
+
+	do := false
+	if opt := r.IsEdns0(); opt != nil {
+		do = opt.Do()
+	}
+	key := forwardCacheKey{strings.ToLower(q.Name), q.Qtype, q.Qclass, do, r.CheckingDisabled}
+
+	resp := forwardResponses.get(key)
+	if resp == nil {
+		req := new(dns.Msg)
+		req.SetQuestion(q.Name, q.Qtype)
+		req.Question[0].Qclass = q.Qclass
+		req.CheckingDisabled = r.CheckingDisabled
+		req.SetEdns0(ednsBufferSize, do)
+
+		var err error
+		resp, err = exchangeWithFailover(req, servers)
+		if err != nil {
+			if config.VerboseLogging {
+				log.Printf("Forwarding %s %s failed: %v", q.Name, dns.TypeToString[q.Qtype], err)
+			}
+			msg.Rcode = dns.RcodeServerFailure
+			return
+		}
+		forwardResponses.put(key, resp)
+	}
+
+	if config.VerboseLogging {
+		log.Printf("Forwarded %s %s: %s, %d answers", q.Name, dns.TypeToString[q.Qtype], dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+	msg.Rcode = resp.Rcode
+	msg.AuthenticatedData = resp.AuthenticatedData
+	msg.Answer = append(msg.Answer, resp.Answer...)
+	msg.Ns = append(msg.Ns, resp.Ns...)
+	for _, rr := range resp.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			msg.Extra = append(msg.Extra, rr)
+		}
+	}
+}
+
+// forwardCacheKey identifies a forwarded question
+type forwardCacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+	do     bool
+	cd     bool // Answers the upstream did not validate are kept apart
+}
+
+// forwardCacheEntry is a cached upstream response
+type forwardCacheEntry struct {
+	key     forwardCacheKey
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// forwardCache caches forwarded responses for the lowest TTL they contain,
+// or the negative caching TTL of NXDOMAIN and NODATA responses (RFC 2308).
+// When it is full, the least recently used response is dropped.
+type forwardCache struct {
+	mu      sync.Mutex
+	entries map[forwardCacheKey]*list.Element // Elements of recent
+	recent  *list.List                        // *forwardCacheEntry, most recently used first
+	now     func() time.Time
+}
+
+// forwardResponses caches the responses of forwarded queries
+var forwardResponses = newForwardCache()
+
+// newForwardCache creates an empty response cache
+func newForwardCache() *forwardCache {
+	return &forwardCache{
+		entries: make(map[forwardCacheKey]*list.Element),
+		recent:  list.New(),
+		now:     time.Now,
+	}
+}
+
+// get returns a copy of the cached response for key with its TTLs reduced by
+// the time it spent in the cache, or nil
+func (c *forwardCache) get(key forwardCacheKey) *dns.Msg {
+	if config.ForwardCacheSize <= 0 {
+		return nil
+	}
+	now := c.now()
+
+	c.mu.Lock()
+	var entry *forwardCacheEntry
+	if element := c.entries[key]; element != nil {
+		entry = element.Value.(*forwardCacheEntry)
+		if now.Before(entry.expires) {
+			c.recent.MoveToFront(element)
+		} else {
+			c.remove(element)
+			entry = nil
+		}
+	}
+	c.mu.Unlock()
+
+	if entry == nil {
+		return nil
+	}
+
+	age := uint32(now.Sub(entry.stored) / time.Second)
+	resp := entry.msg.Copy()
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				rr.Header().Ttl -= min(age, rr.Header().Ttl)
+			}
+		}
+	}
+	return resp
+}
+
+// put caches resp under key. Server failures and responses with a zero TTL
+// are not cached.
+func (c *forwardCache) put(key forwardCacheKey, resp *dns.Msg) {
+	if config.ForwardCacheSize <= 0 {
+		return
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError || resp.Truncated {
+		return
+	}
+	ttl := responseTTL(resp)
+	if ttl == 0 {
+		return
+	}
+	now := c.now()
+	entry := &forwardCacheEntry{
+		key:     key,
+		msg:     resp.Copy(),
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element := c.entries[key]; element != nil {
+		c.remove(element)
+	}
+	c.entries[key] = c.recent.PushFront(entry)
+	for c.recent.Len() > config.ForwardCacheSize {
+		c.remove(c.recent.Back())
+	}
+}
+
+// remove drops a cached response. Callers must hold c.mu.
+func (c *forwardCache) remove(element *list.Element) {
+	c.recent.Remove(element)
+	delete(c.entries, element.Value.(*forwardCacheEntry).key)
+}
+
+// responseTTL returns how long a response may be cached: the lowest TTL of its
+// records, where an SOA counts with the lesser of its TTL and MINIMUM
+func responseTTL(resp *dns.Msg) uint32 {
+	ttl := uint32(maxForwardCacheTTL)
+	empty := true
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			empty = false
+			ttl = min(ttl, rr.Header().Ttl)
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl = min(ttl, soa.Minttl)
+			}
+		}
+	}
+	// Negative answers without an SOA can't be cached (RFC 2308 section 5)
+	if empty {
+		return 0
+	}
+	return ttl
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TestForwarding tests forwarder mode, forward rules and the RD/RA bits
+func TestForwarding(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	defer func() { forwardResponses = newForwardCache() }()
+
+	var defaultQueries, ruleQueries atomic.Int32
+	defaultUpstream := startStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		defaultQueries.Add(1)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.RecursionAvailable = true
+		switch r.Question[0].Name {
+		case "www.example.net.":
+			rr, _ := dns.NewRR("www.example.net. 300 IN A 203.0.113.10")
+			msg.Answer = append(msg.Answer, rr)
+		case "missing.example.net.":
+			msg.Rcode = dns.RcodeNameError
+			soa, _ := dns.NewRR("example.net. 3600 IN SOA ns.example.net. admin.example.net. 1 3600 600 86400 60")
+			msg.Ns = append(msg.Ns, soa)
+		}
+		w.WriteMsg(msg)
+	})
+	ruleUpstream := startStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		ruleQueries.Add(1)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A 10.1.1.1")
+		msg.Answer = append(msg.Answer, rr)
+		w.WriteMsg(msg)
+	})
+
+	store := newRecordStore()
+	store.addRecord(DNSRecord{Name: "example.com", Type: "SOA", Value: "ns1.example.com. admin.example.com. 2025050801 3600 1800 604800 300", TTL: 3600})
+	store.addRecord(DNSRecord{Name: "www.example.com", Type: "A", Value: "192.168.1.1", TTL: 3600})
+	recordStore = store
+
+	config.Upstreams = []upstreamServer{{"udp", defaultUpstream}}
+	config.UpstreamTimeout = time.Second
+	config.Forward = true
+	config.ForwardRules = []forwardRule{{Suffix: "corp.example", Upstreams: []upstreamServer{{"udp", ruleUpstream}}}}
+	config.ForwardCacheSize = 100
+	config.ReflectDomains = []string{"reflect.example"}
+
+	query := func(name string, qtype uint16, rd bool) *dns.Msg {
+		t.Helper()
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		req.RecursionDesired = rd
+		w := newMockResponseWriter()
+		handleDNSRequest(w, req)
+		return w.msg
+	}
+
+	tests := []struct {
+		name      string
+		qname     string
+		rd        bool
+		wantRcode int
+		wantAA    bool
+		wantA     string
+	}{
+		{"Forwarded to the default upstreams", "www.example.net.", true, dns.RcodeSuccess, false, "203.0.113.10"},
+		{"NXDOMAIN is passed through", "missing.example.net.", true, dns.RcodeNameError, false, ""},
+		{"Forward rule", "host.corp.example.", true, dns.RcodeSuccess, false, "10.1.1.1"},
+		{"Authoritative data is not forwarded", "www.example.com.", true, dns.RcodeSuccess, true, "192.168.1.1"},
+		{"Names in our zones are not forwarded", "missing.example.com.", true, dns.RcodeNameError, true, ""},
+		{"Reflection domains are not forwarded", "host.reflect.example.", true, dns.RcodeSuccess, false, ""},
+		{"Without RD nothing is forwarded", "www.example.net.", false, dns.RcodeRefused, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := query(tt.qname, dns.TypeA, tt.rd)
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("Expected rcode %s, got %s", dns.RcodeToString[tt.wantRcode], dns.RcodeToString[resp.Rcode])
+			}
+			if resp.Authoritative != tt.wantAA {
+				t.Errorf("Expected AA=%v, got %v", tt.wantAA, resp.Authoritative)
+			}
+			if !resp.RecursionAvailable {
+				t.Errorf("Expected RA to be set in forwarder mode")
+			}
+			if resp.RecursionDesired != tt.rd {
+				t.Errorf("Expected RD to be copied from the query")
+			}
+			if tt.wantA == "" {
+				if len(resp.Answer) != 0 {
+					t.Errorf("Expected no answers, got %v", resp.Answer)
+				}
+				return
+			}
+			if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != tt.wantA {
+				t.Errorf("Expected A %s, got %v", tt.wantA, resp.Answer)
+			}
+		})
+	}
+
+	t.Run("Responses are cached", func(t *testing.T) {
+		forwardResponses = newForwardCache()
+		now := time.Unix(1700000000, 0)
+		forwardResponses.now = func() time.Time { return now }
+
+		before := defaultQueries.Load()
+		query("www.example.net.", dns.TypeA, true)
+		now = now.Add(100 * time.Second)
+		resp := query("www.example.net.", dns.TypeA, true)
+		if got := defaultQueries.Load() - before; got != 1 {
+			t.Errorf("Expected 1 upstream query, got %d", got)
+		}
+		if ttl := resp.Answer[0].Header().Ttl; ttl != 200 {
+			t.Errorf("Expected the cached TTL to count down to 200, got %d", ttl)
+		}
+
+		// Negative answers are cached for the SOA minimum
+		query("missing.example.net.", dns.TypeA, true)
+		now = now.Add(61 * time.Second)
+		query("missing.example.net.", dns.TypeA, true)
+		if got := defaultQueries.Load() - before; got != 3 {
+			t.Errorf("Expected the negative answer to expire after 60s, got %d upstream queries", got)
+		}
+
+		// Answers to queries with CD set are cached apart, as the upstream did not validate them
+		req := new(dns.Msg)
+		req.SetQuestion("www.example.net.", dns.TypeA)
+		req.RecursionDesired = true
+		req.CheckingDisabled = true
+		handleDNSRequest(newMockResponseWriter(), req)
+		if got := defaultQueries.Load() - before; got != 4 {
+			t.Errorf("Expected a CD query not to be answered from the cache, got %d upstream queries", got)
+		}
+	})
+
+	t.Run("Failing upstreams", func(t *testing.T) {
+		config.Upstreams = []upstreamServer{{"udp", startStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+			msg := new(dns.Msg)
+			msg.SetRcode(r, dns.RcodeRefused)
+			w.WriteMsg(msg)
+		})}}
+		if resp := query("other.example.net.", dns.TypeA, true); resp.Rcode != dns.RcodeServerFailure {
+			t.Errorf("Expected SERVFAIL, got %s", dns.RcodeToString[resp.Rcode])
+		}
+	})
+
+	t.Run("RA only for names covered by a rule without -forward", func(t *testing.T) {
+		config.Forward = false
+		if resp := query("host.corp.example.", dns.TypeA, false); !resp.RecursionAvailable {
+			t.Errorf("Expected RA for a name covered by a forward rule")
+		}
+		if resp := query("www.example.net.", dns.TypeA, false); resp.RecursionAvailable {
+			t.Errorf("Expected RA to be clear for a name no rule covers")
+		}
+	})
+
+	t.Run("RA is clear without forwarding", func(t *testing.T) {
+		config.Forward = false
+		config.ForwardRules = nil
+		if resp := query("www.example.com.", dns.TypeA, true); resp.RecursionAvailable {
+			t.Errorf("Expected RA to be clear")
+		}
+	})
+
+	if ruleQueries.Load() != 1 {
+		t.Errorf("Expected 1 query to the rule's upstream, got %d", ruleQueries.Load())
+	}
+}
+
+// TestForwardRuleFlag tests parsing -forward-rule values
+func TestForwardRuleFlag(t *testing.T) {
+	var rules forwardRulesFlag
+	for _, value := range []string{"corp.example.=10.0.0.53,tcp://10.0.0.54", "lab.corp.example=10.0.1.53"} {
+		if err := rules.Set(value); err != nil {
+			t.Fatalf("Set(%q) failed: %v", value, err)
+		}
+	}
+	for _, value := range []string{"corp.example", "=10.0.0.53", "corp.example=", "corp.example=quic://10.0.0.53"} {
+		if err := rules.Set(value); err == nil {
+			t.Errorf("Expected Set(%q) to fail", value)
+		}
+	}
+
+	if got := rules.String(); got != "corp.example=udp://10.0.0.53:53,tcp://10.0.0.54:53 lab.corp.example=udp://10.0.1.53:53" {
+		t.Errorf("Unexpected rules: %s", got)
+	}
+
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config.ForwardRules = rules
+
+	tests := map[string]string{
+		"corp.example.":          "corp.example",
+		"host.corp.example.":     "corp.example",
+		"host.lab.corp.example.": "lab.corp.example",
+		"notcorp.example.":       "",
+	}
+	for name, want := range tests {
+		got := ""
+		if rule := findForwardRule(name); rule != nil {
+			got = rule.Suffix
+		}
+		if got != want {
+			t.Errorf("%s: expected rule %q, got %q", name, want, got)
+		}
+	}
+}
+
+// TestForwardCacheEviction tests that a full cache drops the least recently used response
+func TestForwardCacheEviction(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config.ForwardCacheSize = 2
+
+	cache := newForwardCache()
+	keys := make([]forwardCacheKey, 3)
+	for i, name := range []string{"a.example.net.", "b.example.net.", "c.example.net."} {
+		keys[i] = forwardCacheKey{name: name, qtype: dns.TypeA, qclass: dns.ClassINET}
+	}
+	put := func(key forwardCacheKey) {
+		resp := new(dns.Msg)
+		resp.SetQuestion(key.name, key.qtype)
+		rr, _ := dns.NewRR(key.name + " 300 IN A 192.0.2.1")
+		resp.Answer = []dns.RR{rr}
+		cache.put(key, resp)
+	}
+
+	put(keys[0])
+	put(keys[1])
+	if cache.get(keys[0]) == nil {
+		t.Fatalf("Expected %s to be cached", keys[0].name)
+	}
+	put(keys[2])
+
+	for i, want := range []bool{true, false, true} {
+		if cached := cache.get(keys[i]) != nil; cached != want {
+			t.Errorf("%s: expected cached=%v, got %v", keys[i].name, want, cached)
+		}
+	}
+}
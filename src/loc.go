@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// locOrigin is the wire value of the equator and the prime meridian, and
+	// locAltitudeBase that of an altitude of 0m, which is 100000m above the
+	// lowest representable altitude (RFC 1876 section 2)
+	locOrigin       = 1 << 31
+	locAltitudeBase = 100000 * 100
+
+	// locMaxAltitude is the highest altitude a LOC record can hold, in meters
+	locMaxAltitude = 42849672.95
+	// locMaxSize is the largest size or precision a LOC record can hold, in meters
+	locMaxSize = 90000000.00
+)
+
+// parseLOC parses a LOC record in the RFC 1876 presentation format:
+//
+//	d1 [m1 [s1]] {N|S} d2 [m2 [s2]] {E|W} alt[m] [size[m] [hp[m] [vp[m]]]]
+//
+// Size defaults to 1m, the horizontal precision to 10000m and the vertical
+// precision to 10m. The header of the returned record is left empty.
+func parseLOC(value string) (*dns.LOC, error) {
+	fields := strings.Fields(value)
+	loc := &dns.LOC{Size: 0x12, HorizPre: 0x16, VertPre: 0x13}
+
+	latitude, fields, err := parseLOCCoordinate(fields, "latitude", 90, "N", "S")
+	if err != nil {
+		return nil, err
+	}
+	loc.Latitude = latitude
+	longitude, fields, err := parseLOCCoordinate(fields, "longitude", 180, "E", "W")
+	if err != nil {
+		return nil, err
+	}
+	loc.Longitude = longitude
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("LOC needs an altitude")
+	}
+	altitude, err := parseLOCMeters(fields[0])
+	if err != nil || altitude < -100000 || altitude > locMaxAltitude {
+		return nil, fmt.Errorf("invalid LOC altitude '%s', must be -100000.00m to %.2fm", fields[0], locMaxAltitude)
+	}
+	loc.Altitude = uint32(math.Round(altitude*100) + locAltitudeBase)
+	fields = fields[1:]
+
+	precisions := []struct {
+		name  string
+		value *uint8
+	}{
+		{"size", &loc.Size},
+		{"horizontal precision", &loc.HorizPre},
+		{"vertical precision", &loc.VertPre},
+	}
+	if len(fields) > len(precisions) {
+		return nil, fmt.Errorf("LOC has %d fields too many", len(fields)-len(precisions))
+	}
+	for i, field := range fields {
+		meters, err := parseLOCMeters(field)
+		if err != nil || meters < 0 || meters > locMaxSize {
+			return nil, fmt.Errorf("invalid LOC %s '%s', must be 0m to %.0fm", precisions[i].name, field, locMaxSize)
+		}
+		*precisions[i].value = encodeLOCSize(meters)
+	}
+	return loc, nil
+}
+
+// parseLOCCoordinate parses degrees, optional minutes and seconds and the
+// hemisphere from the start of fields. It returns the coordinate in the
+// thousandths of an arc second of the wire format and the remaining fields.
+func parseLOCCoordinate(fields []string, name string, maxDegrees uint64, positive, negative string) (uint32, []string, error) {
+	hemisphere := func(field string) (int64, bool) {
+		switch strings.ToUpper(field) {
+		case positive:
+			return 1, true
+		case negative:
+			return -1, true
+		}
+		return 0, false
+	}
+
+	var parts []string
+	for len(fields) > 0 && len(parts) < 4 {
+		parts = append(parts, fields[0])
+		fields = fields[1:]
+		if _, ok := hemisphere(parts[len(parts)-1]); ok {
+			break
+		}
+	}
+	if len(parts) < 2 {
+		return 0, nil, fmt.Errorf("LOC needs a %s with %s or %s", name, positive, negative)
+	}
+	sign, ok := hemisphere(parts[len(parts)-1])
+	if !ok {
+		return 0, nil, fmt.Errorf("invalid LOC %s '%s', must end in %s or %s", name, strings.Join(parts, " "), positive, negative)
+	}
+	parts = parts[:len(parts)-1]
+
+	degrees, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil || degrees > maxDegrees {
+		return 0, nil, fmt.Errorf("invalid LOC %s degrees '%s', must be 0-%d", name, parts[0], maxDegrees)
+	}
+	var minutes uint64
+	if len(parts) > 1 {
+		minutes, err = strconv.ParseUint(parts[1], 10, 8)
+		if err != nil || minutes > 59 {
+			return 0, nil, fmt.Errorf("invalid LOC %s minutes '%s', must be 0-59", name, parts[1])
+		}
+	}
+	var seconds float64
+	if len(parts) > 2 {
+		seconds, err = parseLOCNumber(parts[2])
+		if err != nil || seconds < 0 || seconds >= 60 {
+			return 0, nil, fmt.Errorf("invalid LOC %s seconds '%s', must be 0-59.999", name, parts[2])
+		}
+	}
+
+	// Thousandths of an arc second
+	offset := int64(degrees)*3600000 + int64(minutes)*60000 + int64(math.Round(seconds*1000))
+	if offset > int64(maxDegrees)*3600000 {
+		return 0, nil, fmt.Errorf("invalid LOC %s '%s', beyond %d degrees", name, strings.Join(parts, " "), maxDegrees)
+	}
+	return uint32(locOrigin + sign*offset), fields, nil
+}
+
+// parseLOCMeters parses a distance in meters with an optional "m" suffix and
+// at most two decimals
+func parseLOCMeters(field string) (float64, error) {
+	number := strings.TrimSuffix(strings.TrimSuffix(field, "m"), "M")
+	if _, decimals, ok := strings.Cut(number, "."); ok && len(decimals) > 2 {
+		return 0, fmt.Errorf("more than two decimals")
+	}
+	return parseLOCNumber(number)
+}
+
+// parseLOCNumber parses a plain decimal number. ParseFloat also accepts
+// exponents, hexadecimal, NaN and infinities, and NaN would pass every range
+// check.
+func parseLOCNumber(field string) (float64, error) {
+	if strings.ContainsAny(field, "eExX_") {
+		return 0, fmt.Errorf("not a decimal number")
+	}
+	number, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, fmt.Errorf("not a decimal number")
+	}
+	return number, nil
+}
+
+// encodeLOCSize encodes a size or precision in meters as the mantissa and
+// power of ten of its value in centimeters. Digits beyond the first are
+// dropped, as in the reference implementation.
+func encodeLOCSize(meters float64) uint8 {
+	centimeters := uint64(math.Round(meters * 100))
+	var exponent uint8
+	for centimeters >= 10 {
+		centimeters /= 10
+		exponent++
+	}
+	return uint8(centimeters)<<4 | exponent
+}
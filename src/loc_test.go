@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// TestParseLOC tests LOC parsing against the miekg/dns zone parser and its
+// presentation format
+func TestParseLOC(t *testing.T) {
+	values := []string{
+		"52 22 23.000 N 4 53 32.000 E -2.00m 0.00m 10000m 10m",
+		"42 21 54 N 71 06 18 W -24m 30m",
+		"42 21 43.952 N 71 5 6.344 W -24m 1m 200m",
+		"51 30 12.748 N 0 7 39.611 W 0.00m",
+		"37 S 144 E 30m",
+		"90 N 180 W 42849672.95m 90000000m 90000000m 90000000m",
+		"0 0 0.001 s 0 0 0.001 e -100000m 0.5m 1.5m 0.01m",
+		"32 7 19 S 116 2 25 E 10M 1M",
+	}
+
+	for _, value := range values {
+		t.Run(value, func(t *testing.T) {
+			loc, err := parseLOC(value)
+			if err != nil {
+				t.Fatalf("parseLOC failed: %v", err)
+			}
+			loc.Hdr = dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeLOC, Class: dns.ClassINET, Ttl: 3600}
+
+			want, err := dns.NewRR("example.com. 3600 IN LOC " + value)
+			if err != nil {
+				t.Fatalf("miekg/dns rejects %q: %v", value, err)
+			}
+			if !dns.IsDuplicate(loc, want) {
+				t.Errorf("Parsed differently from miekg/dns:\nwant %v\ngot  %v", want, loc)
+			}
+
+			// The presentation format miekg/dns prints parses back to the same record
+			presentation := strings.TrimPrefix(want.String(), want.Header().String())
+			again, err := parseLOC(presentation)
+			if err != nil {
+				t.Fatalf("parseLOC(%q) failed: %v", presentation, err)
+			}
+			again.Hdr = loc.Hdr
+			if again.String() != want.String() {
+				t.Errorf("Round trip differs:\nwant %v\ngot  %v", want, again)
+			}
+		})
+	}
+}
+
+// TestParseLOCErrors tests the validation of malformed LOC values
+func TestParseLOCErrors(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr string
+	}{
+		{"", "LOC needs a latitude"},
+		{"52 22 23 N", "LOC needs a longitude"},
+		{"52 22 23 N 4 53 32 E", "LOC needs an altitude"},
+		{"91 N 4 E 0m", "invalid LOC latitude degrees '91', must be 0-90"},
+		{"90 1 N 4 E 0m", "beyond 90 degrees"},
+		{"52 60 N 4 E 0m", "invalid LOC latitude minutes '60'"},
+		{"52 22 60 N 4 E 0m", "invalid LOC latitude seconds '60'"},
+		{"52 22 1e1 N 4 E 0m", "invalid LOC latitude seconds"},
+		{"52 22 23 X 4 E 0m", "must end in N or S"},
+		{"52 22 23 E 4 E 0m", "must end in N or S"},
+		{"52 N 181 E 0m", "invalid LOC longitude degrees '181', must be 0-180"},
+		{"52 N 4 53 -1 E 0m", "invalid LOC longitude seconds '-1'"},
+		{"52 N 4 W 42849673m", "invalid LOC altitude '42849673m'"},
+		{"52 N 4 W -100001m", "invalid LOC altitude"},
+		{"52 N 4 W high", "invalid LOC altitude 'high'"},
+		{"52 N 4 W 0m 1.234m", "invalid LOC size '1.234m'"},
+		{"52 N 4 W 0m 1m 90000001m", "invalid LOC horizontal precision '90000001m'"},
+		{"52 N 4 W 0m 1m 1m -1m", "invalid LOC vertical precision '-1m'"},
+		{"52 22 nan N 4 E 0m", "invalid LOC latitude seconds 'nan'"},
+		{"52 N 4 53 inf E 0m", "invalid LOC longitude seconds 'inf'"},
+		{"52 N 4 W nanm", "invalid LOC altitude 'nanm'"},
+		{"52 N 4 W -infm", "invalid LOC altitude '-infm'"},
+		{"52 N 4 W 0m nan", "invalid LOC size 'nan'"},
+		{"52 N 4 W 0m 1m infinity", "invalid LOC horizontal precision 'infinity'"},
+		{"52 N 4 W 0m 1m 1m NaNm", "invalid LOC vertical precision 'NaNm'"},
+		{"52 N 4 W 0m 1m 1m 1m 1m", "LOC has 1 fields too many"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			_, err := parseLOC(tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestLOCRecords tests LOC records from CSV and multi-record JSON
+func TestLOCRecords(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	const value = "52 22 23.000 N 4 53 32.000 E -2.00m 0.00m 10000m 10m"
+
+	t.Run("CSV", func(t *testing.T) {
+		tmpFile, err := createTempCSV("name,type,value,ttl\n" +
+			"office.example.com,LOC,\"" + value + "\",3600\n" +
+			"bad.example.com,LOC,52 22 23 N 200 E 0m,3600\n")
+		if err != nil {
+			t.Fatalf("Failed to create temp CSV: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+
+		load := func() (*RecordStore, error) { return loadRecordsFromCSV(tmpFile.Name()) }
+		_, err = buildRecordStore(load)
+		if err == nil || !strings.Contains(err.Error(), "line 3: bad.example.com LOC: invalid LOC longitude degrees '200'") {
+			t.Fatalf("Expected the malformed LOC to be reported, got %v", err)
+		}
+
+		os.WriteFile(tmpFile.Name(), []byte("name,type,value,ttl\noffice.example.com,LOC,"+value+",3600\n"), 0644)
+		store, err := buildRecordStore(load)
+		if err != nil {
+			t.Fatalf("Failed to load CSV: %v", err)
+		}
+		rrs := store.lookupRecord("office.example.com.", dns.TypeLOC)
+		// As miekg/dns prints it
+		if len(rrs) != 1 || rrs[0].String() != "office.example.com.\t3600\tIN\tLOC\t52 22 23.000 N 04 53 32.000 E -2m 0.00m 10000m 10m" {
+			t.Errorf("Unexpected LOC records: %v", rrs)
+		}
+	})
+
+	t.Run("Multi-record JSON", func(t *testing.T) {
+		rr := createRRFromMultiRecord(MultiRecord{"LOC": "37 49 S 144 58 E 30m 10m"}, "office.2dns.dev", dns.TypeLOC)
+		loc, ok := rr.(*dns.LOC)
+		if !ok {
+			t.Fatalf("Expected a LOC record, got %v", rr)
+		}
+		if got := strings.TrimPrefix(loc.String(), loc.Hdr.String()); got != "37 49 0.000 S 144 58 0.000 E 30m 10m 10000m 10m" {
+			t.Errorf("Unexpected LOC record: %s", got)
+		}
+
+		if rr := createRRFromMultiRecord(MultiRecord{"LOC": "somewhere"}, "office.2dns.dev", dns.TypeLOC); rr != nil {
+			t.Errorf("Expected no record for a malformed LOC, got %v", rr)
+		}
+	})
+}
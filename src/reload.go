@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// recordLoader builds a fresh RecordStore from the configured record sources
+type recordLoader func() (*RecordStore, error)
+
+// reloadMu serialises reloads triggered by SIGHUP and by the file watcher
+var reloadMu sync.Mutex
+
+// buildRecordStore loads a new store off to the side and validates it before
+// it is allowed to replace the one being served
+func buildRecordStore(load recordLoader) (*RecordStore, error) {
+	store, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.validate(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reloadRecords replaces the served record store with a freshly loaded one.
+// If loading or validation fails the current store is kept and the error is returned.
+func reloadRecords(load recordLoader, source string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	store, err := buildRecordStore(load)
+	if err != nil {
+		return err
+	}
+
+	old := swapRecordStore(store)
+	diff := diffRecordStores(old, store)
+	if transfersEnabled() {
+		zoneJournal.record(old, store)
+	}
+
+	log.Printf("Reloaded %d records from %s: %d added, %d removed, %d changed",
+		store.count(), source, len(diff.Added), len(diff.Removed), len(diff.Changed))
+	if config.VerboseLogging {
+		for _, key := range diff.Added {
+			log.Printf("  added %s", key)
+		}
+		for _, key := range diff.Removed {
+			log.Printf("  removed %s", key)
+		}
+		for _, key := range diff.Changed {
+			log.Printf("  changed %s", key)
+		}
+	}
+	return nil
+}
+
+// recordStoreDiff lists the RRsets (as "name TYPE") that differ between two stores
+type recordStoreDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// diffRecordStores compares two backends RRset by RRset. Either may be nil.
+func diffRecordStores(oldStore, newStore RecordBackend) recordStoreDiff {
+	oldSets := recordSets(oldStore)
+	newSets := recordSets(newStore)
+
+	var diff recordStoreDiff
+	for key, values := range newSets {
+		oldValues, found := oldSets[key]
+		if !found {
+			diff.Added = append(diff.Added, key)
+		} else if strings.Join(oldValues, "\n") != strings.Join(values, "\n") {
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range oldSets {
+		if _, found := newSets[key]; !found {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// recordSets groups the records of a backend by owner name and type, with the
+// record data of each set in a stable order
+func recordSets(store RecordBackend) map[string][]string {
+	sets := make(map[string][]string)
+	if store == nil {
+		return sets
+	}
+	store.enumerate(func(record DNSRecord) bool {
+		key := record.Name + " " + record.Type
+		sets[key] = append(sets[key], fmt.Sprintf("%s %d %d %d %d",
+			record.Value, record.ttl(), record.Priority, record.Weight, record.Port))
+		return true
+	})
+	for _, values := range sets {
+		sort.Strings(values)
+	}
+	return sets
+}
+
+// startRecordReloader reloads the record store on SIGHUP and, when interval is
+// positive, whenever one of the watched files changes on disk. paths lists the
+// files to watch; it is called again on every poll, so files that are added
+// later are watched as well.
+func startRecordReloader(load recordLoader, paths func() []string, interval time.Duration) {
+	reload := func(reason string) {
+		log.Printf("Reloading records (%s)", reason)
+		// The sources are listed again, as files may have been added since startup
+		if err := reloadRecords(load, strings.Join(paths(), ", ")); err != nil {
+			log.Printf("Reload failed, keeping previous records: %v", err)
+		}
+	}
+
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			reload("SIGHUP")
+		}
+	}()
+
+	if interval > 0 {
+		go watchFiles(paths, interval, nil, func(path string) {
+			reload(path + " changed")
+		})
+	}
+}
+
+// fileState is the part of a file's metadata used to detect changes
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func (s fileState) equal(other fileState) bool {
+	return s.exists == other.exists && s.size == other.size && s.modTime.Equal(other.modTime)
+}
+
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size(), exists: true}
+}
+
+// watchFiles polls the files paths returns every interval and calls onChange
+// with the path of any file whose modification time or size changed. The list
+// is taken again on every poll; files new to it are only recorded, as the
+// change that added them (to a directory or an $INCLUDE) is reported itself.
+// It returns when stop is closed.
+func watchFiles(paths func() []string, interval time.Duration, stop <-chan struct{}, onChange func(path string)) {
+	states := make(map[string]fileState)
+	for _, path := range paths() {
+		states[path] = statFile(path)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		watched := make(map[string]fileState)
+		for _, path := range paths() {
+			state := statFile(path)
+			watched[path] = state
+			// A file that is being replaced may briefly disappear
+			if previous, known := states[path]; known && !state.equal(previous) && state.exists {
+				onChange(path)
+			}
+		}
+		states = watched
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TestReloadRecords tests swapping in a freshly loaded record store
+func TestReloadRecords(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	tmpFile, err := createTempCSV(`name,type,value,ttl,priority,weight,port
+example.com,A,10.0.0.1,3600,,,
+new.example.com,A,10.0.0.2,3600,,,`)
+	if err != nil {
+		t.Fatalf("Failed to create temp CSV: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	load := func() (*RecordStore, error) {
+		return loadRecordsFromCSV(tmpFile.Name())
+	}
+
+	t.Run("Valid file is swapped in", func(t *testing.T) {
+		if err := reloadRecords(load, tmpFile.Name()); err != nil {
+			t.Fatalf("reloadRecords failed: %v", err)
+		}
+
+		records := currentRecordStore().lookupRecord("new.example.com", dns.TypeA)
+		if len(records) != 1 {
+			t.Fatalf("Expected 1 record for new.example.com, got %d", len(records))
+		}
+		if a := records[0].(*dns.A); a.A.String() != "10.0.0.2" {
+			t.Errorf("Expected IP 10.0.0.2, got %s", a.A.String())
+		}
+	})
+
+	t.Run("Invalid file keeps previous store", func(t *testing.T) {
+		before := currentRecordStore()
+
+		if err := os.WriteFile(tmpFile.Name(), []byte("name,type,value,ttl\nexample.com,A,10.0.0.1,invalid"), 0644); err != nil {
+			t.Fatalf("Failed to rewrite CSV: %v", err)
+		}
+		if err := reloadRecords(load, tmpFile.Name()); err == nil {
+			t.Fatal("Expected reload of unparsable file to fail")
+		}
+		if currentRecordStore() != before {
+			t.Error("Record store was replaced despite failed reload")
+		}
+	})
+
+	t.Run("Invalid record value keeps previous store", func(t *testing.T) {
+		before := currentRecordStore()
+
+		if err := os.WriteFile(tmpFile.Name(), []byte("name,type,value,ttl\nexample.com,A,not-an-ip,3600"), 0644); err != nil {
+			t.Fatalf("Failed to rewrite CSV: %v", err)
+		}
+		if err := reloadRecords(load, tmpFile.Name()); err == nil {
+			t.Fatal("Expected reload with invalid A record to fail")
+		}
+		if currentRecordStore() != before {
+			t.Error("Record store was replaced despite failed validation")
+		}
+	})
+}
+
+// TestDiffRecordStores tests the added/removed/changed summary between two stores
+func TestDiffRecordStores(t *testing.T) {
+	oldStore := &RecordStore{
+		Records: map[string][]DNSRecord{
+			"example.com":      {{Name: "example.com", Type: "A", Value: "192.168.1.1"}},
+			"old.example.com":  {{Name: "old.example.com", Type: "A", Value: "192.168.1.2"}},
+			"same.example.com": {{Name: "same.example.com", Type: "TXT", Value: "unchanged"}},
+		},
+		WildRecords: map[string][]DNSRecord{},
+	}
+	newStore := &RecordStore{
+		Records: map[string][]DNSRecord{
+			"example.com":      {{Name: "example.com", Type: "A", Value: "192.168.1.100"}},
+			"same.example.com": {{Name: "same.example.com", Type: "TXT", Value: "unchanged"}},
+		},
+		WildRecords: map[string][]DNSRecord{
+			"example.com": {{Name: "*.example.com", Type: "A", Value: "192.168.1.3"}},
+		},
+	}
+
+	diff := diffRecordStores(oldStore, newStore)
+
+	if len(diff.Added) != 1 || diff.Added[0] != "*.example.com A" {
+		t.Errorf("Expected added [*.example.com A], got %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "old.example.com A" {
+		t.Errorf("Expected removed [old.example.com A], got %v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0] != "example.com A" {
+		t.Errorf("Expected changed [example.com A], got %v", diff.Changed)
+	}
+
+	// A nil previous store reports everything as added
+	if diff := diffRecordStores(nil, newStore); len(diff.Added) != 3 {
+		t.Errorf("Expected 3 added sets against nil store, got %v", diff.Added)
+	}
+}
+
+// TestWatchFiles tests that modifying a watched file triggers a callback
+func TestWatchFiles(t *testing.T) {
+	tmpFile, err := createTempCSV("name,type,value\n")
+	if err != nil {
+		t.Fatalf("Failed to create temp CSV: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	changed := make(chan string, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go watchFiles(func() []string { return []string{tmpFile.Name()} }, 10*time.Millisecond, stop, func(path string) {
+		select {
+		case changed <- path:
+		default:
+		}
+	})
+
+	// Give the watcher time to record the initial state
+	time.Sleep(30 * time.Millisecond)
+	if err := os.WriteFile(tmpFile.Name(), []byte("name,type,value\nexample.com,A,192.168.1.1\n"), 0644); err != nil {
+		t.Fatalf("Failed to rewrite CSV: %v", err)
+	}
+
+	select {
+	case path := <-changed:
+		if path != tmpFile.Name() {
+			t.Errorf("Expected change for %s, got %s", tmpFile.Name(), path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for file change notification")
+	}
+}
+
+// TestWatchFilesAddedLater tests that files added to a records directory after
+// the watcher started are watched for edits too
+func TestWatchFilesAddedLater(t *testing.T) {
+	dir := t.TempDir()
+	sources := recordSources{Dirs: []string{dir}}
+
+	changed := make(chan string, 16)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go watchFiles(sources.watchPaths, 10*time.Millisecond, stop, func(path string) {
+		select {
+		case changed <- path:
+		default:
+		}
+	})
+
+	waitFor := func(want string) {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case path := <-changed:
+				if path == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for a change of %s", want)
+			}
+		}
+	}
+
+	// Give the watcher time to record the initial state
+	time.Sleep(30 * time.Millisecond)
+	path := filepath.Join(dir, "new.csv")
+	if err := os.WriteFile(path, []byte("name,type,value\n"), 0644); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+	waitFor(dir)
+
+	// Give the watcher time to pick up the new file
+	time.Sleep(30 * time.Millisecond)
+	if err := os.WriteFile(path, []byte("name,type,value\nexample.com,A,192.168.1.1\n"), 0644); err != nil {
+		t.Fatalf("Failed to rewrite CSV: %v", err)
+	}
+	waitFor(path)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// secondaryInitialRetry is how long to wait before trying a zone's first
+	// transfer again, while its SOA timers are not known yet
+	secondaryInitialRetry = 30 * time.Second
+	// secondaryTimeout bounds SOA queries to a primary and each read of a transfer
+	secondaryTimeout = 10 * time.Second
+)
+
+// secondaryZone is a zone served from copies transferred from its primaries
+type secondaryZone struct {
+	Zone      string // Apex, lowercase without the trailing dot
+	Primaries []upstreamServer
+
+	mu      sync.Mutex
+	records []DNSRecord // Transferred records, nil before the first transfer and after expiry
+	soa     *dns.SOA    // SOA of the transferred records
+	expires time.Time   // When the records expire unless a refresh succeeds
+
+	notify chan struct{} // Wakes the refresh loop on NOTIFY
+}
+
+func newSecondaryZone(zone string, primaries []upstreamServer) *secondaryZone {
+	return &secondaryZone{
+		Zone:      strings.ToLower(strings.TrimSuffix(zone, ".")),
+		Primaries: primaries,
+		notify:    make(chan struct{}, 1),
+	}
+}
+
+// secondaryZonesFlag collects repeated -secondary flags of the form
+// "zone=primary[,primary...]"
+type secondaryZonesFlag []*secondaryZone
+
+func (f *secondaryZonesFlag) String() string {
+	var zones []string
+	for _, zone := range *f {
+		var primaries []string
+		for _, primary := range zone.Primaries {
+			primaries = append(primaries, primary.String())
+		}
+		zones = append(zones, zone.Zone+"="+strings.Join(primaries, ","))
+	}
+	return strings.Join(zones, " ")
+}
+
+func (f *secondaryZonesFlag) Set(value string) error {
+	zone, list, ok := strings.Cut(value, "=")
+	zone = strings.TrimSpace(zone)
+	if _, valid := dns.IsDomainName(zone); !ok || zone == "" || !valid {
+		return fmt.Errorf("secondary zone %q: expected zone=primary[,primary...]", value)
+	}
+	primaries, err := parseUpstreams(list)
+	if err != nil {
+		return fmt.Errorf("secondary zone %q: %v", value, err)
+	}
+	if len(primaries) == 0 {
+		return fmt.Errorf("secondary zone %q: no primaries", value)
+	}
+	*f = append(*f, newSecondaryZone(zone, primaries))
+	return nil
+}
+
+// current returns the transferred records of the zone
+func (zone *secondaryZone) current() []DNSRecord {
+	zone.mu.Lock()
+	defer zone.mu.Unlock()
+	return zone.records
+}
+
+// findSecondaryZone returns the secondary zone with apex name, or nil
+func findSecondaryZone(name string) *secondaryZone {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, zone := range config.SecondaryZones {
+		if zone.Zone == name {
+			return zone
+		}
+	}
+	return nil
+}
+
+// withSecondaryZones extends load, which may be nil, with the records most
+// recently transferred for each secondary zone. Conflicts between local and
+// transferred records are reported by validation like any other.
+func withSecondaryZones(load recordLoader, zones []*secondaryZone) recordLoader {
+	return func() (*RecordStore, error) {
+		store := newRecordStore()
+		if load != nil {
+			local, err := load()
+			if err != nil {
+				return nil, err
+			}
+			store = local
+		}
+		for _, zone := range zones {
+			for _, record := range zone.current() {
+				store.addRecord(record)
+			}
+		}
+		store.compile()
+		return store, nil
+	}
+}
+
+// startSecondaryZones keeps every secondary zone refreshed in the background.
+// Whenever a zone's records change, the served store is rebuilt with load.
+func startSecondaryZones(zones []*secondaryZone, load recordLoader) {
+	for _, zone := range zones {
+		apply := func() {
+			if err := reloadRecords(load, "secondary zone "+zone.Zone); err != nil {
+				log.Printf("Failed to serve secondary zone %s: %v", zone.Zone, err)
+			}
+		}
+		go zone.run(apply, nil)
+	}
+}
+
+// run refreshes the zone until stop is closed: at once, then after the SOA
+// refresh interval, after the retry interval while the primaries fail, and
+// whenever a NOTIFY arrives
+func (zone *secondaryZone) run(apply func(), stop <-chan struct{}) {
+	for {
+		timer := time.NewTimer(zone.refresh(apply))
+		select {
+		case <-timer.C:
+		case <-zone.notify:
+			timer.Stop()
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// refresh asks the primaries in order for the zone's SOA and transfers the
+// zone from the first that has a newer serial (RFC 1035 section 4.3.5). If no
+// primary can be reached before the expire interval has passed since the last
+// successful check, the zone is no longer served. apply is called whenever
+// the records changed. It returns how long to wait before the next refresh.
+func (zone *secondaryZone) refresh(apply func()) time.Duration {
+	zone.mu.Lock()
+	current := zone.soa
+	if zone.records == nil {
+		current = nil
+	}
+	zone.mu.Unlock()
+
+	lastErr := fmt.Errorf("no primaries")
+	for _, primary := range zone.Primaries {
+		soa, err := zone.querySOA(primary)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", primary, err)
+			continue
+		}
+		if current != nil && !serialGreater(soa.Serial, current.Serial) {
+			zone.mu.Lock()
+			zone.expires = time.Now().Add(soaInterval(current.Expire))
+			zone.mu.Unlock()
+			return soaInterval(current.Refresh)
+		}
+
+		records, soa, err := zone.transfer(primary)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", primary, err)
+			continue
+		}
+		zone.mu.Lock()
+		zone.records, zone.soa = records, soa
+		zone.expires = time.Now().Add(soaInterval(soa.Expire))
+		zone.mu.Unlock()
+
+		log.Printf("Transferred secondary zone %s serial %d from %s: %d records", zone.Zone, soa.Serial, primary, len(records))
+		apply()
+		return soaInterval(soa.Refresh)
+	}
+
+	log.Printf("Refresh of secondary zone %s failed: %v", zone.Zone, lastErr)
+	if current == nil {
+		return secondaryInitialRetry
+	}
+
+	zone.mu.Lock()
+	expired := time.Now().After(zone.expires)
+	if expired {
+		zone.records = nil
+	}
+	zone.mu.Unlock()
+	if expired {
+		log.Printf("Secondary zone %s expired; no longer serving it", zone.Zone)
+		apply()
+	}
+	return soaInterval(current.Retry)
+}
+
+// soaInterval converts an SOA timer to a duration of at least a second
+func soaInterval(seconds uint32) time.Duration {
+	if seconds == 0 {
+		seconds = 1
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// querySOA asks primary for the zone's SOA record
+func (zone *secondaryZone) querySOA(primary upstreamServer) (*dns.SOA, error) {
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(zone.Zone), dns.TypeSOA)
+	client := &dns.Client{Net: primary.Net, Timeout: secondaryTimeout, TsigSecret: signTransferRequest(req)}
+	resp, _, err := client.Exchange(req, primary.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("SOA query answered %s", dns.RcodeToString[resp.Rcode])
+	}
+	for _, rr := range resp.Answer {
+		if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, dns.Fqdn(zone.Zone)) {
+			return soa, nil
+		}
+	}
+	return nil, fmt.Errorf("no SOA record for %s in the answer", zone.Zone)
+}
+
+// transfer pulls the whole zone from primary with AXFR. Records of types
+// 2dns cannot serve, such as the DNSSEC signatures of a signed zone, and
+// records outside the zone are left out.
+func (zone *secondaryZone) transfer(primary upstreamServer) ([]DNSRecord, *dns.SOA, error) {
+	req := new(dns.Msg)
+	req.SetAxfr(dns.Fqdn(zone.Zone))
+	transfer := &dns.Transfer{ReadTimeout: secondaryTimeout, TsigSecret: signTransferRequest(req)}
+	ch, err := transfer.In(req, primary.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var records []DNSRecord
+	var soa *dns.SOA
+	skipped := 0
+	for envelope := range ch {
+		if envelope.Error != nil {
+			return nil, nil, envelope.Error
+		}
+		for _, rr := range envelope.RR {
+			if !dns.IsSubDomain(dns.Fqdn(zone.Zone), rr.Header().Name) {
+				skipped++
+				continue
+			}
+			if s, ok := rr.(*dns.SOA); ok {
+				// The SOA both opens and closes the transfer
+				if soa != nil {
+					continue
+				}
+				soa = s
+			}
+			record, err := recordFromRR(rr)
+			if err != nil {
+				skipped++
+				continue
+			}
+			record.File = "axfr://" + primary.Addr + "/" + zone.Zone
+			records = append(records, record)
+		}
+	}
+	if soa == nil {
+		return nil, nil, fmt.Errorf("transfer of %s had no SOA record", zone.Zone)
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d records of secondary zone %s that cannot be served", skipped, zone.Zone)
+	}
+	return records, soa, nil
+}
+
+// signTransferRequest signs req with the -secondary-key, if one is set, and
+// returns the TSIG secrets to verify the responses with
+func signTransferRequest(req *dns.Msg) map[string]string {
+	if config.SecondaryKey == "" {
+		return nil
+	}
+	req.SetTsig(config.SecondaryKey, dns.HmacSHA256, 300, time.Now().Unix())
+	return config.TSIGSecrets
+}
+
+// handleNotify answers a NOTIFY (RFC 1996) and, when it comes from a primary
+// of one of our secondary zones, refreshes that zone right away
+func handleNotify(w dns.ResponseWriter, r *dns.Msg) {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Authoritative = true
+
+	var zone *secondaryZone
+	if len(r.Question) == 1 {
+		zone = findSecondaryZone(r.Question[0].Name)
+	}
+	switch {
+	case zone == nil:
+		msg.Rcode = dns.RcodeNotAuth
+	case !zone.fromPrimary(w, r):
+		log.Printf("Ignoring NOTIFY for %s from %s, which is not a primary", zone.Zone, w.RemoteAddr())
+		msg.Rcode = dns.RcodeRefused
+	default:
+		if config.VerboseLogging {
+			log.Printf("NOTIFY for %s from %s", zone.Zone, w.RemoteAddr())
+		}
+		select {
+		case zone.notify <- struct{}{}:
+		default:
+			// A refresh is already pending
+		}
+	}
+
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+		msg.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+	w.WriteMsg(msg)
+}
+
+// fromPrimary reports whether a NOTIFY for the zone may be trusted: it is
+// signed with one of our TSIG keys or sent from the address of a primary
+func (zone *secondaryZone) fromPrimary(w dns.ResponseWriter, r *dns.Msg) bool {
+	if r.IsTsig() != nil {
+		return w.TsigStatus() == nil
+	}
+
+	var ip net.IP
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	}
+	if ip == nil {
+		return false
+	}
+	for _, primary := range zone.Primaries {
+		host, _, _ := net.SplitHostPort(primary.Addr)
+		addrs, err := net.LookupIP(host)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// stubPrimary is an in-process primary server for one zone
+type stubPrimary struct {
+	mu        sync.Mutex
+	zone      []dns.RR // SOA first
+	failing   bool     // Answer everything with SERVFAIL
+	transfers int      // AXFR requests served
+}
+
+// set replaces the zone with an SOA of serial and timers plus rrs
+func (p *stubPrimary) set(serial, refresh, retry, expire uint32, rrs ...string) {
+	zone := []dns.RR{&dns.SOA{
+		Hdr:     dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:      "ns1.example.org.",
+		Mbox:    "admin.example.org.",
+		Serial:  serial,
+		Refresh: refresh,
+		Retry:   retry,
+		Expire:  expire,
+		Minttl:  300,
+	}}
+	for _, s := range rrs {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			panic(err)
+		}
+		zone = append(zone, rr)
+	}
+	p.mu.Lock()
+	p.zone = zone
+	p.mu.Unlock()
+}
+
+func (p *stubPrimary) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	p.mu.Lock()
+	zone, failing := p.zone, p.failing
+	if r.Question[0].Qtype == dns.TypeAXFR && !failing {
+		p.transfers++
+	}
+	p.mu.Unlock()
+
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	if failing {
+		msg.Rcode = dns.RcodeServerFailure
+		w.WriteMsg(msg)
+		return
+	}
+	switch r.Question[0].Qtype {
+	case dns.TypeSOA:
+		msg.Answer = zone[:1]
+		w.WriteMsg(msg)
+	case dns.TypeAXFR:
+		ch := make(chan *dns.Envelope, 2)
+		// Split over two messages, closed by the SOA
+		ch <- &dns.Envelope{RR: zone}
+		ch <- &dns.Envelope{RR: zone[:1]}
+		close(ch)
+		new(dns.Transfer).Out(w, r, ch)
+		w.Close()
+	default:
+		msg.Rcode = dns.RcodeRefused
+		w.WriteMsg(msg)
+	}
+}
+
+func (p *stubPrimary) transferCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.transfers
+}
+
+// startSecondary sets up a secondary zone for example.org pulled from a new
+// stub primary, served like main does
+func startSecondary(t *testing.T) (*stubPrimary, *secondaryZone, func()) {
+	t.Helper()
+	primary := &stubPrimary{}
+	primary.set(1, 3600, 1, 3600,
+		"example.org. 3600 IN NS ns1.example.org.",
+		"ns1.example.org. 3600 IN A 192.0.2.53",
+		"www.example.org. 300 IN A 192.0.2.1",
+		"example.org. 300 IN MX 10 mail.example.org.",
+		"example.org. 300 IN RRSIG SOA 8 2 3600 20300101000000 20200101000000 12345 example.org. AAAA",
+		"outside.example.net. 300 IN A 192.0.2.99",
+	)
+	primaries, err := parseUpstreams(startStubUpstream(t, primary.ServeDNS))
+	if err != nil {
+		t.Fatalf("Invalid primary: %v", err)
+	}
+	zone := newSecondaryZone("example.org", primaries)
+	config.SecondaryZones = []*secondaryZone{zone}
+	load := withSecondaryZones(nil, config.SecondaryZones)
+	apply := func() {
+		if err := reloadRecords(load, "secondary zone example.org"); err != nil {
+			t.Errorf("Reload failed: %v", err)
+		}
+	}
+	return primary, zone, apply
+}
+
+// query sends a question through handleDNSRequest
+func query(name string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	w := newMockResponseWriter()
+	handleDNSRequest(w, req)
+	return w.msg
+}
+
+// TestSecondaryZone tests transferring a zone, NOTIFY and reflection on top of it
+func TestSecondaryZone(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	primary, zone, apply := startSecondary(t)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		zone.run(apply, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	answer := func(name string, qtype uint16) string {
+		resp := query(name, qtype)
+		if resp == nil || len(resp.Answer) == 0 {
+			return ""
+		}
+		return resp.Answer[0].String()
+	}
+	waitFor(t, func() bool { return answer("www.example.org.", dns.TypeA) != "" })
+
+	t.Run("Transferred records are served", func(t *testing.T) {
+		resp := query("www.example.org.", dns.TypeA)
+		if !resp.Authoritative || resp.Answer[0].(*dns.A).A.String() != "192.0.2.1" {
+			t.Errorf("Unexpected answer: %v", resp)
+		}
+		if got := answer("example.org.", dns.TypeMX); got == "" {
+			t.Error("Expected the MX record")
+		}
+		if resp := query("missing.example.org.", dns.TypeA); resp.Rcode != dns.RcodeNameError || len(resp.Ns) != 1 {
+			t.Errorf("Expected NXDOMAIN with the transferred SOA, got %v", resp)
+		}
+		if store := currentRecordStore(); store.nameExists("outside.example.net") {
+			t.Error("Record outside the zone was accepted")
+		}
+	})
+
+	t.Run("Reflection", func(t *testing.T) {
+		resp := query("10.0.0.9.example.org.", dns.TypeA)
+		if resp == nil || len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "10.0.0.9" {
+			t.Errorf("Expected a reflected answer inside the secondary zone, got %v", resp)
+		}
+	})
+
+	t.Run("NOTIFY", func(t *testing.T) {
+		primary.set(2, 3600, 1, 3600, "www.example.org. 300 IN A 192.0.2.2")
+
+		notify := new(dns.Msg)
+		notify.SetNotify("example.org.")
+		w := newMockResponseWriter()
+		handleDNSRequest(w, notify)
+		if w.msg.Rcode != dns.RcodeSuccess || w.msg.Opcode != dns.OpcodeNotify || !w.msg.Response {
+			t.Fatalf("Unexpected NOTIFY response: %v", w.msg)
+		}
+		waitFor(t, func() bool { return answer("www.example.org.", dns.TypeA) == "www.example.org.\t300\tIN\tA\t192.0.2.2" })
+		if answer("example.org.", dns.TypeMX) != "" {
+			t.Error("Expected the MX record to be gone after the transfer")
+		}
+
+		w = newMockResponseWriter()
+		w.remoteAddr = &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 53}
+		handleDNSRequest(w, notify)
+		if w.msg.Rcode != dns.RcodeRefused {
+			t.Errorf("Expected NOTIFY from another address to be refused, got %s", dns.RcodeToString[w.msg.Rcode])
+		}
+
+		other := new(dns.Msg)
+		other.SetNotify("example.com.")
+		w = newMockResponseWriter()
+		handleDNSRequest(w, other)
+		if w.msg.Rcode != dns.RcodeNotAuth {
+			t.Errorf("Expected NOTIFY for another zone to be NOTAUTH, got %s", dns.RcodeToString[w.msg.Rcode])
+		}
+	})
+}
+
+// TestSecondaryRefresh tests the SOA refresh, retry and expire timers
+func TestSecondaryRefresh(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	primary, zone, apply := startSecondary(t)
+	primary.set(1, 60, 5, 1, "www.example.org. 300 IN A 192.0.2.1")
+
+	if wait := zone.refresh(apply); wait != 60*time.Second {
+		t.Errorf("Expected the refresh interval after a transfer, got %v", wait)
+	}
+	if wait := zone.refresh(apply); wait != 60*time.Second || primary.transferCount() != 1 {
+		t.Errorf("Expected no transfer for an unchanged serial, got %d transfers and wait %v", primary.transferCount(), wait)
+	}
+
+	primary.mu.Lock()
+	primary.failing = true
+	primary.mu.Unlock()
+	if wait := zone.refresh(apply); wait != 5*time.Second {
+		t.Errorf("Expected the retry interval while the primary fails, got %v", wait)
+	}
+	if len(query("www.example.org.", dns.TypeA).Answer) != 1 {
+		t.Error("Expected the zone to be served until it expires")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	zone.refresh(apply)
+	if resp := query("www.example.org.", dns.TypeA); len(resp.Answer) != 0 {
+		t.Errorf("Expected the expired zone to be dropped, got %v", resp.Answer)
+	}
+
+	primary.mu.Lock()
+	primary.failing = false
+	primary.mu.Unlock()
+	zone.refresh(apply)
+	if len(query("www.example.org.", dns.TypeA).Answer) != 1 || primary.transferCount() != 2 {
+		t.Error("Expected the zone to be transferred again once the primary is back")
+	}
+}
+
+// TestSecondaryZonesFlag tests parsing -secondary
+func TestSecondaryZonesFlag(t *testing.T) {
+	var zones secondaryZonesFlag
+	if err := zones.Set("Example.org.=192.0.2.53,tcp://[2001:db8::53]:5353"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if got := fmt.Sprint(&zones); got != "example.org=udp://192.0.2.53:53,tcp://[2001:db8::53]:5353" {
+		t.Errorf("Unexpected zones: %s", got)
+	}
+	for _, bad := range []string{"example.org", "example.org=", "=192.0.2.53", "example.org=ftp://192.0.2.53"} {
+		if err := zones.Set(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
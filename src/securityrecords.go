@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// The headers of the records returned by the parsers in this file are left
+// empty for createRR to fill in.
+
+// parseDS parses a DS or CDS value: key tag, algorithm, digest type and the
+// hex digest (RFC 4034 section 5.3). Digest types 1, 2 and 4 are SHA-1,
+// SHA-256 and SHA-384 digests of their own length; the CDS "0 0 0 00" asks
+// the parent to delete the DS RRset (RFC 8078 section 4).
+func parseDS(recordType, value string) (*dns.DS, error) {
+	parts := strings.Fields(value)
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("%s needs key tag, algorithm, digest type and digest", recordType)
+	}
+	keyTag, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s key tag '%s', must be 0-65535", recordType, parts[0])
+	}
+	algorithm, err := parseAlgorithm(recordType, parts[1])
+	if err != nil {
+		return nil, err
+	}
+	digestType, err := strconv.ParseUint(parts[2], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s digest type '%s', must be 0-255", recordType, parts[2])
+	}
+	// Zone files may split the digest with spaces
+	digest := strings.ToUpper(strings.Join(parts[3:], ""))
+	sizes := map[uint8]int{dns.SHA1: 20, dns.SHA256: 32, dns.SHA384: 48}
+	if err := validateHexDigest(recordType+" digest", digest, sizes[uint8(digestType)]); err != nil {
+		return nil, err
+	}
+	return &dns.DS{KeyTag: uint16(keyTag), Algorithm: algorithm, DigestType: uint8(digestType), Digest: digest}, nil
+}
+
+// parseDNSKEY parses a DNSKEY or CDNSKEY value: flags, protocol, algorithm
+// and the base64 public key (RFC 4034 section 2.2)
+func parseDNSKEY(recordType, value string) (*dns.DNSKEY, error) {
+	parts := strings.Fields(value)
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("%s needs flags, protocol, algorithm and public key", recordType)
+	}
+	flags, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s flags '%s', must be 0-65535", recordType, parts[0])
+	}
+	// The protocol field must be 3 (RFC 4034 section 2.1.2)
+	if parts[1] != "3" {
+		return nil, fmt.Errorf("invalid %s protocol '%s', must be 3", recordType, parts[1])
+	}
+	algorithm, err := parseAlgorithm(recordType, parts[2])
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := parseBase64Fields(recordType+" public key", parts[3:])
+	if err != nil {
+		return nil, err
+	}
+	return &dns.DNSKEY{Flags: uint16(flags), Protocol: 3, Algorithm: algorithm, PublicKey: publicKey}, nil
+}
+
+// parseSMIMEA parses an SMIMEA value (RFC 8162), which has the format of a
+// TLSA value: usage, selector, matching type and the hex certificate data
+func parseSMIMEA(value string) (*dns.SMIMEA, error) {
+	parts := strings.Fields(value)
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("SMIMEA needs usage, selector, matching type and certificate data")
+	}
+	var fields [3]uint8
+	for i, field := range []string{"usage", "selector", "matching type"} {
+		n, err := strconv.ParseUint(parts[i], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMIMEA %s '%s'", field, parts[i])
+		}
+		fields[i] = uint8(n)
+	}
+	// Matching types 1 and 2 are SHA-256 and SHA-512 digests
+	certificate := strings.Join(parts[3:], "")
+	if err := validateHexDigest("SMIMEA certificate data", certificate, map[uint8]int{1: 32, 2: 64}[fields[2]]); err != nil {
+		return nil, err
+	}
+	return &dns.SMIMEA{Usage: fields[0], Selector: fields[1], MatchingType: fields[2], Certificate: certificate}, nil
+}
+
+// parseOPENPGPKEY parses an OPENPGPKEY value: the base64 transferable public
+// key (RFC 7929 section 2.3)
+func parseOPENPGPKEY(value string) (*dns.OPENPGPKEY, error) {
+	publicKey, err := parseBase64Fields("OPENPGPKEY public key", strings.Fields(value))
+	if err != nil {
+		return nil, err
+	}
+	return &dns.OPENPGPKEY{PublicKey: publicKey}, nil
+}
+
+// parseURI parses a URI value: priority, weight and the target URI as a
+// quoted string (RFC 7553 section 4.4)
+func parseURI(value string) (*dns.URI, error) {
+	fields, err := splitRecordFields(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("URI needs priority, weight and target, got %d fields", len(fields))
+	}
+	priority, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URI priority '%s', must be 0-65535", fields[0])
+	}
+	weight, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URI weight '%s', must be 0-65535", fields[1])
+	}
+	if fields[2] == "" {
+		return nil, fmt.Errorf("URI target must not be empty")
+	}
+	return &dns.URI{Priority: uint16(priority), Weight: uint16(weight), Target: escapeCharacterString(fields[2])}, nil
+}
+
+// parseCERT parses a CERT value: certificate type, key tag, algorithm and
+// the base64 certificate (RFC 4398 section 2.2). The type and algorithm may
+// be numbers or mnemonics such as PKIX and RSASHA256.
+func parseCERT(value string) (*dns.CERT, error) {
+	parts := strings.Fields(value)
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("CERT needs type, key tag, algorithm and certificate")
+	}
+	certType, ok := dns.StringToCertType[strings.ToUpper(parts[0])]
+	if !ok {
+		n, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CERT type '%s'", parts[0])
+		}
+		certType = uint16(n)
+	}
+	keyTag, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CERT key tag '%s', must be 0-65535", parts[1])
+	}
+	algorithm, err := parseAlgorithm("CERT", parts[2])
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := parseBase64Fields("CERT certificate", parts[3:])
+	if err != nil {
+		return nil, err
+	}
+	return &dns.CERT{Type: certType, KeyTag: uint16(keyTag), Algorithm: algorithm, Certificate: certificate}, nil
+}
+
+// parseAlgorithm parses a DNSSEC algorithm number or mnemonic
+func parseAlgorithm(recordType, s string) (uint8, error) {
+	if algorithm, ok := dns.StringToAlgorithm[strings.ToUpper(s)]; ok {
+		return algorithm, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s algorithm '%s'", recordType, s)
+	}
+	return uint8(n), nil
+}
+
+// parseBase64Fields joins base64 data that zone files may split with spaces
+// and checks that it decodes
+func parseBase64Fields(what string, fields []string) (string, error) {
+	data := strings.Join(fields, "")
+	if decoded, err := base64.StdEncoding.DecodeString(data); err != nil || len(decoded) == 0 {
+		return "", fmt.Errorf("invalid %s '%s', must be base64", what, data)
+	}
+	return data, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// testPublicKey and testCertificate are base64 test data, not real keys
+	testPublicKey   = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8gISIjJCUmJygpKissLS4vMDEyMzQ1Njc4OTo7PD0+Pw=="
+	testCertificate = "ZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXp7fH1+f4CBgoOEhYaHiImKiw=="
+	testSHA256      = "d2abde240d7cd3ee6b4b28c54df034b97983a1d16e8a410e4561cb106618e971"
+)
+
+// TestSecurityRecords tests DS, DNSKEY, CDS, CDNSKEY, OPENPGPKEY, SMIMEA,
+// URI and CERT parsing against the miekg/dns zone parser
+func TestSecurityRecords(t *testing.T) {
+	tests := []struct {
+		recordType string
+		value      string
+		zone       string // The same record data in a zone file
+	}{
+		{"DS", "60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118", "60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118"},
+		{"DS", "2371 13 2 " + testSHA256, "2371 13 2 " + testSHA256},
+		{"DS", "2371 ECDSAP256SHA256 2 " + testSHA256[:32] + " " + testSHA256[32:], "2371 13 2 " + testSHA256},
+		{"CDS", "2371 13 2 " + testSHA256, "2371 13 2 " + testSHA256},
+		{"CDS", "0 0 0 00", "0 0 0 00"},
+		{"DNSKEY", "257 3 13 " + testPublicKey, "257 3 13 " + testPublicKey},
+		{"DNSKEY", "256 3 8 " + testPublicKey[:40] + " " + testPublicKey[40:], "256 3 8 " + testPublicKey},
+		{"CDNSKEY", "257 3 13 " + testPublicKey, "257 3 13 " + testPublicKey},
+		{"CDNSKEY", "0 3 0 AA==", "0 3 0 AA=="},
+		{"OPENPGPKEY", testPublicKey, testPublicKey},
+		{"OPENPGPKEY", testPublicKey[:20] + " " + testPublicKey[20:], testPublicKey},
+		{"SMIMEA", "3 0 1 " + testSHA256, "3 0 1 " + testSHA256},
+		{"SMIMEA", "0 0 0 " + strings.Repeat("ab", 100), "0 0 0 " + strings.Repeat("ab", 100)},
+		{"URI", `10 1 "ftp://ftp1.example.com/public"`, `10 1 "ftp://ftp1.example.com/public"`},
+		{"URI", `1 0 "https://example.com/a \"b\""`, `1 0 "https://example.com/a \"b\""`},
+		{"CERT", "PGP 0 0 " + testCertificate, "PGP 0 0 " + testCertificate},
+		{"CERT", "1 12345 RSASHA256 " + testCertificate, "PKIX 12345 RSASHA256 " + testCertificate},
+		{"CERT", "pkix 12345 8 " + testCertificate, "PKIX 12345 8 " + testCertificate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.recordType+" "+tt.value, func(t *testing.T) {
+			record := DNSRecord{Name: "example.com", Type: tt.recordType, Value: tt.value, TTL: 3600}
+			if err := validateRecordValue(record); err != nil {
+				t.Fatalf("Validation failed: %v", err)
+			}
+			rr := createRR(record, "example.com.", dns.StringToType[tt.recordType])
+			if rr == nil {
+				t.Fatal("createRR returned nil")
+			}
+			want, err := dns.NewRR("example.com. 3600 IN " + tt.recordType + " " + tt.zone)
+			if err != nil {
+				t.Fatalf("miekg/dns rejects %q: %v", tt.zone, err)
+			}
+			// Hex digests compare in their printed, uppercase form
+			if rr.String() != want.String() {
+				t.Errorf("Parsed differently from miekg/dns:\nwant %v\ngot  %v", want, rr)
+			}
+
+			// Records from zone files and transfers convert back to the same data
+			converted, err := recordFromRR(want)
+			if err != nil {
+				t.Fatalf("recordFromRR failed: %v", err)
+			}
+			if again := createRR(converted, "example.com.", dns.StringToType[tt.recordType]); again == nil || again.String() != want.String() {
+				t.Errorf("Value %q from recordFromRR gives %v", converted.Value, again)
+			}
+		})
+	}
+}
+
+// TestSecurityRecordErrors tests the validation of malformed values
+func TestSecurityRecordErrors(t *testing.T) {
+	tests := []struct {
+		recordType string
+		value      string
+		wantErr    string
+	}{
+		{"DS", "60485 5 1", "DS needs key tag, algorithm, digest type and digest"},
+		{"DS", "70000 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118", "invalid DS key tag '70000'"},
+		{"DS", "60485 RSA 1 2BB183AF5F22588179A53B0A98631FAD1A292118", "invalid DS algorithm 'RSA'"},
+		{"DS", "60485 5 x 2BB183AF5F22588179A53B0A98631FAD1A292118", "invalid DS digest type 'x'"},
+		{"DS", "60485 5 2 2BB183AF5F22588179A53B0A98631FAD1A292118", "DS digest is 20 bytes, the digest type needs 32"},
+		{"CDS", "60485 5 1 not-hex", "invalid CDS digest 'NOT-HEX', must be hex"},
+		{"DNSKEY", "257 3 13", "DNSKEY needs flags, protocol, algorithm and public key"},
+		{"DNSKEY", "257 2 13 " + testPublicKey, "invalid DNSKEY protocol '2', must be 3"},
+		{"DNSKEY", "65536 3 13 " + testPublicKey, "invalid DNSKEY flags '65536'"},
+		{"CDNSKEY", "257 3 13 not*base64", "invalid CDNSKEY public key 'not*base64', must be base64"},
+		{"OPENPGPKEY", "", "invalid OPENPGPKEY public key '', must be base64"},
+		{"SMIMEA", "3 0 1 abcd", "SMIMEA certificate data is 2 bytes, the digest type needs 32"},
+		{"SMIMEA", "3 x 1 " + testSHA256, "invalid SMIMEA selector 'x'"},
+		{"URI", `10 "ftp://ftp1.example.com/public"`, "URI needs priority, weight and target, got 2 fields"},
+		{"URI", `10 x "ftp://ftp1.example.com/public"`, "invalid URI weight 'x'"},
+		{"URI", `10 1 ""`, "URI target must not be empty"},
+		{"CERT", "X509 0 0 " + testCertificate, "invalid CERT type 'X509'"},
+		{"CERT", "PGP 0 0 %%%", "invalid CERT certificate '%%%', must be base64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.recordType+" "+tt.value, func(t *testing.T) {
+			err := validateRecordValue(DNSRecord{Name: "example.com", Type: tt.recordType, Value: tt.value})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestSecurityRecordLookups tests the new types from CSV, including a DS
+// answered by the parent of a delegation, and from multi-record JSON
+func TestSecurityRecordLookups(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	t.Run("CSV", func(t *testing.T) {
+		tmpFile, err := createTempCSV("name,type,value,ttl\n" +
+			"example.com,SOA,ns1.example.com. admin.example.com. 1 3600 600 86400 300,3600\n" +
+			"example.com,NS,ns1.example.com,3600\n" +
+			"example.com,DNSKEY,257 3 13 " + testPublicKey + ",3600\n" +
+			"child.example.com,NS,ns1.child.example.com,3600\n" +
+			"child.example.com,DS,2371 13 2 " + testSHA256 + ",3600\n" +
+			"child.example.com,CDS,2371 13 2 " + testSHA256 + ",3600\n" +
+			"_443._tcp.www.example.com,TLSA,3 1 1 " + testSHA256 + ",3600\n" +
+			"c93f1e400f26708f98cb19d936620da35eec8f72e57f9eec01c1afd6._openpgpkey.example.com,OPENPGPKEY," + testPublicKey + ",3600\n" +
+			"c93f1e400f26708f98cb19d936620da35eec8f72e57f9eec01c1afd6._smimecert.example.com,SMIMEA,3 0 1 " + testSHA256 + ",3600\n" +
+			`_ftp._tcp.example.com,URI,"10 1 ""ftp://ftp1.example.com/public""",3600` + "\n" +
+			"example.com,CERT,PKIX 0 0 " + testCertificate + ",3600\n")
+		if err != nil {
+			t.Fatalf("Failed to create temp CSV: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+
+		store, err := buildRecordStore(func() (*RecordStore, error) { return loadRecordsFromCSV(tmpFile.Name()) })
+		if err != nil {
+			t.Fatalf("Failed to load CSV: %v", err)
+		}
+		recordStore = store
+
+		tests := []struct {
+			qname string
+			qtype uint16
+		}{
+			{"example.com.", dns.TypeDNSKEY},
+			{"child.example.com.", dns.TypeDS},
+			{"c93f1e400f26708f98cb19d936620da35eec8f72e57f9eec01c1afd6._openpgpkey.example.com.", dns.TypeOPENPGPKEY},
+			{"c93f1e400f26708f98cb19d936620da35eec8f72e57f9eec01c1afd6._smimecert.example.com.", dns.TypeSMIMEA},
+			{"_ftp._tcp.example.com.", dns.TypeURI},
+			{"example.com.", dns.TypeCERT},
+		}
+		for _, tt := range tests {
+			resp := query(tt.qname, tt.qtype)
+			if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 || resp.Answer[0].Header().Rrtype != tt.qtype {
+				t.Errorf("%s %s: unexpected response %v", tt.qname, dns.TypeToString[tt.qtype], resp)
+			}
+		}
+
+		// Everything else at the cut is referred to the child
+		if resp := query("child.example.com.", dns.TypeCDS); resp.Authoritative || len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+			t.Errorf("Expected a referral for CDS at the cut, got %v", resp)
+		}
+	})
+
+	t.Run("Multi-record JSON", func(t *testing.T) {
+		multiRecord := MultiRecord{
+			"DS":  "2371 13 2 " + testSHA256,
+			"URI": `10 1 "https://example.com/"`,
+		}
+		if ds, ok := createRRFromMultiRecord(multiRecord, "test.2dns.dev", dns.TypeDS).(*dns.DS); !ok || ds.KeyTag != 2371 || ds.Digest != strings.ToUpper(testSHA256) {
+			t.Errorf("Unexpected DS record: %v", ds)
+		}
+		if uri, ok := createRRFromMultiRecord(multiRecord, "test.2dns.dev", dns.TypeURI).(*dns.URI); !ok || uri.Target != "https://example.com/" {
+			t.Errorf("Unexpected URI record: %v", uri)
+		}
+		if rr := createRRFromMultiRecord(MultiRecord{"DS": "2371 13 2 abcd"}, "test.2dns.dev", dns.TypeDS); rr != nil {
+			t.Errorf("Expected no record for a malformed DS, got %v", rr)
+		}
+	})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxIncludeDepth limits how deeply $INCLUDE directives in CSV files may nest
+const maxIncludeDepth = 8
+
+// stringListFlag collects the values of a repeatable string flag
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// recordSources are the record files and directories given on the command
+// line. They are all merged into one RecordStore.
+type recordSources struct {
+	CSVFiles []string // -csv files
+	ZoneFile string   // -zone file
+	Origin   string   // Initial $ORIGIN of the -zone file
+	Dirs     []string // -records-dir directories
+}
+
+// sourceFile is a single file to load records from
+type sourceFile struct {
+	Path   string
+	Zone   bool   // An RFC 1035 zone file rather than CSV
+	Origin string // Initial $ORIGIN of a zone file
+}
+
+func (sources recordSources) empty() bool {
+	return len(sources.CSVFiles) == 0 && sources.ZoneFile == "" && len(sources.Dirs) == 0
+}
+
+// String describes the sources for log messages
+func (sources recordSources) String() string {
+	var names []string
+	names = append(names, sources.CSVFiles...)
+	if sources.ZoneFile != "" {
+		names = append(names, sources.ZoneFile)
+	}
+	names = append(names, sources.Dirs...)
+	return strings.Join(names, ", ")
+}
+
+// files lists the files to load. Directories are expanded to the *.csv and
+// *.zone files in them, in name order; the origin of a zone file found in a
+// directory is its file name without the .zone extension.
+func (sources recordSources) files() ([]sourceFile, error) {
+	var files []sourceFile
+	for _, path := range sources.CSVFiles {
+		files = append(files, sourceFile{Path: path})
+	}
+	if sources.ZoneFile != "" {
+		files = append(files, sourceFile{Path: sources.ZoneFile, Zone: true, Origin: sources.Origin})
+	}
+
+	for _, dir := range sources.Dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read records directory: %v", err)
+		}
+		// ReadDir returns the entries sorted by name
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			switch filepath.Ext(entry.Name()) {
+			case ".csv":
+				files = append(files, sourceFile{Path: path})
+			case ".zone":
+				files = append(files, sourceFile{Path: path, Zone: true, Origin: strings.TrimSuffix(entry.Name(), ".zone")})
+			}
+		}
+	}
+	return files, nil
+}
+
+// load reads every source into one store. Problems in all files are reported
+// together; conflicts between files are found by validate like any other.
+func (sources recordSources) load() (*RecordStore, error) {
+	files, err := sources.files()
+	if err != nil {
+		return nil, err
+	}
+
+	store := newRecordStore()
+	var errs []error
+	for _, file := range files {
+		if !file.Zone {
+			errs = append(errs, readCSVFile(store, file.Path, nil)...)
+			continue
+		}
+		zone, err := loadRecordsFromZoneFile(file.Path, file.Origin)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, record := range zone.allRecords() {
+			store.addRecord(record)
+		}
+	}
+
+	if len(errs) > 0 {
+		// Report problems with the records that did load as well
+		if err := store.validate(); err != nil {
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	// Compile the records now rather than on the first query
+	store.compile()
+
+	return store, nil
+}
+
+// watchPaths returns the files and directories to watch for changes. A
+// directory's modification time changes when files are added or removed, and
+// the files in it are watched for edits, as are the files pulled in with
+// $INCLUDE.
+func (sources recordSources) watchPaths() []string {
+	// Listing the named files can't fail, only reading the directories can
+	files, _ := recordSources{CSVFiles: sources.CSVFiles, ZoneFile: sources.ZoneFile}.files()
+	if len(sources.Dirs) > 0 {
+		if dirFiles, err := (recordSources{Dirs: sources.Dirs}).files(); err == nil {
+			files = append(files, dirFiles...)
+		}
+	}
+
+	paths := append([]string{}, sources.Dirs...)
+	for _, file := range files {
+		paths = append(paths, file.Path)
+		paths = append(paths, includedFiles(file)...)
+	}
+	return paths
+}
+
+// includedFiles returns the files file pulls in with $INCLUDE, directly or
+// through the files it includes. Files that can't be read are left out; load
+// reports them.
+func includedFiles(file sourceFile) []string {
+	var included []string
+	seen := map[string]bool{filepath.Clean(file.Path): true}
+	var walk func(path string, depth int)
+	walk = func(path string, depth int) {
+		if depth >= maxIncludeDepth {
+			return
+		}
+		for _, name := range includeDirectives(path, file.Zone) {
+			include := includePath(path, name)
+			if seen[filepath.Clean(include)] {
+				continue
+			}
+			seen[filepath.Clean(include)] = true
+			included = append(included, include)
+			walk(include, depth+1)
+		}
+	}
+	walk(file.Path, 0)
+	return included
+}
+
+// includeDirectives returns the file names given to the $INCLUDE directives
+// in a zone or CSV file
+func includeDirectives(path string, zone bool) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	if zone {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && strings.EqualFold(fields[0], "$INCLUDE") {
+				names = append(names, fields[1])
+			}
+		}
+		return names
+	}
+
+	// The same reader settings as readCSVFile
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			return names
+		}
+		if len(record) >= 2 && strings.EqualFold(strings.TrimSpace(record[0]), "$INCLUDE") && strings.TrimSpace(record[1]) != "" {
+			names = append(names, strings.TrimSpace(record[1]))
+		}
+	}
+}
+
+// includePath resolves the path of an $INCLUDE directive relative to the
+// directory of the file that contains it
+func includePath(from, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(from), path)
+}
+
+// checkInclude rejects an $INCLUDE of path by the files in chain (outermost
+// first) that would loop or nest too deeply
+func checkInclude(chain []string, path string) error {
+	for _, including := range chain {
+		if filepath.Clean(including) == filepath.Clean(path) {
+			return fmt.Errorf("$INCLUDE %s: include loop", path)
+		}
+	}
+	if len(chain) >= maxIncludeDepth {
+		return fmt.Errorf("$INCLUDE %s: includes nested more than %d deep", path, maxIncludeDepth)
+	}
+	return nil
+}
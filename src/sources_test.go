@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// writeFiles creates files with the given contents in dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+// TestRecordSources tests merging several CSV files, zone files and directories into one store
+func TestRecordSources(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"web.csv": "name,type,value,ttl\nwww.example.com,A,192.0.2.1,300\n",
+		"mail.csv": "name,type,value,ttl,priority\n" +
+			"example.com,MX,mail.example.com,300,10\n" +
+			"$INCLUDE,shared/hosts.csv\n",
+		"shared/hosts.csv":       "name,type,value\nmail.example.com,A,192.0.2.25\n",
+		"zones/example.org.zone": "$TTL 300\n@ IN SOA ns1 admin 1 3600 600 86400 60\nwww IN A 198.51.100.1\n$INCLUDE hosts.inc\n",
+		"zones/hosts.inc":        "mail IN A 198.51.100.25\n",
+		"zones/example.net.csv":  "name,type,value\nexample.net,TXT,from a directory\n",
+		"zones/notes.txt":        "not a record file\n",
+	})
+
+	sources := recordSources{
+		CSVFiles: []string{filepath.Join(dir, "web.csv"), filepath.Join(dir, "mail.csv")},
+		Dirs:     []string{filepath.Join(dir, "zones")},
+	}
+	store, err := buildRecordStore(sources.load)
+	if err != nil {
+		t.Fatalf("Failed to load sources: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		qtype uint16
+	}{
+		{"www.example.com.", dns.TypeA},
+		{"example.com.", dns.TypeMX},
+		{"mail.example.com.", dns.TypeA},
+		{"example.org.", dns.TypeSOA},
+		{"www.example.org.", dns.TypeA},
+		{"mail.example.org.", dns.TypeA},
+		{"example.net.", dns.TypeTXT},
+	}
+	for _, tt := range tests {
+		if rrs := store.lookupRecord(tt.name, tt.qtype); len(rrs) != 1 {
+			t.Errorf("%s %s: expected 1 record, got %v", tt.name, dns.TypeToString[tt.qtype], rrs)
+		}
+	}
+	if store.count() != 7 {
+		t.Errorf("Expected 7 records, got %d", store.count())
+	}
+
+	paths := sources.watchPaths()
+	for _, want := range []string{
+		filepath.Join(dir, "zones"),
+		filepath.Join(dir, "zones", "example.org.zone"),
+		filepath.Join(dir, "zones", "hosts.inc"),
+		filepath.Join(dir, "shared", "hosts.csv"),
+	} {
+		found := false
+		for _, path := range paths {
+			found = found || path == want
+		}
+		if !found {
+			t.Errorf("Expected %s to be watched, got %v", want, paths)
+		}
+	}
+}
+
+// TestRecordSourceConflicts tests the errors for conflicting data in different files
+func TestRecordSourceConflicts(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.csv": "name,type,value\n" +
+			"www.example.com,CNAME,lb.example.net\n" +
+			"example.com,SOA,ns1.example.com. admin.example.com. 1 3600 600 86400 60\n",
+		"b.csv": "name,type,value\n" +
+			"www.example.com,A,192.0.2.1\n" +
+			"example.com,SOA,ns2.example.com. admin.example.com. 2 3600 600 86400 60\n",
+		"loop.csv":    "name,type,value\n$INCLUDE,loop2.csv\n",
+		"loop2.csv":   "name,type,value\n$INCLUDE,loop.csv\n",
+		"missing.csv": "name,type,value\n$INCLUDE,nowhere.csv\n",
+	})
+	a, b := filepath.Join(dir, "a.csv"), filepath.Join(dir, "b.csv")
+
+	_, err := buildRecordStore(recordSources{CSVFiles: []string{a, b}}.load)
+	if err == nil {
+		t.Fatal("Expected conflicting files to be rejected")
+	}
+	for _, want := range []string{
+		a + ": line 2: www.example.com: CNAME and other data (A record on line 2 of " + b + ")",
+		b + ": line 3: example.com: more than one SOA record on line 3 of " + a,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error containing %q, got:\n%v", want, err)
+		}
+	}
+
+	_, err = recordSources{CSVFiles: []string{filepath.Join(dir, "loop.csv")}}.load()
+	if err == nil || !strings.Contains(err.Error(), "include loop") {
+		t.Errorf("Expected an include loop error, got %v", err)
+	}
+
+	_, err = recordSources{CSVFiles: []string{filepath.Join(dir, "missing.csv")}}.load()
+	if err == nil || !strings.Contains(err.Error(), "nowhere.csv") {
+		t.Errorf("Expected an error for the missing include, got %v", err)
+	}
+
+	_, err = recordSources{Dirs: []string{filepath.Join(dir, "nowhere")}}.load()
+	if err == nil {
+		t.Errorf("Expected an error for a missing directory")
+	}
+}
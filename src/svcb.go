@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// svcbKeys are the SVCB and HTTPS parameters 2dns understands (RFC 9460
+// section 14.3.2)
+var svcbKeys = map[string]dns.SVCBKey{
+	"mandatory":       dns.SVCB_MANDATORY,
+	"alpn":            dns.SVCB_ALPN,
+	"no-default-alpn": dns.SVCB_NO_DEFAULT_ALPN,
+	"port":            dns.SVCB_PORT,
+	"ipv4hint":        dns.SVCB_IPV4HINT,
+	"ech":             dns.SVCB_ECHCONFIG,
+	"ipv6hint":        dns.SVCB_IPV6HINT,
+}
+
+// parseSVCB parses an SVCB or HTTPS value in the RFC 9460 presentation
+// format:
+//
+//	priority target [key=value ...]
+//
+// e.g. 1 . alpn=h3,h2 port=443 ipv4hint=192.0.2.1 ech="AEn+DQ...". Priority
+// 0 is AliasMode, which takes no parameters. The header of the returned
+// record is left empty.
+func parseSVCB(recordType, value string) (*dns.SVCB, error) {
+	fields, err := splitRecordFields(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("%s needs priority and target", recordType)
+	}
+	priority, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s priority '%s', must be 0-65535", recordType, fields[0])
+	}
+	if err := validateTarget(fields[1], true); err != nil {
+		return nil, err
+	}
+	svcb := &dns.SVCB{Priority: uint16(priority), Target: dns.Fqdn(fields[1])}
+
+	params := fields[2:]
+	if priority == 0 && len(params) > 0 {
+		return nil, fmt.Errorf("%s priority 0 is AliasMode, which takes no parameters", recordType)
+	}
+	seen := make(map[dns.SVCBKey]bool)
+	var mandatory []dns.SVCBKey
+	for _, param := range params {
+		name, arg, hasArg := strings.Cut(param, "=")
+		key, ok := svcbKeys[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported %s parameter '%s'", recordType, name)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%s parameter %s given more than once", recordType, key)
+		}
+		seen[key] = true
+		if key != dns.SVCB_NO_DEFAULT_ALPN && (!hasArg || arg == "") {
+			return nil, fmt.Errorf("%s parameter %s needs a value", recordType, key)
+		}
+
+		var kv dns.SVCBKeyValue
+		switch key {
+		case dns.SVCB_MANDATORY:
+			for _, name := range strings.Split(arg, ",") {
+				listed, ok := svcbKeys[strings.ToLower(name)]
+				if !ok || listed == dns.SVCB_MANDATORY {
+					return nil, fmt.Errorf("invalid %s mandatory key '%s'", recordType, name)
+				}
+				mandatory = append(mandatory, listed)
+			}
+			kv = &dns.SVCBMandatory{Code: mandatory}
+		case dns.SVCB_ALPN:
+			alpn := strings.Split(arg, ",")
+			for _, id := range alpn {
+				if id == "" || len(id) > maxCharacterString {
+					return nil, fmt.Errorf("invalid %s alpn '%s'", recordType, arg)
+				}
+			}
+			kv = &dns.SVCBAlpn{Alpn: alpn}
+		case dns.SVCB_NO_DEFAULT_ALPN:
+			// miekg/dns prints it as no-default-alpn=""
+			if arg != "" {
+				return nil, fmt.Errorf("%s parameter no-default-alpn takes no value", recordType)
+			}
+			kv = &dns.SVCBNoDefaultAlpn{}
+		case dns.SVCB_PORT:
+			port, err := strconv.ParseUint(arg, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s port '%s', must be 0-65535", recordType, arg)
+			}
+			kv = &dns.SVCBPort{Port: uint16(port)}
+		case dns.SVCB_IPV4HINT, dns.SVCB_IPV6HINT:
+			var hints []net.IP
+			for _, addr := range strings.Split(arg, ",") {
+				ip := net.ParseIP(addr)
+				if ip == nil || (ip.To4() != nil) != (key == dns.SVCB_IPV4HINT) {
+					return nil, fmt.Errorf("invalid %s %s address '%s'", recordType, key, addr)
+				}
+				hints = append(hints, ip)
+			}
+			if key == dns.SVCB_IPV4HINT {
+				kv = &dns.SVCBIPv4Hint{Hint: hints}
+			} else {
+				kv = &dns.SVCBIPv6Hint{Hint: hints}
+			}
+		case dns.SVCB_ECHCONFIG:
+			ech, err := base64.StdEncoding.DecodeString(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s ech '%s', must be base64", recordType, arg)
+			}
+			kv = &dns.SVCBECHConfig{ECH: ech}
+		}
+		svcb.Value = append(svcb.Value, kv)
+	}
+
+	// Keys listed as mandatory must be present (RFC 9460 section 8)
+	for _, key := range mandatory {
+		if !seen[key] {
+			return nil, fmt.Errorf("%s mandatory key %s is missing", recordType, key)
+		}
+	}
+	return svcb, nil
+}
+
+// svcbTarget returns the name whose addresses an SVCB or HTTPS record points
+// to, or "" for AliasMode records without a target. A ServiceMode target of
+// "." stands for the owner name (RFC 9460 section 2.5).
+func svcbTarget(svcb *dns.SVCB) string {
+	if svcb.Target != "." {
+		return svcb.Target
+	}
+	if svcb.Priority == 0 {
+		return ""
+	}
+	return svcb.Hdr.Name
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// TestParseSVCB tests SVCB and HTTPS parsing against the miekg/dns zone parser
+func TestParseSVCB(t *testing.T) {
+	const ech = "AEj+DQBEAQAgACAdd+scUi0IYFsXnUIU7ko2Nd9+F8M26pAGZVpz/KrWPgAEAAEAAWQVZWNoLXNpdGVzLmV4YW1wbGUubmV0AAA="
+	tests := []struct {
+		recordType string
+		value      string
+		zone       string // The same record data in a zone file
+	}{
+		{"HTTPS", "0 www.example.net", "0 www.example.net."},
+		{"HTTPS", "1 .", "1 ."},
+		{"HTTPS", "1 . alpn=h3,h2 ipv4hint=192.0.2.1,192.0.2.2 ipv6hint=2001:db8::1", `1 . alpn="h3,h2" ipv4hint="192.0.2.1,192.0.2.2" ipv6hint="2001:db8::1"`},
+		{"HTTPS", `1 . alpn="h3,h2" ech="` + ech + `"`, `1 . alpn="h3,h2" ech="` + ech + `"`},
+		{"HTTPS", "2 svc.example.net. port=8443 no-default-alpn alpn=h2", `2 svc.example.net. alpn="h2" no-default-alpn port="8443"`},
+		{"SVCB", "1 svc.example.net mandatory=alpn,port alpn=doq port=853", `1 svc.example.net. mandatory="alpn,port" alpn="doq" port="853"`},
+		{"SVCB", "16 . PORT=53 IPv4Hint=192.0.2.53", `16 . port="53" ipv4hint="192.0.2.53"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.recordType+" "+tt.value, func(t *testing.T) {
+			record := DNSRecord{Name: "example.com", Type: tt.recordType, Value: tt.value, TTL: 3600}
+			if err := validateRecordValue(record); err != nil {
+				t.Fatalf("Validation failed: %v", err)
+			}
+			rr := createRR(record, "example.com.", dns.StringToType[tt.recordType])
+			if rr == nil {
+				t.Fatal("createRR returned nil")
+			}
+			want, err := dns.NewRR("example.com. 3600 IN " + tt.recordType + " " + tt.zone)
+			if err != nil {
+				t.Fatalf("miekg/dns rejects %q: %v", tt.zone, err)
+			}
+			// Parameters are packed in key order, whatever order they were given in
+			wirePacked := func(rr dns.RR) string {
+				buf := make([]byte, dns.MaxMsgSize)
+				n, err := dns.PackRR(rr, buf, 0, nil, false)
+				if err != nil {
+					t.Fatalf("PackRR(%v) failed: %v", rr, err)
+				}
+				return string(buf[:n])
+			}
+			if wirePacked(rr) != wirePacked(want) {
+				t.Errorf("Parsed differently from miekg/dns:\nwant %v\ngot  %v", want, rr)
+			}
+
+			// Records from zone files and transfers convert back to the same data
+			converted, err := recordFromRR(want)
+			if err != nil {
+				t.Fatalf("recordFromRR failed: %v", err)
+			}
+			again := createRR(converted, "example.com.", dns.StringToType[tt.recordType])
+			if again == nil || wirePacked(again) != wirePacked(want) {
+				t.Errorf("Value %q from recordFromRR gives %v", converted.Value, again)
+			}
+		})
+	}
+}
+
+// TestParseSVCBErrors tests the validation of malformed SVCB and HTTPS values
+func TestParseSVCBErrors(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr string
+	}{
+		{"1", "HTTPS needs priority and target"},
+		{"70000 .", "invalid HTTPS priority '70000'"},
+		{"1 192.0.2.1", "must be a domain name, not an IP address"},
+		{"0 www.example.net alpn=h2", "priority 0 is AliasMode, which takes no parameters"},
+		{"1 . alpn=h2 alpn=h3", "parameter alpn given more than once"},
+		{"1 . dohpath=/q{?dns}", "unsupported HTTPS parameter 'dohpath'"},
+		{"1 . alpn", "parameter alpn needs a value"},
+		{"1 . alpn=h2,,h3", "invalid HTTPS alpn 'h2,,h3'"},
+		{"1 . no-default-alpn=yes", "no-default-alpn takes no value"},
+		{"1 . port=65536", "invalid HTTPS port '65536'"},
+		{"1 . ipv4hint=2001:db8::1", "invalid HTTPS ipv4hint address '2001:db8::1'"},
+		{"1 . ipv6hint=192.0.2.1", "invalid HTTPS ipv6hint address '192.0.2.1'"},
+		{"1 . ech=not*base64", "invalid HTTPS ech 'not*base64', must be base64"},
+		{"1 . mandatory=port", "mandatory key port is missing"},
+		{"1 . mandatory=mandatory", "invalid HTTPS mandatory key 'mandatory'"},
+		{`1 . alpn="h2`, "unterminated quoted string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			err := validateRecordValue(DNSRecord{Name: "example.com", Type: "HTTPS", Value: tt.value})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestSVCBRecords tests SVCB and HTTPS records from CSV and multi-record JSON
+func TestSVCBRecords(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	t.Run("CSV", func(t *testing.T) {
+		tmpFile, err := createTempCSV("name,type,value,ttl\n" +
+			`example.com,HTTPS,"1 . alpn=h3,h2 ipv4hint=192.0.2.1",3600` + "\n" +
+			`_dns.example.com,SVCB,"1 dns.example.com alpn=dot port=853",3600` + "\n" +
+			"bad.example.com,HTTPS,1 . port=https,3600\n")
+		if err != nil {
+			t.Fatalf("Failed to create temp CSV: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+
+		load := func() (*RecordStore, error) { return loadRecordsFromCSV(tmpFile.Name()) }
+		_, err = buildRecordStore(load)
+		if err == nil || !strings.Contains(err.Error(), "line 4: bad.example.com HTTPS: invalid HTTPS port 'https'") {
+			t.Fatalf("Expected the malformed HTTPS record to be reported, got %v", err)
+		}
+
+		store, err := load()
+		if err != nil {
+			t.Fatalf("Failed to load CSV: %v", err)
+		}
+		rrs := store.lookupRecord("example.com.", dns.TypeHTTPS)
+		if len(rrs) != 1 || rrs[0].String() != "example.com.\t3600\tIN\tHTTPS\t1 . alpn=\"h3,h2\" ipv4hint=\"192.0.2.1\"" {
+			t.Errorf("Unexpected HTTPS records: %v", rrs)
+		}
+		rrs = store.lookupRecord("_dns.example.com.", dns.TypeSVCB)
+		if len(rrs) != 1 || rrs[0].String() != "_dns.example.com.\t3600\tIN\tSVCB\t1 dns.example.com. alpn=\"dot\" port=\"853\"" {
+			t.Errorf("Unexpected SVCB records: %v", rrs)
+		}
+		if rrs := store.lookupRecord("example.com.", dns.TypeSVCB); len(rrs) != 0 {
+			t.Errorf("Expected no SVCB answer for an HTTPS record, got %v", rrs)
+		}
+	})
+
+	t.Run("Multi-record JSON", func(t *testing.T) {
+		multiRecord := MultiRecord{"HTTPS": "1 . alpn=h2 ipv6hint=2001:db8::1", "A": "192.0.2.1"}
+		rr := createRRFromMultiRecord(multiRecord, "test.2dns.dev", dns.TypeHTTPS)
+		https, ok := rr.(*dns.HTTPS)
+		if !ok {
+			t.Fatalf("Expected an HTTPS record, got %v", rr)
+		}
+		if got := strings.TrimPrefix(https.String(), https.Hdr.String()); got != `1 . alpn="h2" ipv6hint="2001:db8::1"` {
+			t.Errorf("Unexpected HTTPS record: %s", got)
+		}
+
+		if rr := createRRFromMultiRecord(MultiRecord{"HTTPS": "1 . port=x"}, "test.2dns.dev", dns.TypeHTTPS); rr != nil {
+			t.Errorf("Expected no record for a malformed HTTPS value, got %v", rr)
+		}
+	})
+}
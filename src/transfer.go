@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// transferMessageSize is roughly how many bytes of records go into one
+	// zone transfer message, well below the 64KB limit of DNS over TCP
+	transferMessageSize = 16 * 1024
+	// maxJournalDeltas is how many reloads of a zone are kept for IXFR
+	maxJournalDeltas = 100
+)
+
+// tsigKeysFlag collects repeated -tsig-key flags of the form "name:secret"
+// into the key name to base64 secret map the DNS server verifies TSIG with
+type tsigKeysFlag map[string]string
+
+func (f *tsigKeysFlag) String() string {
+	var names []string
+	for name := range *f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (f *tsigKeysFlag) Set(value string) error {
+	name, secret, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || secret == "" {
+		return fmt.Errorf("TSIG key %q: expected name:base64-secret", value)
+	}
+	if _, err := base64.StdEncoding.DecodeString(secret); err != nil {
+		return fmt.Errorf("TSIG key %q: secret is not base64: %v", name, err)
+	}
+	if *f == nil {
+		*f = make(tsigKeysFlag)
+	}
+	(*f)[dns.CanonicalName(name)] = secret
+	return nil
+}
+
+// parseTransferACL parses a comma-separated list of IP addresses and CIDR
+// prefixes that may request zone transfers
+func parseTransferACL(list string) ([]*net.IPNet, error) {
+	var acl []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address '%s'", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			acl = append(acl, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, prefix, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix '%s'", entry)
+		}
+		acl = append(acl, prefix)
+	}
+	return acl, nil
+}
+
+// transfersEnabled reports whether anyone may transfer zones. Without an ACL
+// or TSIG keys for transfers every transfer is refused.
+func transfersEnabled() bool {
+	return len(config.TransferACL) > 0 || len(transferKeys()) > 0
+}
+
+// transferKeys returns the TSIG keys zone transfers may be signed with: every
+// -tsig-key except the -secondary-key, which only signs our requests to
+// primaries
+func transferKeys() map[string]string {
+	keys := make(map[string]string, len(config.TSIGSecrets))
+	for name, secret := range config.TSIGSecrets {
+		if name != config.SecondaryKey {
+			keys[name] = secret
+		}
+	}
+	return keys
+}
+
+// transferRcode decides whether the client may transfer zones. When TSIG keys
+// for transfers are configured the request must be signed with one of them,
+// and when an ACL is configured the client address must be in it; both apply
+// if both are set. It returns RcodeSuccess if the transfer may go ahead.
+func transferRcode(w dns.ResponseWriter, r *dns.Msg) int {
+	if !transfersEnabled() {
+		return dns.RcodeRefused
+	}
+
+	// A signature that does not verify is reported as NOTAUTH (RFC 8945 section 5.2)
+	if r.IsTsig() != nil && w.TsigStatus() != nil {
+		return dns.RcodeNotAuth
+	}
+	if keys := transferKeys(); len(keys) > 0 {
+		tsig := r.IsTsig()
+		if tsig == nil {
+			return dns.RcodeRefused
+		}
+		if _, ok := keys[dns.CanonicalName(tsig.Hdr.Name)]; !ok {
+			return dns.RcodeRefused
+		}
+	}
+
+	if len(config.TransferACL) > 0 {
+		var ip net.IP
+		switch addr := w.RemoteAddr().(type) {
+		case *net.TCPAddr:
+			ip = addr.IP
+		case *net.UDPAddr:
+			ip = addr.IP
+		}
+		for _, prefix := range config.TransferACL {
+			if ip != nil && prefix.Contains(ip) {
+				return dns.RcodeSuccess
+			}
+		}
+		return dns.RcodeRefused
+	}
+	return dns.RcodeSuccess
+}
+
+// handleTransfer answers an AXFR (RFC 5936) or IXFR (RFC 1995) request for a
+// zone in store
+func handleTransfer(w dns.ResponseWriter, r *dns.Msg, store RecordBackend) {
+	q := r.Question[0]
+	zone := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+	_, udp := w.RemoteAddr().(*net.UDPAddr)
+
+	reply := func(rcode int, answer ...dns.RR) {
+		msg := new(dns.Msg)
+		msg.SetRcode(r, rcode)
+		msg.Authoritative = rcode == dns.RcodeSuccess
+		msg.Answer = answer
+		if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+			msg.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+		}
+		w.WriteMsg(msg)
+	}
+
+	if rcode := transferRcode(w, r); rcode != dns.RcodeSuccess {
+		log.Printf("Refused %s of %s from %s", dns.TypeToString[q.Qtype], q.Name, w.RemoteAddr())
+		reply(rcode)
+		return
+	}
+
+	var soa *dns.SOA
+	if store != nil {
+		soa = store.findSOA(zone)
+	}
+	if soa == nil || strings.ToLower(strings.TrimSuffix(soa.Hdr.Name, ".")) != zone {
+		// Not the apex of a zone we serve
+		reply(dns.RcodeNotAuth)
+		return
+	}
+
+	if q.Qtype == dns.TypeIXFR {
+		var clientSOA *dns.SOA
+		for _, rr := range r.Ns {
+			if s, ok := rr.(*dns.SOA); ok {
+				clientSOA = s
+			}
+		}
+		// A client that is up to date, or any client over UDP, only gets the
+		// current SOA; the latter then retries over TCP (RFC 1995 section 2)
+		if (clientSOA != nil && !serialGreater(soa.Serial, clientSOA.Serial)) || udp {
+			reply(dns.RcodeSuccess, soa)
+			return
+		}
+		if clientSOA != nil {
+			if deltas, ok := zoneJournal.since(zone, clientSOA.Serial, soa.Serial); ok {
+				if config.VerboseLogging {
+					log.Printf("IXFR of %s from serial %d to %d for %s", q.Name, clientSOA.Serial, soa.Serial, w.RemoteAddr())
+				}
+				sendTransfer(w, r, incrementalTransfer(soa, deltas))
+				return
+			}
+		}
+		// Without a journal entry for the client's serial the whole zone is
+		// sent in AXFR format (RFC 1995 section 4)
+	} else if udp {
+		// AXFR is only defined over TCP (RFC 5936 section 4.2)
+		reply(dns.RcodeRefused)
+		return
+	}
+
+	contents := zoneContents(store)[zone]
+	log.Printf("%s of %s (serial %d, %d records) for %s", dns.TypeToString[q.Qtype], q.Name, soa.Serial, len(contents.records)+1, w.RemoteAddr())
+	rrs := append([]dns.RR{contents.soa}, contents.records...)
+	sendTransfer(w, r, append(rrs, contents.soa))
+}
+
+// sendTransfer streams rrs to the client, split over as many messages as needed
+func sendTransfer(w dns.ResponseWriter, r *dns.Msg, rrs []dns.RR) {
+	var envelopes []*dns.Envelope
+	size := 0
+	for _, rr := range rrs {
+		if len(envelopes) == 0 || size+dns.Len(rr) > transferMessageSize {
+			envelopes = append(envelopes, &dns.Envelope{})
+			size = 0
+		}
+		last := envelopes[len(envelopes)-1]
+		last.RR = append(last.RR, rr)
+		size += dns.Len(rr)
+	}
+
+	// Buffered so that a client going away does not leave us blocked
+	ch := make(chan *dns.Envelope, len(envelopes))
+	for _, envelope := range envelopes {
+		ch <- envelope
+	}
+	close(ch)
+
+	if err := new(dns.Transfer).Out(w, r, ch); err != nil {
+		log.Printf("Zone transfer to %s failed: %v", w.RemoteAddr(), err)
+	}
+	w.Close()
+}
+
+// incrementalTransfer lays out an IXFR response: the current SOA, then for
+// each delta the old SOA, the deleted records, the new SOA and the added
+// records, and the current SOA again (RFC 1995 section 4)
+func incrementalTransfer(current *dns.SOA, deltas []zoneDelta) []dns.RR {
+	rrs := []dns.RR{current}
+	for _, delta := range deltas {
+		rrs = append(rrs, delta.from)
+		rrs = append(rrs, delta.deleted...)
+		rrs = append(rrs, delta.to)
+		rrs = append(rrs, delta.added...)
+	}
+	return append(rrs, current)
+}
+
+// transferZone is the content of a zone as sent in a transfer: its SOA and
+// every other record whose closest enclosing SOA is the zone's. That includes
+// delegation NS records and their glue, but no records of child zones.
+type transferZone struct {
+	soa     *dns.SOA
+	records []dns.RR
+}
+
+// zoneContents splits the records of store into zones, keyed by apex name.
+// ALIAS and ANAME records are resolved at query time and left out.
+func zoneContents(store RecordBackend) map[string]*transferZone {
+	zones := make(map[string]*transferZone)
+	if store == nil {
+		return zones
+	}
+	zoneOf := func(soa *dns.SOA) *transferZone {
+		apex := strings.ToLower(strings.TrimSuffix(soa.Hdr.Name, "."))
+		if zones[apex] == nil {
+			zones[apex] = &transferZone{soa: soa}
+		}
+		return zones[apex]
+	}
+
+	store.enumerate(func(record DNSRecord) bool {
+		if record.Type == "ALIAS" || record.Type == "ANAME" {
+			return true
+		}
+		soa := store.findSOA(record.Name)
+		if soa == nil {
+			return true
+		}
+		zone := zoneOf(soa)
+		if record.Type == "SOA" {
+			return true
+		}
+		rr := createRR(record, dns.Fqdn(record.Name), dns.StringToType[record.Type])
+		if rr == nil {
+			return true
+		}
+
+		// The NS records at the apex of a child zone served here are also
+		// the parent's delegation, and the DS records only belong to the
+		// parent (RFC 4035 section 2.4)
+		var parentZone *transferZone
+		if dns.CanonicalName(record.Name) == dns.CanonicalName(soa.Hdr.Name) {
+			if _, parent, ok := strings.Cut(strings.TrimSuffix(soa.Hdr.Name, "."), "."); ok {
+				if parentSOA := store.findSOA(parent); parentSOA != nil {
+					parentZone = zoneOf(parentSOA)
+				}
+			}
+		}
+		switch {
+		case record.Type == "DS" && parentZone != nil:
+			parentZone.records = append(parentZone.records, rr)
+		case record.Type == "NS" && parentZone != nil:
+			parentZone.records = append(parentZone.records, rr)
+			zone.records = append(zone.records, rr)
+		default:
+			zone.records = append(zone.records, rr)
+		}
+		return true
+	})
+
+	for _, zone := range zones {
+		records := zone.records
+		sort.Slice(records, func(i, j int) bool {
+			a, b := records[i].Header(), records[j].Header()
+			if a.Name != b.Name {
+				return canonicalNameLess(strings.TrimSuffix(a.Name, "."), strings.TrimSuffix(b.Name, "."))
+			}
+			if a.Rrtype != b.Rrtype {
+				return a.Rrtype < b.Rrtype
+			}
+			return records[i].String() < records[j].String()
+		})
+	}
+	return zones
+}
+
+// serialGreater reports whether SOA serial a is newer than b in serial number
+// arithmetic (RFC 1982)
+func serialGreater(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+// zoneDelta is the difference between two versions of a zone
+type zoneDelta struct {
+	from, to *dns.SOA
+	deleted  []dns.RR
+	added    []dns.RR
+}
+
+// transferJournal keeps the changes between reloads of each zone, oldest
+// first, so IXFR clients can be sent just what changed since their serial
+type transferJournal struct {
+	mu     sync.Mutex
+	deltas map[string][]zoneDelta
+}
+
+// zoneJournal is the journal of the served record store
+var zoneJournal = newTransferJournal()
+
+func newTransferJournal() *transferJournal {
+	return &transferJournal{deltas: make(map[string][]zoneDelta)}
+}
+
+// record adds the changes from oldStore to newStore to the journal. A zone is
+// only journaled when its SOA serial increased; if the data changed without
+// a new serial, secondaries cannot notice and a warning is logged.
+func (journal *transferJournal) record(oldStore, newStore RecordBackend) {
+	oldZones, newZones := zoneContents(oldStore), zoneContents(newStore)
+
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	for apex := range journal.deltas {
+		if newZones[apex] == nil {
+			delete(journal.deltas, apex)
+		}
+	}
+
+	for apex, newZone := range newZones {
+		oldZone := oldZones[apex]
+		if oldZone == nil {
+			delete(journal.deltas, apex)
+			continue
+		}
+
+		deleted := rrDifference(oldZone.records, newZone.records)
+		added := rrDifference(newZone.records, oldZone.records)
+
+		switch {
+		case oldZone.soa.Serial == newZone.soa.Serial:
+			if len(deleted) > 0 || len(added) > 0 || oldZone.soa.String() != newZone.soa.String() {
+				log.Printf("Warning: %s changed but its SOA serial is still %d; secondaries will not pick up the change", apex, newZone.soa.Serial)
+			}
+		case !serialGreater(newZone.soa.Serial, oldZone.soa.Serial):
+			log.Printf("Warning: SOA serial of %s went back from %d to %d; IXFR clients get the whole zone", apex, oldZone.soa.Serial, newZone.soa.Serial)
+			delete(journal.deltas, apex)
+		default:
+			deltas := append(journal.deltas[apex], zoneDelta{from: oldZone.soa, to: newZone.soa, deleted: deleted, added: added})
+			if len(deltas) > maxJournalDeltas {
+				deltas = deltas[len(deltas)-maxJournalDeltas:]
+			}
+			journal.deltas[apex] = deltas
+		}
+	}
+}
+
+// since returns the deltas that take zone from serial to current, or false if
+// the journal does not reach back to serial
+func (journal *transferJournal) since(zone string, serial, current uint32) ([]zoneDelta, bool) {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	deltas := journal.deltas[zone]
+	for i, delta := range deltas {
+		if delta.from.Serial != serial {
+			continue
+		}
+		chain := deltas[i:]
+		for j := 1; j < len(chain); j++ {
+			if chain[j].from.Serial != chain[j-1].to.Serial {
+				return nil, false
+			}
+		}
+		if chain[len(chain)-1].to.Serial != current {
+			return nil, false
+		}
+		return append([]zoneDelta(nil), chain...), true
+	}
+	return nil, false
+}
+
+// rrDifference returns the records of a that are not in b
+func rrDifference(a, b []dns.RR) []dns.RR {
+	inB := make(map[string]bool, len(b))
+	for _, rr := range b {
+		inB[rr.String()] = true
+	}
+	var diff []dns.RR
+	for _, rr := range a {
+		if !inB[rr.String()] {
+			diff = append(diff, rr)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// startTransferServer serves handleDNSRequest over TCP on a random local port
+func startTransferServer(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %v", err)
+	}
+	server := &dns.Server{Listener: l, Handler: dns.HandlerFunc(handleDNSRequest), TsigSecret: config.TSIGSecrets}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return l.Addr().String()
+}
+
+// transferZoneIn runs a transfer of zone against addr and returns the records
+// of each message received
+func transferZoneIn(t *testing.T, addr string, msg *dns.Msg, transfer *dns.Transfer) ([][]dns.RR, error) {
+	t.Helper()
+	ch, err := transfer.In(msg, addr)
+	if err != nil {
+		return nil, err
+	}
+	var messages [][]dns.RR
+	for envelope := range ch {
+		if envelope.Error != nil {
+			return messages, envelope.Error
+		}
+		messages = append(messages, envelope.RR)
+	}
+	return messages, nil
+}
+
+// transferTestCSV has a parent zone with a delegation and glue, a child zone
+// served from the same store and enough hosts to need several messages
+func transferTestCSV(serial int, extra string) string {
+	var b strings.Builder
+	b.WriteString("name,type,value,ttl\n")
+	fmt.Fprintf(&b, "example.com,SOA,ns1.example.com. admin.example.com. %d 3600 600 86400 300,3600\n", serial)
+	b.WriteString("example.com,NS,ns1.example.com,3600\n")
+	b.WriteString("ns1.example.com,A,192.0.2.53,3600\n")
+	b.WriteString("sub.example.com,NS,ns.sub.example.com,3600\n")
+	b.WriteString("ns.sub.example.com,A,192.0.2.54,3600\n")
+	b.WriteString("child.example.com,SOA,ns1.example.com. admin.example.com. 1 3600 600 86400 300,3600\n")
+	b.WriteString("child.example.com,NS,ns1.example.com,3600\n")
+	b.WriteString("child.example.com,DS,60485 8 2 D4B7D520E7BB5F0F67674A0CCEB1E3E0614B93C4F9E99B8383F6A1E4469DA50A,3600\n")
+	b.WriteString("www.child.example.com,A,192.0.2.80,3600\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&b, "host%d.example.com,A,10.0.%d.%d,3600\n", i, i/256, i%256)
+	}
+	b.WriteString(extra)
+	return b.String()
+}
+
+// TestZoneTransfer tests AXFR framing, the transfer ACL and TSIG
+func TestZoneTransfer(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	tmpFile, err := createTempCSV(transferTestCSV(1, ""))
+	if err != nil {
+		t.Fatalf("Failed to create temp CSV: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	store, err := buildRecordStore(func() (*RecordStore, error) { return loadRecordsFromCSV(tmpFile.Name()) })
+	if err != nil {
+		t.Fatalf("Failed to load CSV: %v", err)
+	}
+	swapRecordStore(store)
+
+	const secret = "c2VjcmV0LWtleS1mb3ItdGVzdHM="
+	config.TSIGSecrets = map[string]string{"xfr.example.": secret}
+	addr := startTransferServer(t)
+	axfr := new(dns.Msg)
+	axfr.SetAxfr("example.com.")
+
+	t.Run("Refused without ACL or keys", func(t *testing.T) {
+		config.TransferACL, config.TSIGSecrets = nil, nil
+		if _, err := transferZoneIn(t, addr, axfr, new(dns.Transfer)); err == nil {
+			t.Error("Expected the transfer to be refused")
+		}
+	})
+
+	t.Run("ACL", func(t *testing.T) {
+		config.TransferACL, _ = parseTransferACL("192.0.2.0/24, 2001:db8::1")
+		if _, err := transferZoneIn(t, addr, axfr, new(dns.Transfer)); err == nil {
+			t.Error("Expected a client outside the ACL to be refused")
+		}
+
+		config.TransferACL, _ = parseTransferACL("127.0.0.1")
+		messages, err := transferZoneIn(t, addr, axfr, new(dns.Transfer))
+		if err != nil {
+			t.Fatalf("AXFR failed: %v", err)
+		}
+		if len(messages) < 2 {
+			t.Errorf("Expected the zone to span several messages, got %d", len(messages))
+		}
+
+		var rrs []dns.RR
+		for _, message := range messages {
+			rrs = append(rrs, message...)
+		}
+		if _, ok := rrs[0].(*dns.SOA); !ok {
+			t.Fatalf("Expected the transfer to start with the SOA, got %v", rrs[0])
+		}
+		if _, ok := rrs[len(rrs)-1].(*dns.SOA); !ok {
+			t.Fatalf("Expected the transfer to end with the SOA, got %v", rrs[len(rrs)-1])
+		}
+		// SOA twice, NS, ns1, the sub delegation with glue, the child
+		// delegation with its DS and the hosts; nothing inside the child zone
+		if want := 2 + 1 + 1 + 2 + 2 + 1000; len(rrs) != want {
+			t.Errorf("Expected %d records, got %d", want, len(rrs))
+		}
+		for _, rr := range rrs {
+			if rr.Header().Name == "www.child.example.com." {
+				t.Errorf("Child zone record in the parent transfer: %v", rr)
+			}
+		}
+
+		child := new(dns.Msg)
+		child.SetAxfr("child.example.com.")
+		messages, err = transferZoneIn(t, addr, child, new(dns.Transfer))
+		if err != nil || len(messages) != 1 || len(messages[0]) != 4 {
+			t.Errorf("Expected SOA, NS, A and SOA but no DS for the child zone, got %v (%v)", messages, err)
+		}
+
+		notZone := new(dns.Msg)
+		notZone.SetAxfr("www.example.com.")
+		if _, err := transferZoneIn(t, addr, notZone, new(dns.Transfer)); err == nil {
+			t.Error("Expected a transfer of a name that is no zone apex to fail")
+		}
+	})
+
+	t.Run("TSIG", func(t *testing.T) {
+		config.TransferACL = nil
+		config.TSIGSecrets = map[string]string{"xfr.example.": secret}
+		if _, err := transferZoneIn(t, addr, axfr, new(dns.Transfer)); err == nil {
+			t.Error("Expected an unsigned request to be refused")
+		}
+
+		signed := axfr.Copy()
+		signed.SetTsig("xfr.example.", dns.HmacSHA256, 300, 0)
+		transfer := &dns.Transfer{TsigSecret: map[string]string{"xfr.example.": secret}}
+		messages, err := transferZoneIn(t, addr, signed, transfer)
+		if err != nil {
+			t.Fatalf("Signed AXFR failed: %v", err)
+		}
+		if len(messages) < 2 {
+			t.Errorf("Expected several signed messages, got %d", len(messages))
+		}
+
+		wrong := &dns.Transfer{TsigSecret: map[string]string{"xfr.example.": "d3Jvbmc="}}
+		if _, err := transferZoneIn(t, addr, signed, wrong); err == nil {
+			t.Error("Expected a request with the wrong secret to fail")
+		}
+	})
+
+	t.Run("Secondary key does not allow transfers", func(t *testing.T) {
+		config.TransferACL = nil
+		config.TSIGSecrets = map[string]string{"primary.example.": secret}
+		config.SecondaryKey = "primary.example."
+		defer func() { config.SecondaryKey = "" }()
+		if transfersEnabled() {
+			t.Error("Expected a -secondary-key alone not to enable transfers")
+		}
+
+		config.TSIGSecrets = map[string]string{"xfr.example.": secret, "primary.example.": secret}
+		addr := startTransferServer(t)
+		keys := map[string]string{"xfr.example.": secret, "primary.example.": secret}
+
+		signed := axfr.Copy()
+		signed.SetTsig("primary.example.", dns.HmacSHA256, 300, 0)
+		if _, err := transferZoneIn(t, addr, signed, &dns.Transfer{TsigSecret: keys}); err == nil {
+			t.Error("Expected a request signed with the secondary key to be refused")
+		}
+
+		signed = axfr.Copy()
+		signed.SetTsig("xfr.example.", dns.HmacSHA256, 300, 0)
+		if _, err := transferZoneIn(t, addr, signed, &dns.Transfer{TsigSecret: keys}); err != nil {
+			t.Errorf("Expected a request signed with the transfer key to succeed, got %v", err)
+		}
+	})
+
+	t.Run("AXFR over UDP", func(t *testing.T) {
+		config.TransferACL, _ = parseTransferACL("127.0.0.0/8")
+		config.TSIGSecrets = nil
+		w := newMockResponseWriter()
+		handleDNSRequest(w, axfr)
+		if w.msg == nil || w.msg.Rcode != dns.RcodeRefused {
+			t.Errorf("Expected AXFR over UDP to be refused, got %v", w.msg)
+		}
+	})
+}
+
+// TestIncrementalZoneTransfer tests IXFR from the journal kept across reloads
+func TestIncrementalZoneTransfer(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	originalJournal := zoneJournal
+	defer func() { zoneJournal = originalJournal }()
+	zoneJournal = newTransferJournal()
+
+	config.TransferACL, _ = parseTransferACL("127.0.0.1")
+	addr := startTransferServer(t)
+
+	tmpFile, err := createTempCSV(transferTestCSV(1, "old.example.com,A,192.0.2.1,3600\n"))
+	if err != nil {
+		t.Fatalf("Failed to create temp CSV: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	load := func() (*RecordStore, error) { return loadRecordsFromCSV(tmpFile.Name()) }
+	if err := reloadRecords(load, tmpFile.Name()); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	versions := []struct {
+		serial int
+		extra  string
+	}{
+		{2, "new.example.com,A,192.0.2.2,3600\n"},
+		{3, "new.example.com,A,192.0.2.3,3600\n"},
+	}
+	for _, version := range versions {
+		os.WriteFile(tmpFile.Name(), []byte(transferTestCSV(version.serial, version.extra)), 0644)
+		if err := reloadRecords(load, tmpFile.Name()); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+	}
+
+	ixfr := func(serial uint32) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetIxfr("example.com.", serial, "ns1.example.com.", "admin.example.com.")
+		return msg
+	}
+	transferred := func(t *testing.T, serial uint32) []string {
+		t.Helper()
+		messages, err := transferZoneIn(t, addr, ixfr(serial), new(dns.Transfer))
+		if err != nil {
+			t.Fatalf("IXFR failed: %v", err)
+		}
+		var rrs []string
+		for _, message := range messages {
+			for _, rr := range message {
+				if soa, ok := rr.(*dns.SOA); ok {
+					rrs = append(rrs, fmt.Sprintf("SOA %d", soa.Serial))
+				} else {
+					rrs = append(rrs, strings.TrimPrefix(rr.String(), rr.Header().String()))
+				}
+			}
+		}
+		return rrs
+	}
+
+	t.Run("Deltas since serial 1", func(t *testing.T) {
+		want := []string{
+			"SOA 3",
+			"SOA 1", "192.0.2.1", "SOA 2", "192.0.2.2",
+			"SOA 2", "192.0.2.2", "SOA 3", "192.0.2.3",
+			"SOA 3",
+		}
+		if got := transferred(t, 1); strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("Unexpected IXFR:\nwant %v\ngot  %v", want, got)
+		}
+	})
+
+	t.Run("Up to date", func(t *testing.T) {
+		if got := transferred(t, 3); len(got) != 1 || got[0] != "SOA 3" {
+			t.Errorf("Expected only the current SOA, got %v", got)
+		}
+	})
+
+	t.Run("Unknown serial falls back to AXFR", func(t *testing.T) {
+		got := transferred(t, 0)
+		if len(got) < 1000 || got[0] != "SOA 3" || got[len(got)-1] != "SOA 3" {
+			t.Errorf("Expected the whole zone, got %d records", len(got))
+		}
+	})
+
+	t.Run("UDP gets the SOA", func(t *testing.T) {
+		w := newMockResponseWriter()
+		handleDNSRequest(w, ixfr(1))
+		if w.msg == nil || len(w.msg.Answer) != 1 || w.msg.Answer[0].(*dns.SOA).Serial != 3 {
+			t.Errorf("Expected the current SOA over UDP, got %v", w.msg)
+		}
+	})
+}
+
+// TestSerialGreater tests RFC 1982 serial number comparison
+func TestSerialGreater(t *testing.T) {
+	tests := []struct {
+		a, b uint32
+		want bool
+	}{
+		{2, 1, true},
+		{1, 2, false},
+		{1, 1, false},
+		{0, 0xffffffff, true},
+		{0xffffffff, 0, false},
+	}
+	for _, tt := range tests {
+		if got := serialGreater(tt.a, tt.b); got != tt.want {
+			t.Errorf("serialGreater(%d, %d) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// upstreamServer is a recursive resolver used to resolve ALIAS and ANAME targets
+type upstreamServer struct {
+	Net  string // "udp" or "tcp"
+	Addr string // host:port
+}
+
+func (s upstreamServer) String() string {
+	return s.Net + "://" + s.Addr
+}
+
+// defaultUpstreamTimeout is used when no per-attempt timeout is configured
+const defaultUpstreamTimeout = 2 * time.Second
+
+// defaultUpstreams are queried when no upstreams are configured
+var defaultUpstreams = []upstreamServer{{Net: "udp", Addr: "8.8.8.8:53"}}
+
+// parseUpstreams parses a comma-separated list of upstream servers. Each entry
+// is an address with an optional port (default 53) and an optional udp:// or
+// tcp:// prefix (default udp), e.g. "1.1.1.1,tcp://[2001:db8::53]:5353".
+func parseUpstreams(list string) ([]upstreamServer, error) {
+	var servers []upstreamServer
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		server := upstreamServer{Net: "udp", Addr: entry}
+		if i := strings.Index(entry, "://"); i >= 0 {
+			server.Net = strings.ToLower(entry[:i])
+			server.Addr = entry[i+3:]
+		}
+		if server.Net != "udp" && server.Net != "tcp" {
+			return nil, fmt.Errorf("upstream %s: unsupported protocol %q", entry, server.Net)
+		}
+
+		host, port, err := net.SplitHostPort(server.Addr)
+		if err != nil {
+			host, port = strings.Trim(server.Addr, "[]"), "53"
+		}
+		if host == "" || net.ParseIP(host) == nil && !isHostName(host) {
+			return nil, fmt.Errorf("upstream %s: invalid address", entry)
+		}
+		server.Addr = net.JoinHostPort(host, port)
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// isHostName reports whether host is a syntactically valid host name
+func isHostName(host string) bool {
+	_, ok := dns.IsDomainName(host)
+	return ok && !strings.Contains(host, ":")
+}
+
+// queryUpstream sends a query for name and qtype to the configured upstreams
+// and returns the first usable response
+func queryUpstream(name string, qtype uint16) (*dns.Msg, error) {
+	servers := config.Upstreams
+	if len(servers) == 0 {
+		servers = defaultUpstreams
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qtype)
+	return exchangeWithFailover(req, servers)
+}
+
+// exchangeWithFailover sends req to servers in order and returns the first
+// usable response, failing over to the next server on network errors,
+// timeouts, SERVFAIL and REFUSED, for one round plus config.UpstreamRetries more
+func exchangeWithFailover(req *dns.Msg, servers []upstreamServer) (*dns.Msg, error) {
+	timeout := config.UpstreamTimeout
+	if timeout <= 0 {
+		timeout = defaultUpstreamTimeout
+	}
+
+	lastErr := fmt.Errorf("no upstream servers")
+	for round := 0; round <= config.UpstreamRetries; round++ {
+		for _, server := range servers {
+			req.Id = dns.Id()
+			resp, err := exchangeUpstream(req, server, timeout)
+			if err != nil {
+				lastErr = fmt.Errorf("%s: %v", server, err)
+				continue
+			}
+			if resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused {
+				lastErr = fmt.Errorf("%s: %s", server, dns.RcodeToString[resp.Rcode])
+				continue
+			}
+			return resp, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// exchangeUpstream sends req to a single server. Truncated UDP responses are
+// retried over TCP.
+func exchangeUpstream(req *dns.Msg, server upstreamServer, timeout time.Duration) (*dns.Msg, error) {
+	c := &dns.Client{Net: server.Net, Timeout: timeout}
+	resp, _, err := c.Exchange(req, server.Addr)
+	if err == nil && resp.Truncated && server.Net == "udp" {
+		c.Net = "tcp"
+		resp, _, err = c.Exchange(req, server.Addr)
+	}
+	return resp, err
+}
+
+// resolveUpstream returns the complete qtype RRset of name as resolved by the
+// upstreams, and how long it may be cached. CNAMEs the upstream followed are
+// left out, so the records are owned by the end of the chain. For an empty
+// RRset the TTL is the negative caching TTL from the upstream's SOA, if any.
+func resolveUpstream(name string, qtype uint16) ([]dns.RR, uint32, error) {
+	resp, err := queryUpstream(name, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var rrs []dns.RR
+	var ttl uint32
+	for i, rr := range resp.Answer {
+		// The answer is only valid as long as every CNAME leading to the RRset
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+		if rr.Header().Rrtype == qtype {
+			rrs = append(rrs, rr)
+		}
+	}
+	if len(rrs) == 0 {
+		ttl = 0
+		for _, rr := range resp.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl = min(soa.Hdr.Ttl, soa.Minttl)
+			}
+		}
+	}
+	return rrs, ttl, nil
+}
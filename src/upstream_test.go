@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startStubUpstream serves handler on a local UDP and TCP port and returns the address
+func startStubUpstream(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+
+	// The TCP port may already be taken by another listener, so a new UDP
+	// port is tried a few times
+	var pc net.PacketConn
+	var l net.Listener
+	for attempt := 0; ; attempt++ {
+		var err error
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen on UDP: %v", err)
+		}
+		l, err = net.Listen("tcp", pc.LocalAddr().String())
+		if err == nil {
+			break
+		}
+		pc.Close()
+		if attempt == 9 {
+			t.Fatalf("Failed to listen on TCP: %v", err)
+		}
+	}
+
+	for _, server := range []*dns.Server{
+		{PacketConn: pc, Handler: handler},
+		{Listener: l, Handler: handler},
+	} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { server.Shutdown() })
+	}
+	return pc.LocalAddr().String()
+}
+
+// stubAnswer answers A and AAAA queries for target.example.net with two addresses each
+func stubAnswer(w dns.ResponseWriter, r *dns.Msg) {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	q := r.Question[0]
+	if q.Name == "target.example.net." {
+		for _, value := range []string{"203.0.113.1", "203.0.113.2"} {
+			rr, _ := dns.NewRR("target.example.net. 120 IN A " + value)
+			if q.Qtype == dns.TypeA {
+				msg.Answer = append(msg.Answer, rr)
+			}
+		}
+		for _, value := range []string{"2001:db8::1", "2001:db8::2"} {
+			rr, _ := dns.NewRR("target.example.net. 120 IN AAAA " + value)
+			if q.Qtype == dns.TypeAAAA {
+				msg.Answer = append(msg.Answer, rr)
+			}
+		}
+	}
+	w.WriteMsg(msg)
+}
+
+// TestParseUpstreams tests parsing the -upstreams list
+func TestParseUpstreams(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []upstreamServer
+		wantErr bool
+	}{
+		{"8.8.8.8", []upstreamServer{{"udp", "8.8.8.8:53"}}, false},
+		{"1.1.1.1:5353, tcp://9.9.9.9", []upstreamServer{{"udp", "1.1.1.1:5353"}, {"tcp", "9.9.9.9:53"}}, false},
+		{"udp://[2001:db8::53]:5353,2001:db8::1", []upstreamServer{{"udp", "[2001:db8::53]:5353"}, {"udp", "[2001:db8::1]:53"}}, false},
+		{"resolver.example.net", []upstreamServer{{"udp", "resolver.example.net:53"}}, false},
+		{"", nil, false},
+		{"https://1.1.1.1", nil, true},
+		{"tcp://", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			got, err := parseUpstreams(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestUpstreamResolution tests resolving ALIAS and ANAME targets with a local stub upstream
+func TestUpstreamResolution(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	good := startStubUpstream(t, stubAnswer)
+	failing := startStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(msg)
+	})
+	truncating := startStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			msg := new(dns.Msg)
+			msg.SetReply(r)
+			msg.Truncated = true
+			w.WriteMsg(msg)
+			return
+		}
+		stubAnswer(w, r)
+	})
+
+	store := newRecordStore()
+	store.addRecord(DNSRecord{Name: "example.com", Type: "ALIAS", Value: "target.example.net", TTL: 300})
+	store.addRecord(DNSRecord{Name: "aname.example.com", Type: "ANAME", Value: "target.example.net", TTL: 300})
+	recordStore = store
+
+	tests := []struct {
+		name      string
+		upstreams []upstreamServer
+		qname     string
+		qtype     uint16
+		want      int
+	}{
+		{"ALIAS returns the whole A RRset", []upstreamServer{{"udp", good}}, "example.com.", dns.TypeA, 2},
+		{"ALIAS returns the whole AAAA RRset", []upstreamServer{{"udp", good}}, "example.com.", dns.TypeAAAA, 2},
+		{"ANAME returns the whole A RRset", []upstreamServer{{"udp", good}}, "aname.example.com.", dns.TypeA, 2},
+		{"ANAME returns the whole AAAA RRset", []upstreamServer{{"udp", good}}, "aname.example.com.", dns.TypeAAAA, 2},
+		{"TCP upstream", []upstreamServer{{"tcp", good}}, "example.com.", dns.TypeA, 2},
+		{"Failover after SERVFAIL", []upstreamServer{{"udp", failing}, {"udp", good}}, "example.com.", dns.TypeA, 2},
+		{"Truncated UDP retried over TCP", []upstreamServer{{"udp", truncating}}, "example.com.", dns.TypeA, 2},
+		{"All upstreams failing", []upstreamServer{{"udp", failing}}, "example.com.", dns.TypeA, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Upstreams = tt.upstreams
+			config.UpstreamTimeout = time.Second
+			config.UpstreamRetries = 1
+
+			records := store.lookupRecord(tt.qname, tt.qtype)
+			if len(records) != tt.want {
+				t.Fatalf("Expected %d records, got %d: %v", tt.want, len(records), records)
+			}
+			for _, rr := range records {
+				if rr.Header().Name != tt.qname || rr.Header().Rrtype != tt.qtype {
+					t.Errorf("Expected %s %s record, got %s", tt.qname, dns.TypeToString[tt.qtype], rr)
+				}
+				// The record TTL of 300 is clamped to the upstream TTL
+				if rr.Header().Ttl != 120 {
+					t.Errorf("Expected TTL 120, got %d", rr.Header().Ttl)
+				}
+			}
+		})
+	}
+
+	t.Run("Timeout", func(t *testing.T) {
+		// A UDP socket that never answers
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen on UDP: %v", err)
+		}
+		defer pc.Close()
+
+		config.Upstreams = []upstreamServer{{"udp", pc.LocalAddr().String()}, {"udp", good}}
+		config.UpstreamTimeout = 100 * time.Millisecond
+		config.UpstreamRetries = 0
+
+		start := time.Now()
+		rrs, _, err := resolveUpstream("target.example.net", dns.TypeA)
+		if err != nil || len(rrs) != 2 {
+			t.Fatalf("Expected failover to the second upstream, got %v, %v", rrs, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected the silent upstream to time out quickly, took %v", elapsed)
+		}
+	})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// staleAnswerTTL is the TTL of answers served from expired cache entries (RFC 8767 section 4)
+	staleAnswerTTL = 30
+	// staleRetryInterval is how long an upstream failure is remembered before
+	// an expired entry is refreshed again (RFC 8767 section 5, failure recheck timer)
+	staleRetryInterval = 30 * time.Second
+	// prefetchFraction is the share of the upstream TTL left at which a cached
+	// entry is refreshed in the background
+	prefetchFraction = 10
+)
+
+// upstreamCacheKey identifies an upstream RRset
+type upstreamCacheKey struct {
+	name  string
+	qtype uint16
+}
+
+// upstreamCacheEntry is a cached upstream RRset. An empty RRset records that
+// the upstream had no data of the type (negative caching).
+type upstreamCacheEntry struct {
+	rrs        []dns.RR
+	ttl        uint32    // Upstream TTL when the entry was stored
+	expires    time.Time // When the upstream TTL runs out
+	retryAfter time.Time // No refresh before this time after a failure
+	refreshing bool      // A background refresh is running
+}
+
+// upstreamCacheStats counts how upstream lookups were answered
+type upstreamCacheStats struct {
+	Hits       uint64 // Answered from a fresh entry
+	Misses     uint64 // Resolved upstream because no fresh entry existed
+	StaleHits  uint64 // Answered from an expired entry because the upstream failed
+	Prefetches uint64 // Background refreshes of entries about to expire
+	Entries    int    // Entries currently cached
+}
+
+// upstreamCache is a cache of upstream results shared by all ALIAS and ANAME
+// records, so each target is resolved once per upstream TTL no matter how
+// many records point at it or how often they are queried
+type upstreamCache struct {
+	mu      sync.Mutex
+	entries map[upstreamCacheKey]*upstreamCacheEntry
+
+	resolve func(name string, qtype uint16) ([]dns.RR, uint32, error)
+	now     func() time.Time
+
+	hits, misses, staleHits, prefetches atomic.Uint64
+}
+
+// aliasCache caches the upstream results of ALIAS and ANAME targets
+var aliasCache = newUpstreamCache(resolveUpstream)
+
+// newUpstreamCache creates an empty cache that resolves misses with resolve
+func newUpstreamCache(resolve func(name string, qtype uint16) ([]dns.RR, uint32, error)) *upstreamCache {
+	return &upstreamCache{
+		entries: make(map[upstreamCacheKey]*upstreamCacheEntry),
+		resolve: resolve,
+		now:     time.Now,
+	}
+}
+
+// lookupUpstream resolves name and qtype upstream, through the cache if it is
+// enabled. The returned TTL is how long the answer remains valid.
+func lookupUpstream(name string, qtype uint16) ([]dns.RR, uint32, error) {
+	if !config.UpstreamCache {
+		return resolveUpstream(name, qtype)
+	}
+	return aliasCache.lookup(name, qtype)
+}
+
+// lookup returns the cached RRset of name and qtype with its remaining TTL,
+// resolving it upstream if it is not cached or has expired. Entries close to
+// expiry are refreshed in the background. If the upstream fails, an expired
+// entry is served for up to config.ServeStale past its expiry.
+func (c *upstreamCache) lookup(name string, qtype uint16) ([]dns.RR, uint32, error) {
+	key := upstreamCacheKey{strings.ToLower(dns.Fqdn(name)), qtype}
+	now := c.now()
+
+	c.mu.Lock()
+	entry := c.entries[key]
+	if entry != nil && now.Before(entry.expires) {
+		remaining := uint32(entry.expires.Sub(now) / time.Second)
+		if !entry.refreshing && remaining*prefetchFraction <= entry.ttl && !now.Before(entry.retryAfter) {
+			entry.refreshing = true
+			c.prefetches.Add(1)
+			go c.refresh(key)
+		}
+		rrs := entry.rrs
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return rrs, remaining, nil
+	}
+	// The upstream failed recently, keep serving stale data without asking again
+	if entry != nil && now.Before(entry.retryAfter) && c.isServable(entry, now) {
+		rrs := entry.rrs
+		c.mu.Unlock()
+		c.staleHits.Add(1)
+		return rrs, staleAnswerTTL, nil
+	}
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	rrs, ttl, err := c.resolve(name, qtype)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		entry = c.entries[key]
+		if entry != nil && c.isServable(entry, now) {
+			entry.retryAfter = now.Add(staleRetryInterval)
+			c.staleHits.Add(1)
+			if config.VerboseLogging {
+				log.Printf("Serving stale %s %s: %v", name, dns.TypeToString[qtype], err)
+			}
+			return entry.rrs, staleAnswerTTL, nil
+		}
+		delete(c.entries, key)
+		return nil, 0, err
+	}
+	c.store(key, rrs, ttl, now)
+	return rrs, ttl, nil
+}
+
+// refresh resolves a cached entry again in the background
+func (c *upstreamCache) refresh(key upstreamCacheKey) {
+	rrs, ttl, err := c.resolve(key.name, key.qtype)
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[key]
+	if entry != nil {
+		entry.refreshing = false
+	}
+	if err != nil {
+		if entry != nil {
+			entry.retryAfter = now.Add(staleRetryInterval)
+		}
+		if config.VerboseLogging {
+			log.Printf("Prefetching %s %s failed: %v", key.name, dns.TypeToString[key.qtype], err)
+		}
+		return
+	}
+	c.store(key, rrs, ttl, now)
+}
+
+// store caches an upstream result. A result with a zero TTL can't be cached;
+// the previous entry is kept to be served stale if the upstream fails later.
+// Callers must hold c.mu.
+func (c *upstreamCache) store(key upstreamCacheKey, rrs []dns.RR, ttl uint32, now time.Time) {
+	if ttl == 0 {
+		return
+	}
+	c.entries[key] = &upstreamCacheEntry{
+		rrs:     rrs,
+		ttl:     ttl,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// isServable reports whether an expired entry may still be served stale.
+// Callers must hold c.mu.
+func (c *upstreamCache) isServable(entry *upstreamCacheEntry, now time.Time) bool {
+	return config.ServeStale > 0 && now.Before(entry.expires.Add(config.ServeStale))
+}
+
+// purge drops entries that can no longer be served, even stale
+func (c *upstreamCache) purge() {
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) && !c.isServable(entry, now) && !entry.refreshing {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// stats returns the cache counters
+func (c *upstreamCache) stats() upstreamCacheStats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	return upstreamCacheStats{
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+		StaleHits:  c.staleHits.Load(),
+		Prefetches: c.prefetches.Load(),
+		Entries:    entries,
+	}
+}
+
+// startUpstreamCacheMaintenance periodically purges dead entries from the
+// cache and logs its counters
+func startUpstreamCacheMaintenance(c *upstreamCache, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			c.purge()
+			stats := c.stats()
+			if stats.Hits+stats.Misses == 0 {
+				continue
+			}
+			log.Printf("ALIAS/ANAME cache: %d hits, %d misses, %d stale, %d prefetches, %d entries",
+				stats.Hits, stats.Misses, stats.StaleHits, stats.Prefetches, stats.Entries)
+		}
+	}()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeUpstream is a resolver for upstreamCache tests that counts its calls
+type fakeUpstream struct {
+	mu    sync.Mutex
+	calls int
+	ttl   uint32
+	rrs   []dns.RR
+	err   error
+}
+
+func (f *fakeUpstream) resolve(name string, qtype uint16) ([]dns.RR, uint32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	return f.rrs, f.ttl, nil
+}
+
+func (f *fakeUpstream) set(ttl uint32, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ttl, f.err = ttl, err
+}
+
+func (f *fakeUpstream) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// TestUpstreamCache tests TTL handling, prefetching and serve-stale of the upstream cache
+func TestUpstreamCache(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config.ServeStale = time.Hour
+
+	rr, _ := dns.NewRR("target.example.net. 100 IN A 203.0.113.1")
+	upstream := &fakeUpstream{ttl: 100, rrs: []dns.RR{rr}}
+
+	now := time.Unix(1700000000, 0)
+	var clockMu sync.Mutex
+	cache := newUpstreamCache(upstream.resolve)
+	cache.now = func() time.Time {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		now = now.Add(d)
+	}
+
+	lookup := func(wantTTL uint32, wantErr bool) {
+		t.Helper()
+		rrs, ttl, err := cache.lookup("Target.example.net", dns.TypeA)
+		if (err != nil) != wantErr {
+			t.Fatalf("Expected error %v, got %v", wantErr, err)
+		}
+		if wantErr {
+			return
+		}
+		if len(rrs) != 1 {
+			t.Errorf("Expected 1 record, got %v", rrs)
+		}
+		if ttl != wantTTL {
+			t.Errorf("Expected TTL %d, got %d", wantTTL, ttl)
+		}
+	}
+
+	// First lookup misses, the second is answered from the cache with the remaining TTL
+	lookup(100, false)
+	advance(40 * time.Second)
+	lookup(60, false)
+	if calls := upstream.callCount(); calls != 1 {
+		t.Errorf("Expected 1 upstream query, got %d", calls)
+	}
+
+	// Close to expiry the entry is refreshed in the background
+	advance(55 * time.Second)
+	lookup(5, false)
+	waitFor(t, func() bool { return upstream.callCount() == 2 })
+	waitFor(t, func() bool {
+		_, ttl, _ := cache.lookup("target.example.net", dns.TypeA)
+		return ttl == 100
+	})
+
+	// After expiry with the upstream failing, the stale entry is served
+	upstream.set(100, errors.New("upstream down"))
+	advance(150 * time.Second)
+	lookup(staleAnswerTTL, false)
+	calls := upstream.callCount()
+	// and the upstream is not asked again until the retry interval has passed
+	lookup(staleAnswerTTL, false)
+	if upstream.callCount() != calls {
+		t.Errorf("Expected no upstream query within the retry interval")
+	}
+	advance(staleRetryInterval)
+	lookup(staleAnswerTTL, false)
+	if upstream.callCount() != calls+1 {
+		t.Errorf("Expected an upstream query after the retry interval")
+	}
+
+	// Past the serve-stale window the failure is returned
+	advance(config.ServeStale)
+	lookup(0, true)
+
+	// Once the upstream recovers, the entry is fresh again
+	upstream.set(100, nil)
+	lookup(100, false)
+
+	stats := cache.stats()
+	if stats.Hits == 0 || stats.Misses == 0 || stats.StaleHits != 3 || stats.Prefetches != 1 || stats.Entries != 1 {
+		t.Errorf("Unexpected counters: %+v", stats)
+	}
+
+	t.Run("Zero TTL is not cached", func(t *testing.T) {
+		upstream := &fakeUpstream{ttl: 0, rrs: []dns.RR{rr}}
+		cache := newUpstreamCache(upstream.resolve)
+		cache.lookup("target.example.net", dns.TypeA)
+		cache.lookup("target.example.net", dns.TypeA)
+		if upstream.callCount() != 2 {
+			t.Errorf("Expected 2 upstream queries, got %d", upstream.callCount())
+		}
+	})
+
+	t.Run("Zero TTL keeps the stale entry", func(t *testing.T) {
+		upstream := &fakeUpstream{ttl: 1, rrs: []dns.RR{rr}}
+		cache := newUpstreamCache(upstream.resolve)
+		cache.now = func() time.Time { return now }
+		cache.lookup("target.example.net", dns.TypeA)
+
+		upstream.set(0, nil)
+		now = now.Add(2 * time.Second)
+		if _, ttl, err := cache.lookup("target.example.net", dns.TypeA); err != nil || ttl != 0 {
+			t.Errorf("Expected the uncached answer with TTL 0, got TTL %d, %v", ttl, err)
+		}
+
+		upstream.set(0, errors.New("upstream down"))
+		if rrs, ttl, err := cache.lookup("target.example.net", dns.TypeA); err != nil || len(rrs) != 1 || ttl != staleAnswerTTL {
+			t.Errorf("Expected the stale entry, got %v with TTL %d, %v", rrs, ttl, err)
+		}
+	})
+
+	t.Run("Serve-stale disabled", func(t *testing.T) {
+		config.ServeStale = 0
+		upstream := &fakeUpstream{ttl: 1, rrs: []dns.RR{rr}}
+		cache := newUpstreamCache(upstream.resolve)
+		cache.now = func() time.Time { return now }
+		cache.lookup("target.example.net", dns.TypeA)
+
+		upstream.set(1, errors.New("upstream down"))
+		now = now.Add(2 * time.Second)
+		if _, _, err := cache.lookup("target.example.net", dns.TypeA); err == nil {
+			t.Errorf("Expected an error without serve-stale")
+		}
+	})
+}
+
+// waitFor polls cond until it is true or a second has passed
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
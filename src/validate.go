@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// validate checks every record in the store, so that bad data is rejected at
+// load time instead of silently disappearing from answers. All problems are
+// reported, not just the first.
+func (store *RecordStore) validate() error {
+	records := store.allRecords()
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].File != records[j].File {
+			return records[i].File < records[j].File
+		}
+		if records[i].Line != records[j].Line {
+			return records[i].Line < records[j].Line
+		}
+		return records[i].Name < records[j].Name
+	})
+
+	var errs []error
+	for _, record := range records {
+		if err := validateRecord(record); err != nil {
+			errs = append(errs, atLine(record, err))
+			continue
+		}
+		if record.Type == "ANAME" {
+			if err := store.checkANAMEChain(record); err != nil {
+				errs = append(errs, atLine(record, err))
+			}
+		}
+	}
+	errs = append(errs, checkConflicts(records)...)
+	return errors.Join(errs...)
+}
+
+// atLine prefixes err with the file and line of the record it is about, if known
+func atLine(record DNSRecord, err error) error {
+	if record.Line != 0 {
+		err = fmt.Errorf("line %d: %w", record.Line, err)
+	}
+	if record.File != "" {
+		err = fmt.Errorf("%s: %w", record.File, err)
+	}
+	return err
+}
+
+// validateRecord checks the owner name and value of a single record
+func validateRecord(record DNSRecord) error {
+	if !isValidOwnerName(record.Name) {
+		return fmt.Errorf("%s: invalid domain name", record.Name)
+	}
+	if err := validateRecordValue(record); err != nil {
+		return fmt.Errorf("%s %s: %v", record.Name, record.Type, err)
+	}
+	return nil
+}
+
+// isValidOwnerName reports whether name is a domain name, with "*" allowed
+// only as the whole leftmost label
+func isValidOwnerName(name string) bool {
+	if name == "" {
+		return false
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return false
+	}
+	return !strings.Contains(strings.TrimPrefix(name, "*."), "*")
+}
+
+// validateRecordValue checks that the value of a record is well formed for
+// its type. Types without a stricter check only need to produce a resource record.
+func validateRecordValue(record DNSRecord) error {
+	value := record.Value
+	switch record.Type {
+	case "A":
+		if ip := net.ParseIP(value); ip == nil || ip.To4() == nil || strings.Contains(value, ":") {
+			return fmt.Errorf("invalid IPv4 address '%s'", value)
+		}
+		return nil
+
+	case "AAAA":
+		if ip := net.ParseIP(value); ip == nil || !strings.Contains(value, ":") {
+			return fmt.Errorf("invalid IPv6 address '%s'", value)
+		}
+		return nil
+
+	case "CNAME", "NS", "PTR", "DNAME", "ALIAS", "ANAME":
+		return validateTarget(value, false)
+
+	case "MX", "SRV":
+		// "." is the null MX (RFC 7505) or a service that is not available (RFC 2782)
+		return validateTarget(value, true)
+
+	case "SOA":
+		// Format: primary_ns admin_email serial refresh retry expire minimum
+		parts := strings.Fields(value)
+		if len(parts) != 7 {
+			return fmt.Errorf("SOA needs 7 fields (mname rname serial refresh retry expire minimum), got %d", len(parts))
+		}
+		for _, name := range parts[:2] {
+			if _, ok := dns.IsDomainName(name); !ok {
+				return fmt.Errorf("invalid SOA name '%s'", name)
+			}
+		}
+		fields := []string{"serial", "refresh", "retry", "expire", "minimum"}
+		for i, field := range fields {
+			if _, err := strconv.ParseUint(parts[2+i], 10, 32); err != nil {
+				return fmt.Errorf("invalid SOA %s '%s'", field, parts[2+i])
+			}
+		}
+		return nil
+
+	case "TXT":
+		_, err := parseTXT(value)
+		return err
+
+	case "CAA":
+		// Format: flag tag value
+		_, _, _, err := parseCAA(value)
+		return err
+
+	case "NAPTR":
+		_, err := parseNAPTR(value)
+		return err
+
+	case "TLSA":
+		// Format: usage selector matchingtype certificateassociationdata
+		parts := strings.SplitN(value, " ", 4)
+		if len(parts) != 4 {
+			return fmt.Errorf("TLSA needs usage, selector, matching type and certificate data")
+		}
+		for i, field := range []string{"usage", "selector", "matching type"} {
+			if _, err := strconv.ParseUint(parts[i], 10, 8); err != nil {
+				return fmt.Errorf("invalid TLSA %s '%s'", field, parts[i])
+			}
+		}
+		// Matching types 1 and 2 are SHA-256 and SHA-512 digests
+		return validateHexDigest("TLSA certificate data", parts[3], map[string]int{"1": 32, "2": 64}[parts[2]])
+
+	case "SSHFP":
+		// Format: algorithm fptype fingerprint
+		parts := strings.SplitN(value, " ", 3)
+		if len(parts) != 3 {
+			return fmt.Errorf("SSHFP needs algorithm, fingerprint type and fingerprint")
+		}
+		for i, field := range []string{"algorithm", "fingerprint type"} {
+			if _, err := strconv.ParseUint(parts[i], 10, 8); err != nil {
+				return fmt.Errorf("invalid SSHFP %s '%s'", field, parts[i])
+			}
+		}
+		// Fingerprint types 1 and 2 are SHA-1 and SHA-256 digests
+		return validateHexDigest("SSHFP fingerprint", parts[2], map[string]int{"1": 20, "2": 32}[parts[1]])
+
+	case "LOC":
+		_, err := parseLOC(value)
+		return err
+
+	case "SVCB", "HTTPS":
+		_, err := parseSVCB(record.Type, value)
+		return err
+
+	case "DS", "CDS":
+		_, err := parseDS(record.Type, value)
+		return err
+
+	case "DNSKEY", "CDNSKEY":
+		_, err := parseDNSKEY(record.Type, value)
+		return err
+
+	case "SMIMEA":
+		_, err := parseSMIMEA(value)
+		return err
+
+	case "OPENPGPKEY":
+		_, err := parseOPENPGPKEY(value)
+		return err
+
+	case "URI":
+		_, err := parseURI(value)
+		return err
+
+	case "CERT":
+		_, err := parseCERT(value)
+		return err
+	}
+
+	qtype, ok := dns.StringToType[record.Type]
+	if !ok {
+		return fmt.Errorf("unsupported record type")
+	}
+	if createRR(record, record.Name, qtype) == nil {
+		return fmt.Errorf("invalid value '%s'", value)
+	}
+	return nil
+}
+
+// validateTarget checks a domain name in a record value. IP addresses are
+// technically domain names too, but here they are always a mistake.
+func validateTarget(target string, allowRoot bool) error {
+	if target == "." && allowRoot {
+		return nil
+	}
+	if _, ok := dns.IsDomainName(target); !ok || target == "" || target == "." {
+		return fmt.Errorf("invalid target name '%s'", target)
+	}
+	if net.ParseIP(strings.TrimSuffix(target, ".")) != nil {
+		return fmt.Errorf("target '%s' must be a domain name, not an IP address", target)
+	}
+	return nil
+}
+
+// validateHexDigest checks that data is hex encoded and, when size is not 0,
+// decodes to size bytes
+func validateHexDigest(what, data string, size int) error {
+	decoded, err := hex.DecodeString(data)
+	if err != nil || len(decoded) == 0 {
+		return fmt.Errorf("invalid %s '%s', must be hex", what, data)
+	}
+	if size != 0 && len(decoded) != size {
+		return fmt.Errorf("%s is %d bytes, the digest type needs %d", what, len(decoded), size)
+	}
+	return nil
+}
+
+// isAlphanumeric reports whether s is a non-empty string of ASCII letters and digits
+func isAlphanumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkConflicts reports names that own a CNAME next to other data or more
+// than one CNAME (RFC 1034 section 3.6.2), including CNAMEs at a zone apex,
+// and names with more than one SOA. The records may come from several files.
+func checkConflicts(records []DNSRecord) []error {
+	byName := make(map[string][]DNSRecord)
+	var names []string
+	for _, record := range records {
+		if _, seen := byName[record.Name]; !seen {
+			names = append(names, record.Name)
+		}
+		byName[record.Name] = append(byName[record.Name], record)
+	}
+
+	var errs []error
+	for _, name := range names {
+		var cnames, soas, others []DNSRecord
+		for _, record := range byName[name] {
+			switch record.Type {
+			case "CNAME":
+				cnames = append(cnames, record)
+			case "SOA":
+				soas = append(soas, record)
+				fallthrough
+			default:
+				others = append(others, record)
+			}
+		}
+		for _, extra := range soas[min(1, len(soas)):] {
+			errs = append(errs, atLine(extra, fmt.Errorf("%s: more than one SOA record%s", name, lineRef(soas[0], extra))))
+		}
+		if len(cnames) == 0 {
+			continue
+		}
+
+		for _, extra := range cnames[1:] {
+			errs = append(errs, atLine(extra, fmt.Errorf("%s: more than one CNAME record%s", name, lineRef(cnames[0], extra))))
+		}
+		if len(soas) > 0 {
+			errs = append(errs, atLine(cnames[0], fmt.Errorf("%s: CNAME at zone apex", name)))
+		} else if len(others) > 0 {
+			errs = append(errs, atLine(cnames[0], fmt.Errorf("%s: CNAME and other data (%s record%s)", name, others[0].Type, lineRef(others[0], cnames[0]))))
+		}
+	}
+	return errs
+}
+
+// lineRef describes where another record involved in an error about record
+// is, if known. The file is only named if it is a different one.
+func lineRef(other, record DNSRecord) string {
+	ref := ""
+	if other.Line != 0 {
+		ref += fmt.Sprintf(" on line %d", other.Line)
+	}
+	if other.File != record.File {
+		ref += " of " + other.File
+	}
+	return ref
+}
+
+// checkRecords implements -check: it loads and validates the records from
+// source, writes every problem found to out and returns the exit status
+func checkRecords(load recordLoader, source string, out io.Writer) int {
+	store, err := buildRecordStore(load)
+	if err != nil {
+		// Each problem names the file it was found in
+		problems := flattenErrors(err)
+		for _, problem := range problems {
+			fmt.Fprintln(out, problem)
+		}
+		fmt.Fprintf(out, "%s: %d errors\n", source, len(problems))
+		return 1
+	}
+	fmt.Fprintf(out, "%s: %d records OK\n", source, store.count())
+	return 0
+}
+
+// flattenErrors lists the errors joined into err with errors.Join
+func flattenErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var result []error
+	for _, e := range joined.Unwrap() {
+		result = append(result, flattenErrors(e)...)
+	}
+	return result
+}
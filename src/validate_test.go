@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestValidateRecordValue tests the per-type value checks
+func TestValidateRecordValue(t *testing.T) {
+	sha256 := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		record  DNSRecord
+		wantErr string
+	}{
+		{"A", DNSRecord{Type: "A", Value: "192.0.2.1"}, ""},
+		{"A with IPv6 address", DNSRecord{Type: "A", Value: "2001:db8::1"}, "invalid IPv4 address"},
+		{"A with IPv4-mapped address", DNSRecord{Type: "A", Value: "::ffff:192.0.2.1"}, "invalid IPv4 address"},
+		{"A not an IP", DNSRecord{Type: "A", Value: "not-an-ip"}, "invalid IPv4 address"},
+		{"AAAA", DNSRecord{Type: "AAAA", Value: "2001:db8::1"}, ""},
+		{"AAAA with IPv4 address", DNSRecord{Type: "AAAA", Value: "192.0.2.1"}, "invalid IPv6 address"},
+		{"CNAME", DNSRecord{Type: "CNAME", Value: "target.example.net"}, ""},
+		{"CNAME to an IP", DNSRecord{Type: "CNAME", Value: "192.0.2.1"}, "not an IP address"},
+		{"NS empty", DNSRecord{Type: "NS", Value: ""}, "invalid target name"},
+		{"Null MX", DNSRecord{Type: "MX", Value: "."}, ""},
+		{"SOA", DNSRecord{Type: "SOA", Value: "ns1.example.com. admin.example.com. 1 3600 600 86400 300"}, ""},
+		{"SOA missing fields", DNSRecord{Type: "SOA", Value: "ns1.example.com. admin.example.com. 1"}, "SOA needs 7 fields"},
+		{"SOA bad serial", DNSRecord{Type: "SOA", Value: "ns1.example.com. admin.example.com. x 3600 600 86400 300"}, "invalid SOA serial"},
+		{"CAA", DNSRecord{Type: "CAA", Value: `0 issue "letsencrypt.org"`}, ""},
+		{"CAA critical flag", DNSRecord{Type: "CAA", Value: "128 issue letsencrypt.org"}, ""},
+		{"CAA flag out of range", DNSRecord{Type: "CAA", Value: "256 issue letsencrypt.org"}, "invalid CAA flags"},
+		{"CAA bad tag", DNSRecord{Type: "CAA", Value: "0 is-sue letsencrypt.org"}, "invalid CAA tag"},
+		{"CAA missing value", DNSRecord{Type: "CAA", Value: "0 issue"}, "CAA needs flag, tag and value"},
+		{"TLSA SHA-256", DNSRecord{Type: "TLSA", Value: "3 1 1 " + sha256}, ""},
+		{"TLSA full certificate", DNSRecord{Type: "TLSA", Value: "3 0 0 3082"}, ""},
+		{"TLSA not hex", DNSRecord{Type: "TLSA", Value: "3 1 1 xyz"}, "must be hex"},
+		{"TLSA wrong digest length", DNSRecord{Type: "TLSA", Value: "3 1 2 " + sha256}, "needs 64"},
+		{"TLSA bad usage", DNSRecord{Type: "TLSA", Value: "x 1 1 " + sha256}, "invalid TLSA usage"},
+		{"SSHFP SHA-256", DNSRecord{Type: "SSHFP", Value: "4 2 " + sha256}, ""},
+		{"SSHFP SHA-1", DNSRecord{Type: "SSHFP", Value: "1 1 " + strings.Repeat("0f", 20)}, ""},
+		{"SSHFP wrong digest length", DNSRecord{Type: "SSHFP", Value: "4 1 " + sha256}, "needs 20"},
+		{"SSHFP missing fingerprint", DNSRecord{Type: "SSHFP", Value: "4 2"}, "SSHFP needs"},
+		{"HINFO missing OS", DNSRecord{Type: "HINFO", Value: "x86"}, "invalid value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.record.Name = "example.com"
+			err := validateRecordValue(tt.record)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestStrictCSVValidation tests that every problem in a CSV file is reported with its line
+func TestStrictCSVValidation(t *testing.T) {
+	tmpFile, err := createTempCSV(`name,type,value,ttl,priority,weight,port
+example.com,A,not-an-ip,3600,,,
+example.com,SOA,ns1.example.com. admin.example.com. 1 2 3,3600,,,
+example.com,CNAME,other.example.net,,,,
+www.example.com,CNAME,example.com,,,,
+
+www.example.com,TXT,hello,,,,
+example.com,CAA,256 issue letsencrypt.org,,,,
+host.example.com,SSHFP,1 1 abcd,,,,
+bad.example.com,BOGUS,x,,,,
+alias.example.com,CNAME,a.example.net,,,,
+alias.example.com,CNAME,b.example.net,,,,
+bad*.example.com,A,192.0.2.1,,,,
+ok.example.com,A,192.0.2.1,,,,`)
+	if err != nil {
+		t.Fatalf("Failed to create temp CSV: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = loadRecordsFromCSV(tmpFile.Name())
+	if err == nil {
+		t.Fatal("Expected loading to fail")
+	}
+
+	want := []string{
+		"line 2: example.com A: invalid IPv4 address 'not-an-ip'",
+		"line 3: example.com SOA: SOA needs 7 fields",
+		"line 4: example.com: CNAME at zone apex",
+		"line 5: www.example.com: CNAME and other data (TXT record on line 7)",
+		"line 8: example.com CAA: invalid CAA flags '256'",
+		"line 9: host.example.com SSHFP: SSHFP fingerprint is 2 bytes",
+		"line 10: invalid record type 'BOGUS'",
+		"line 12: alias.example.com: more than one CNAME record on line 11",
+		"line 13: bad*.example.com: invalid domain name",
+	}
+	for _, w := range want {
+		if !strings.Contains(err.Error(), w) {
+			t.Errorf("Expected error containing %q, got:\n%v", w, err)
+		}
+	}
+	if problems := flattenErrors(err); len(problems) != len(want) {
+		t.Errorf("Expected %d problems, got %d:\n%v", len(want), len(problems), err)
+	}
+}
+
+// TestCheckRecords tests the -check mode
+func TestCheckRecords(t *testing.T) {
+	good, err := createTempCSV("name,type,value\nexample.com,A,192.0.2.1\nwww.example.com,CNAME,example.com")
+	if err != nil {
+		t.Fatalf("Failed to create temp CSV: %v", err)
+	}
+	defer os.Remove(good.Name())
+	bad, err := createTempCSV("name,type,value\nexample.com,A,192.0.2.256\nexample.com,AAAA,2001:db8::zz")
+	if err != nil {
+		t.Fatalf("Failed to create temp CSV: %v", err)
+	}
+	defer os.Remove(bad.Name())
+
+	var out bytes.Buffer
+	load := func() (*RecordStore, error) { return loadRecordsFromCSV(good.Name()) }
+	if status := checkRecords(load, "good.csv", &out); status != 0 {
+		t.Errorf("Expected exit status 0, got %d: %s", status, out.String())
+	}
+	if !strings.Contains(out.String(), "good.csv: 2 records OK") {
+		t.Errorf("Unexpected output: %s", out.String())
+	}
+
+	out.Reset()
+	load = func() (*RecordStore, error) { return loadRecordsFromCSV(bad.Name()) }
+	if status := checkRecords(load, "bad.csv", &out); status != 1 {
+		t.Errorf("Expected exit status 1, got %d", status)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], bad.Name()+": line 2:") ||
+		!strings.HasPrefix(lines[1], bad.Name()+": line 3:") || lines[2] != "bad.csv: 2 errors" {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+}
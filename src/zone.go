@@ -0,0 +1,197 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// nameState describes how a domain name exists in a record backend
+type nameState int
+
+const (
+	nameMissing          nameState = iota // The name does not exist (NXDOMAIN)
+	nameOwnsRecords                       // The name owns records
+	nameEmptyNonTerminal                  // The name owns no records but has descendants that do
+	nameWildcard                          // The name is synthesised from a wildcard
+)
+
+// storeIndex holds lookup structures derived from the records of a RecordStore
+type storeIndex struct {
+	nonTerminals map[string]bool             // Names that have descendants owning records
+	names        map[string]*compiledRecords // Compiled records by owner name
+	wildcards    map[string]*compiledRecords // Compiled wildcard records by parent domain
+}
+
+// getIndex returns the store's index, building it and compiling the records on
+// first use. Callers must hold store.mu for reading.
+func (store *RecordStore) getIndex() *storeIndex {
+	store.indexOnce.Do(func() {
+		index := &storeIndex{
+			nonTerminals: make(map[string]bool),
+			names:        make(map[string]*compiledRecords),
+			wildcards:    make(map[string]*compiledRecords),
+		}
+
+		addAncestors := func(name string) {
+			for i, c := range name {
+				if c == '.' {
+					index.nonTerminals[name[i+1:]] = true
+				}
+			}
+		}
+		for name, records := range store.Records {
+			addAncestors(name)
+			index.names[name] = compileRecords(name, records)
+		}
+		for domain, records := range store.WildRecords {
+			addAncestors("*." + domain)
+			index.wildcards[domain] = compileRecords("*."+domain, records)
+		}
+
+		store.index = index
+	})
+	return store.index
+}
+
+// lookupName reports whether name exists in the store, taking empty
+// non-terminals and wildcards into account
+func (store *RecordStore) lookupName(name string) nameState {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	if len(store.Records[name]) > 0 {
+		return nameOwnsRecords
+	}
+	// A query for the wildcard owner itself matches it literally
+	if strings.HasPrefix(name, "*.") && len(store.WildRecords[name[2:]]) > 0 {
+		return nameOwnsRecords
+	}
+	if store.getIndex().nonTerminals[name] {
+		return nameEmptyNonTerminal
+	}
+	if _, records := store.findWildcard(name); len(records) > 0 {
+		return nameWildcard
+	}
+
+	return nameMissing
+}
+
+// exists reports whether name is a node of the store's name tree: it owns
+// records (wildcard owners included) or is an empty non-terminal. Callers must
+// hold store.mu for reading.
+func (store *RecordStore) exists(name string) bool {
+	if len(store.Records[name]) > 0 {
+		return true
+	}
+	if strings.HasPrefix(name, "*.") && len(store.WildRecords[name[2:]]) > 0 {
+		return true
+	}
+	return store.getIndex().nonTerminals[name]
+}
+
+// findWildcard returns the closest encloser of a name that does not exist in
+// the store, and the records of the wildcard "*.<closest encloser>" that
+// synthesise it (RFC 4592 section 3.3.1). Wildcards are never applied across
+// a zone cut. Callers must hold store.mu for reading.
+func (store *RecordStore) findWildcard(name string) (string, []DNSRecord) {
+	labels := strings.Split(name, ".")
+	for i := 1; i < len(labels); i++ {
+		encloser := strings.Join(labels[i:], ".")
+		if !store.exists(encloser) {
+			continue
+		}
+		// Data below a delegation belongs to the child zone
+		if store.isZoneCut(encloser) {
+			return encloser, nil
+		}
+		return encloser, store.WildRecords[encloser]
+	}
+	return "", nil
+}
+
+// isZoneCut reports whether name is a delegation point: it owns NS records
+// but no SOA and lies inside a zone. Callers must hold store.mu for reading.
+func (store *RecordStore) isZoneCut(name string) bool {
+	hasNS := false
+	for _, record := range store.Records[name] {
+		switch record.Type {
+		case "SOA":
+			return false
+		case "NS":
+			hasNS = true
+		}
+	}
+	if !hasNS {
+		return false
+	}
+
+	labels := strings.Split(name, ".")
+	for i := 1; i < len(labels); i++ {
+		for _, record := range store.Records[strings.Join(labels[i:], ".")] {
+			if record.Type == "SOA" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setNegativeResponse completes the response for a question that produced no
+// answers. Names inside a zone get the zone's SOA in the authority section,
+// with NXDOMAIN if the name does not exist and NOERROR (NODATA) if it exists
+// with other types. Names outside every zone get an empty NOERROR if they are
+// in a reflection domain and REFUSED otherwise.
+func setNegativeResponse(msg *dns.Msg, store RecordBackend, q dns.Question) {
+	qname := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+
+	if store != nil {
+		if soa := store.findSOA(qname); soa != nil {
+			// RFC 2308: the negative TTL is the lesser of the SOA TTL and MINIMUM
+			negative := dns.Copy(soa).(*dns.SOA)
+			if negative.Minttl < negative.Hdr.Ttl {
+				negative.Hdr.Ttl = negative.Minttl
+			}
+			msg.Ns = append(msg.Ns, negative)
+
+			if store.lookupName(qname) == nameMissing && !isReflectionName(qname) {
+				msg.Rcode = dns.RcodeNameError
+			}
+			return
+		}
+
+		// Records outside any zone are still served, just without negative caching data
+		if store.lookupName(qname) != nameMissing {
+			return
+		}
+	}
+
+	// Without -reflect-domains every name is a possible reflection name, so
+	// nothing is refused; the empty answer has no zone behind it and is not
+	// authoritative
+	if inReflectionDomain(qname) {
+		msg.Authoritative = false
+		return
+	}
+
+	if config.VerboseLogging {
+		log.Printf("Refusing query for %s outside configured zones", q.Name)
+	}
+	msg.Rcode = dns.RcodeRefused
+	msg.Authoritative = false
+}
+
+// splitDomainList parses a comma-separated list of domain names
+func splitDomainList(list string) []string {
+	var domains []string
+	for _, domain := range strings.Split(list, ",") {
+		domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// newZoneTestStore builds a small zone without wildcards for negative answer tests
+func newZoneTestStore() *RecordStore {
+	store := newRecordStore()
+	for _, record := range []DNSRecord{
+		{Name: "test.org", Type: "SOA", Value: "ns1.test.org. admin.test.org. 2025050801 3600 1800 604800 300", TTL: 3600},
+		{Name: "test.org", Type: "NS", Value: "ns1.test.org", TTL: 3600},
+		{Name: "test.org", Type: "A", Value: "192.0.2.1", TTL: 3600},
+		{Name: "ns1.test.org", Type: "A", Value: "192.0.2.53", TTL: 3600},
+		{Name: "_sip._tcp.test.org", Type: "SRV", Value: "sip.test.org", TTL: 3600, Priority: 10, Weight: 20, Port: 5060},
+		{Name: "*.wild.test.org", Type: "A", Value: "192.0.2.2", TTL: 3600},
+		{Name: "outside.net", Type: "A", Value: "192.0.2.3", TTL: 3600},
+	} {
+		store.addRecord(record)
+	}
+	return store
+}
+
+// TestLookupName tests name existence including empty non-terminals and wildcards
+func TestLookupName(t *testing.T) {
+	store := newZoneTestStore()
+
+	tests := []struct {
+		name string
+		want nameState
+	}{
+		{"test.org", nameOwnsRecords},
+		{"NS1.test.org.", nameOwnsRecords},
+		{"_sip._tcp.test.org", nameOwnsRecords},
+		{"_tcp.test.org", nameEmptyNonTerminal},
+		{"wild.test.org", nameEmptyNonTerminal},
+		{"*.wild.test.org", nameOwnsRecords},
+		{"anything.wild.test.org", nameWildcard},
+		{"missing.test.org", nameMissing},
+		{"_udp.test.org", nameMissing},
+	}
+
+	for _, tt := range tests {
+		if got := store.lookupName(tt.name); got != tt.want {
+			t.Errorf("lookupName(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Adding a record must invalidate the non-terminal index
+	store.addRecord(DNSRecord{Name: "host._udp.test.org", Type: "A", Value: "192.0.2.4"})
+	if got := store.lookupName("_udp.test.org"); got != nameEmptyNonTerminal {
+		t.Errorf("lookupName(_udp.test.org) after add = %d, want %d", got, nameEmptyNonTerminal)
+	}
+}
+
+// TestNegativeResponses tests NXDOMAIN, NODATA and REFUSED handling
+func TestNegativeResponses(t *testing.T) {
+	suite := setupTestSuite()
+	defer suite.teardown()
+
+	recordStore = newZoneTestStore()
+
+	tests := []struct {
+		name           string
+		qname          string
+		qtype          uint16
+		reflectDomains []string
+		wantRcode      int
+		wantAnswers    int
+		wantSOA        bool
+		wantAA         bool
+	}{
+		{
+			name:      "NODATA for existing name with other types",
+			qname:     "test.org.",
+			qtype:     dns.TypeAAAA,
+			wantRcode: dns.RcodeSuccess,
+			wantSOA:   true,
+			wantAA:    true,
+		},
+		{
+			name:      "NODATA for empty non-terminal",
+			qname:     "_tcp.test.org.",
+			qtype:     dns.TypeSRV,
+			wantRcode: dns.RcodeSuccess,
+			wantSOA:   true,
+			wantAA:    true,
+		},
+		{
+			name:      "NODATA for wildcard name with other types",
+			qname:     "host.wild.test.org.",
+			qtype:     dns.TypeTXT,
+			wantRcode: dns.RcodeSuccess,
+			wantSOA:   true,
+			wantAA:    true,
+		},
+		{
+			name:      "NXDOMAIN for missing name in zone",
+			qname:     "missing.test.org.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeNameError,
+			wantSOA:   true,
+			wantAA:    true,
+		},
+		{
+			name:      "NODATA for reflection name in zone",
+			qname:     "10.0.0.1.test.org.",
+			qtype:     dns.TypeTXT,
+			wantRcode: dns.RcodeSuccess,
+			wantSOA:   true,
+			wantAA:    true,
+		},
+		{
+			name:           "REFUSED outside zones and reflection domains",
+			qname:          "unknown.example.",
+			qtype:          dns.TypeA,
+			reflectDomains: []string{"2dns.dev"},
+			wantRcode:      dns.RcodeRefused,
+		},
+		{
+			name:           "Records outside zones are still served",
+			qname:          "outside.net.",
+			qtype:          dns.TypeA,
+			reflectDomains: []string{"2dns.dev"},
+			wantRcode:      dns.RcodeSuccess,
+			wantAnswers:    1,
+			wantAA:         true,
+		},
+		{
+			name:           "Reflection inside reflection domain",
+			qname:          "10.0.0.1.2dns.dev.",
+			qtype:          dns.TypeA,
+			reflectDomains: []string{"2dns.dev"},
+			wantRcode:      dns.RcodeSuccess,
+			wantAnswers:    1,
+			wantAA:         true,
+		},
+		{
+			name:           "No reflection outside reflection domains",
+			qname:          "10.0.0.1.other.dev.",
+			qtype:          dns.TypeA,
+			reflectDomains: []string{"2dns.dev"},
+			wantRcode:      dns.RcodeRefused,
+		},
+		{
+			name:      "Non-authoritative empty NOERROR for unparsable name without reflection domains",
+			qname:     "unknown.example.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+		},
+		{
+			name:           "Non-authoritative NODATA for reflection name without a zone",
+			qname:          "10.0.0.1.2dns.dev.",
+			qtype:          dns.TypeMX,
+			reflectDomains: []string{"2dns.dev"},
+			wantRcode:      dns.RcodeSuccess,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.ReflectDomains = tt.reflectDomains
+			defer func() { config.ReflectDomains = nil }()
+
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, tt.qtype)
+
+			w := newMockResponseWriter()
+			handleDNSRequest(w, req)
+
+			if w.msg.Rcode != tt.wantRcode {
+				t.Errorf("Expected rcode %s, got %s", dns.RcodeToString[tt.wantRcode], dns.RcodeToString[w.msg.Rcode])
+			}
+			if len(w.msg.Answer) != tt.wantAnswers {
+				t.Errorf("Expected %d answers, got %d", tt.wantAnswers, len(w.msg.Answer))
+			}
+			if w.msg.Authoritative != tt.wantAA {
+				t.Errorf("Expected AA=%v, got %v", tt.wantAA, w.msg.Authoritative)
+			}
+
+			hasSOA := len(w.msg.Ns) == 1 && w.msg.Ns[0].Header().Rrtype == dns.TypeSOA
+			if hasSOA != tt.wantSOA {
+				t.Errorf("Expected SOA in authority=%v, got %v", tt.wantSOA, w.msg.Ns)
+			}
+			if hasSOA && w.msg.Ns[0].Header().Ttl != 300 {
+				t.Errorf("Expected negative TTL 300 (SOA minimum), got %d", w.msg.Ns[0].Header().Ttl)
+			}
+		})
+	}
+}
+
+// TestWildcardMatching tests wildcard synthesis against the examples of RFC 4592 section 2.2.1
+func TestWildcardMatching(t *testing.T) {
+	store := newRecordStore()
+	for _, record := range []DNSRecord{
+		{Name: "example", Type: "SOA", Value: "ns.example.com. admin.example. 1 3600 1800 604800 300", TTL: 3600},
+		{Name: "example", Type: "NS", Value: "ns.example.com", TTL: 3600},
+		{Name: "*.example", Type: "TXT", Value: "this is a wildcard", TTL: 3600},
+		{Name: "*.example", Type: "MX", Value: "host1.example", TTL: 3600, Priority: 10},
+		{Name: "sub.*.example", Type: "TXT", Value: "this is not a wildcard", TTL: 3600},
+		{Name: "host1.example", Type: "A", Value: "192.0.2.1", TTL: 3600},
+		{Name: "_ssh._tcp.host1.example", Type: "SRV", Value: "host1.example", TTL: 3600, Port: 22},
+		{Name: "_ssh._tcp.host2.example", Type: "SRV", Value: "host2.example", TTL: 3600, Port: 22},
+		{Name: "subdel.example", Type: "NS", Value: "ns.example.com", TTL: 3600},
+		{Name: "*.subdel.example", Type: "A", Value: "192.0.2.99", TTL: 3600},
+		{Name: "*.alias.example", Type: "CNAME", Value: "host1.example", TTL: 3600},
+	} {
+		store.addRecord(record)
+	}
+
+	tests := []struct {
+		qname     string
+		qtype     uint16
+		wantType  uint16
+		wantCount int
+		wantState nameState
+	}{
+		// Synthesised from *.example
+		{"host3.example", dns.TypeMX, dns.TypeMX, 1, nameWildcard},
+		{"foo.bar.example", dns.TypeTXT, dns.TypeTXT, 1, nameWildcard},
+		// Wildcard exists but has no A record: NODATA
+		{"host3.example", dns.TypeA, 0, 0, nameWildcard},
+		// Existing names are never matched by the wildcard
+		{"host1.example", dns.TypeMX, 0, 0, nameOwnsRecords},
+		{"sub.*.example", dns.TypeMX, 0, 0, nameOwnsRecords},
+		// The closest encloser _tcp.host1.example has no wildcard: NXDOMAIN
+		{"_telnet._tcp.host1.example", dns.TypeSRV, 0, 0, nameMissing},
+		// The closest encloser *.example has no "*.*.example" wildcard
+		{"ghost.*.example", dns.TypeMX, 0, 0, nameMissing},
+		// Wildcards are not applied below a delegation
+		{"host.subdel.example", dns.TypeA, 0, 0, nameMissing},
+		// Wildcard CNAMEs answer every query type
+		{"www.alias.example", dns.TypeA, dns.TypeCNAME, 1, nameWildcard},
+		{"www.alias.example", dns.TypeMX, dns.TypeCNAME, 1, nameWildcard},
+		// Literal query for the wildcard owner
+		{"*.example", dns.TypeTXT, dns.TypeTXT, 1, nameOwnsRecords},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qname+"/"+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			records := store.lookupRecord(tt.qname, tt.qtype)
+			if len(records) != tt.wantCount {
+				t.Fatalf("Expected %d records, got %d: %v", tt.wantCount, len(records), records)
+			}
+			if tt.wantCount > 0 {
+				if records[0].Header().Rrtype != tt.wantType {
+					t.Errorf("Expected type %s, got %s", dns.TypeToString[tt.wantType], dns.TypeToString[records[0].Header().Rrtype])
+				}
+				if records[0].Header().Name != dns.Fqdn(tt.qname) {
+					t.Errorf("Expected owner %s, got %s", dns.Fqdn(tt.qname), records[0].Header().Name)
+				}
+			}
+			if state := store.lookupName(tt.qname); state != tt.wantState {
+				t.Errorf("Expected name state %d, got %d", tt.wantState, state)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// noZoneTTL is the TTL the zone parser gives records without one
+const noZoneTTL = math.MaxUint32
+
+// loadRecordsFromZoneFile loads DNS records from an RFC 1035 master zone file.
+// $ORIGIN, $TTL and $INCLUDE directives and relative names are handled by the
+// miekg/dns zone parser; origin is used for relative names until the file sets
+// its own $ORIGIN. The store is not compiled, as its records are merged with
+// those of the other sources first.
+func loadRecordsFromZoneFile(filePath string, origin string) (*RecordStore, error) {
+	store := newRecordStore()
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zone file: %v", err)
+	}
+	defer file.Close()
+
+	if origin == "" {
+		origin = "."
+	}
+
+	zp := dns.NewZoneParser(file, dns.Fqdn(origin), filePath)
+	zp.SetIncludeAllowed(true)
+	// Records without a TTL and no $TTL directive get the server's default TTL,
+	// just like an empty ttl column in the CSV format. They are marked with a
+	// TTL no record can have (RFC 2181 section 8), as an explicit TTL of 0 must
+	// stay 0.
+	zp.SetDefaultTTL(noZoneTTL)
+
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		record, err := recordFromRR(rr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filePath, err)
+		}
+		if rr.Header().Ttl == noZoneTTL {
+			record.TTL, record.ZeroTTL = 0, false
+		}
+		record.File = filePath
+		store.addRecord(record)
+	}
+
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse zone file: %v", err)
+	}
+
+	return store, nil
+}
+
+// recordFromRR converts a parsed resource record into the DNSRecord form used
+// by the CSV loader, so both sources produce identical store contents
+func recordFromRR(rr dns.RR) (DNSRecord, error) {
+	hdr := rr.Header()
+	name := strings.ToLower(strings.TrimSuffix(hdr.Name, "."))
+
+	if hdr.Class != dns.ClassINET {
+		return DNSRecord{}, fmt.Errorf("%s: unsupported class %s", hdr.Name, dns.ClassToString[hdr.Class])
+	}
+
+	recordType := dns.TypeToString[hdr.Rrtype]
+	if !isValidRecordType(recordType) {
+		return DNSRecord{}, fmt.Errorf("%s: unsupported record type '%s'", hdr.Name, recordType)
+	}
+
+	record := DNSRecord{
+		Name:    name,
+		Type:    recordType,
+		TTL:     hdr.Ttl,
+		ZeroTTL: hdr.Ttl == 0,
+	}
+
+	// Names in record data are stored without the trailing dot, as in the CSV format
+	switch v := rr.(type) {
+	case *dns.CNAME:
+		record.Value = strings.TrimSuffix(v.Target, ".")
+	case *dns.DNAME:
+		record.Value = strings.TrimSuffix(v.Target, ".")
+	case *dns.NS:
+		record.Value = strings.TrimSuffix(v.Ns, ".")
+	case *dns.PTR:
+		record.Value = strings.TrimSuffix(v.Ptr, ".")
+	case *dns.MX:
+		record.Priority = v.Preference
+		record.Value = strings.TrimSuffix(v.Mx, ".")
+	case *dns.SRV:
+		record.Priority = v.Priority
+		record.Weight = v.Weight
+		record.Port = v.Port
+		record.Value = strings.TrimSuffix(v.Target, ".")
+	case *dns.TXT:
+		// Keeps the boundaries between character-strings, see formatTXT
+		record.Value = formatTXT(v.Txt)
+	case *dns.CAA:
+		record.Value = fmt.Sprintf("%d %s %s", v.Flag, v.Tag, quoteCharacterString(v.Value))
+	case *dns.HINFO:
+		record.Value = quoteCharacterString(v.Cpu) + " " + quoteCharacterString(v.Os)
+	default:
+		// The presentation format of the record data matches what createRR expects
+		record.Value = strings.TrimSpace(strings.TrimPrefix(rr.String(), hdr.String()))
+	}
+
+	return record, nil
+}
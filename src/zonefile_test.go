@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// TestLoadRecordsFromZoneFile tests loading a BIND-style zone file into the record store
+func TestLoadRecordsFromZoneFile(t *testing.T) {
+	dir := t.TempDir()
+
+	includeContent := `mail    IN A     192.168.1.5
+        IN MX    5 mail
+`
+	zoneContent := `$ORIGIN example.com.
+$TTL 300
+@       IN SOA   ns1 admin 2025050801 3600 1800 604800 86400
+@       IN NS    ns1
+@       IN NS    ns2.example.com.
+@       IN A     192.168.1.1
+@       IN AAAA  2001:db8::1
+@       IN MX    10 mail
+@       IN TXT   "This is a test record"
+@       IN CAA   0 issue "letsencrypt.org"
+www     IN CNAME @
+ns1     IN A     192.168.1.10
+ns2 60  IN A     192.168.1.11
+*       IN A     192.168.1.2
+_sip._tcp IN SRV 10 20 5060 sip
+$INCLUDE ` + filepath.Join(dir, "mail.zone") + `
+`
+	if err := os.WriteFile(filepath.Join(dir, "mail.zone"), []byte(includeContent), 0644); err != nil {
+		t.Fatalf("Failed to write include file: %v", err)
+	}
+	zonePath := filepath.Join(dir, "example.com.zone")
+	if err := os.WriteFile(zonePath, []byte(zoneContent), 0644); err != nil {
+		t.Fatalf("Failed to write zone file: %v", err)
+	}
+
+	store, err := loadRecordsFromZoneFile(zonePath, ".")
+	if err != nil {
+		t.Fatalf("Failed to load zone file: %v", err)
+	}
+
+	t.Run("Records are stored like CSV records", func(t *testing.T) {
+		if len(store.Records["example.com"]) != 8 {
+			t.Errorf("Expected 8 records for example.com, got %d", len(store.Records["example.com"]))
+		}
+		if len(store.WildRecords["example.com"]) != 1 {
+			t.Errorf("Expected 1 wildcard record for example.com, got %d", len(store.WildRecords["example.com"]))
+		}
+
+		www := store.Records["www.example.com"]
+		if len(www) != 1 || www[0].Type != "CNAME" || www[0].Value != "example.com" {
+			t.Errorf("Expected www.example.com CNAME example.com, got %+v", www)
+		}
+
+		srv := store.Records["_sip._tcp.example.com"]
+		if len(srv) != 1 || srv[0].Priority != 10 || srv[0].Weight != 20 || srv[0].Port != 5060 || srv[0].Value != "sip.example.com" {
+			t.Errorf("Unexpected SRV record: %+v", srv)
+		}
+	})
+
+	t.Run("TTL directives", func(t *testing.T) {
+		if ttl := store.Records["ns1.example.com"][0].TTL; ttl != 300 {
+			t.Errorf("Expected $TTL 300 for ns1.example.com, got %d", ttl)
+		}
+		if ttl := store.Records["ns2.example.com"][0].TTL; ttl != 60 {
+			t.Errorf("Expected explicit TTL 60 for ns2.example.com, got %d", ttl)
+		}
+	})
+
+	t.Run("Included records", func(t *testing.T) {
+		mail := store.Records["mail.example.com"]
+		if len(mail) != 2 {
+			t.Fatalf("Expected 2 records for mail.example.com, got %d", len(mail))
+		}
+		if mail[1].Type != "MX" || mail[1].Priority != 5 || mail[1].Value != "mail.example.com" {
+			t.Errorf("Unexpected included MX record: %+v", mail[1])
+		}
+	})
+
+	t.Run("Lookups work on zone file data", func(t *testing.T) {
+		tests := []struct {
+			qname string
+			qtype uint16
+			want  string
+		}{
+			{"example.com", dns.TypeSOA, "ns1.example.com."},
+			{"example.com", dns.TypeMX, "mail.example.com."},
+			{"example.com", dns.TypeCAA, "letsencrypt.org"},
+			{"anything.example.com", dns.TypeA, "192.168.1.2"},
+		}
+
+		for _, tt := range tests {
+			records := store.lookupRecord(tt.qname, tt.qtype)
+			if len(records) != 1 {
+				t.Errorf("%s %s: expected 1 record, got %d", tt.qname, dns.TypeToString[tt.qtype], len(records))
+				continue
+			}
+			if !strings.Contains(records[0].String(), tt.want) {
+				t.Errorf("%s %s: expected %q in %s", tt.qname, dns.TypeToString[tt.qtype], tt.want, records[0].String())
+			}
+		}
+	})
+
+	t.Run("Explicit zero TTL", func(t *testing.T) {
+		originalConfig := config
+		defer func() { config = originalConfig }()
+		config.TTL = 3600
+
+		path := filepath.Join(dir, "zero.zone")
+		content := "$ORIGIN example.net.\n@ IN A 10.0.0.1\nzero 0 IN A 10.0.0.2\nafter IN A 10.0.0.3\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write zone file: %v", err)
+		}
+
+		store, err := loadRecordsFromZoneFile(path, ".")
+		if err != nil {
+			t.Fatalf("Failed to load zone file: %v", err)
+		}
+
+		// Without $TTL, a record without a TTL takes the previous record's (RFC 1035)
+		tests := []struct {
+			name string
+			want uint32
+		}{
+			{"example.net", 3600},
+			{"zero.example.net", 0},
+			{"after.example.net", 0},
+		}
+		for _, tt := range tests {
+			records := store.lookupRecord(tt.name, dns.TypeA)
+			if len(records) != 1 || records[0].Header().Ttl != tt.want {
+				t.Errorf("%s: expected TTL %d, got %v", tt.name, tt.want, records)
+			}
+		}
+	})
+
+	t.Run("Origin flag for relative names", func(t *testing.T) {
+		path := filepath.Join(dir, "relative.zone")
+		if err := os.WriteFile(path, []byte("@ 3600 IN A 10.0.0.1\nhost 3600 IN A 10.0.0.2\n"), 0644); err != nil {
+			t.Fatalf("Failed to write zone file: %v", err)
+		}
+
+		store, err := loadRecordsFromZoneFile(path, "example.org")
+		if err != nil {
+			t.Fatalf("Failed to load zone file: %v", err)
+		}
+		if len(store.Records["example.org"]) != 1 || len(store.Records["host.example.org"]) != 1 {
+			t.Errorf("Expected relative names under example.org, got %v", store.Records)
+		}
+	})
+
+	t.Run("Invalid zone files", func(t *testing.T) {
+		invalidZones := []struct {
+			name    string
+			content string
+			wantErr string
+		}{
+			{
+				name:    "Syntax error",
+				content: "$ORIGIN example.com.\n@ 3600 IN A not-an-ip\n",
+				wantErr: "failed to parse zone file",
+			},
+			{
+				name:    "Unsupported record type",
+				content: "$ORIGIN example.com.\n@ 3600 IN RP admin.example.com. .\n",
+				wantErr: "unsupported record type",
+			},
+		}
+
+		for _, tt := range invalidZones {
+			t.Run(tt.name, func(t *testing.T) {
+				path := filepath.Join(dir, "invalid.zone")
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatalf("Failed to write zone file: %v", err)
+				}
+
+				_, err := loadRecordsFromZoneFile(path, ".")
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing '%s', got %v", tt.wantErr, err)
+				}
+			})
+		}
+	})
+}